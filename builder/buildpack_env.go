@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultEnvAllowList names the platform variables of the executor
+// environment that are passed on to buildpack scripts. Entries ending in "*"
+// match by prefix. Everything else, such as registry credentials and signed
+// upload URLs, is removed before a buildpack runs. The app's environment and
+// the staging environment variable group are not taken from the executor
+// environment but added from the staging environment.
+var DefaultEnvAllowList = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"SHELL",
+	"HOSTNAME",
+	"PWD",
+	"TERM",
+	"TMPDIR",
+	"TZ",
+	"LANG",
+	"LANGUAGE",
+	"LC_*",
+	"SSL_CERT_DIR",
+	"SSL_CERT_FILE",
+	"http_proxy",
+	"https_proxy",
+	"no_proxy",
+	"HTTP_PROXY",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+}
+
+// scrubEnv keeps the variables in env that match allowList. It also returns
+// the sorted names of the variables it removed.
+func scrubEnv(env, allowList []string) (kept []string, removed []string) {
+	kept = []string{}
+	removed = []string{}
+
+	for _, kv := range env {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if envMatches(name, allowList) {
+			kept = append(kept, kv)
+
+			continue
+		}
+
+		removed = append(removed, name)
+	}
+
+	sort.Strings(removed)
+
+	return kept, removed
+}
+
+func envMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+
+			continue
+		}
+
+		if name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
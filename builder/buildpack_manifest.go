@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// buildpackManifest is the part of a buildpack's manifest.yml describing the
+// stack it targets and the dependencies it may install.
+type buildpackManifest struct {
+	Stack        string `yaml:"stack"`
+	Dependencies []struct {
+		Name     string   `yaml:"name"`
+		Version  string   `yaml:"version"`
+		CFStacks []string `yaml:"cf_stacks"`
+	} `yaml:"dependencies"`
+}
+
+// readBuildpackManifest returns an empty manifest for buildpacks without a
+// manifest.yml.
+func readBuildpackManifest(buildpackPath string) (buildpackManifest, error) {
+	manifest := buildpackManifest{}
+
+	contents, err := ioutil.ReadFile(filepath.Join(buildpackPath, "manifest.yml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+
+		return manifest, fmt.Errorf("failed to read manifest.yml: %w", err)
+	}
+
+	if err = yaml.Unmarshal(contents, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest.yml: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// stacks lists the stacks the buildpack supports: its own stack if it is
+// stack specific, otherwise every stack one of its dependencies is built
+// for. An empty list means the manifest does not say.
+func (m buildpackManifest) stacks() []string {
+	if m.Stack != "" {
+		return []string{m.Stack}
+	}
+
+	seen := map[string]bool{}
+	stacks := []string{}
+
+	for _, dependency := range m.Dependencies {
+		for _, stack := range dependency.CFStacks {
+			if !seen[stack] {
+				seen[stack] = true
+				stacks = append(stacks, stack)
+			}
+		}
+	}
+
+	return stacks
+}
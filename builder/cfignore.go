@@ -0,0 +1,217 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const CFIgnoreFile = ".cfignore"
+
+// defaultCFIgnorePatterns are the files the cf CLI never uploads, whether or
+// not the app has a .cfignore.
+var defaultCFIgnorePatterns = []string{
+	".cfignore",
+	"/manifest.yml",
+	".gitignore",
+	".git",
+	".hg",
+	".svn",
+	"_darcs",
+	".DS_Store",
+}
+
+// CFIgnore matches paths relative to the app root against .cfignore
+// patterns, which follow .gitignore syntax.
+type CFIgnore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ReadCFIgnore loads the .cfignore in appDir on top of the default patterns.
+// Apps without a .cfignore only get the defaults.
+func ReadCFIgnore(appDir string) (*CFIgnore, error) {
+	file, err := os.Open(filepath.Join(appDir, CFIgnoreFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ParseCFIgnore(strings.NewReader(""))
+		}
+
+		return nil, fmt.Errorf("failed to open .cfignore: %w", err)
+	}
+	defer file.Close()
+
+	return ParseCFIgnore(file)
+}
+
+// RemoveCFIgnored removes what the .cfignore in appDir excludes from the
+// app, as the cf CLI does before uploading it. It must run on the extracted
+// app bits before any buildpack, so that files created by buildpacks are
+// never removed.
+func RemoveCFIgnored(appDir string) (CopyStats, error) {
+	stats := CopyStats{}
+
+	ignore, err := ReadCFIgnore(appDir)
+	if err != nil {
+		return stats, err
+	}
+
+	err = filepath.Walk(appDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(appDir, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+
+		if relPath == "." || !ignore.Ignored(relPath, info.IsDir()) {
+			return nil
+		}
+
+		if err = stats.exclude(filePath, info); err != nil && err != filepath.SkipDir {
+			return err
+		}
+
+		if err = os.RemoveAll(filePath); err != nil {
+			return fmt.Errorf("failed to remove ignored %s: %w", filePath, err)
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.ExcludedFiles > 0 {
+		log.Printf("Excluded %d files (%d bytes) from the app based on .cfignore", stats.ExcludedFiles, stats.ExcludedBytes)
+	}
+
+	return stats, nil
+}
+
+func ParseCFIgnore(reader io.Reader) (*CFIgnore, error) {
+	ignore := &CFIgnore{}
+	for _, pattern := range defaultCFIgnorePatterns {
+		ignore.add(pattern)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		ignore.add(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read .cfignore: %w", err)
+	}
+
+	return ignore, nil
+}
+
+func (c *CFIgnore) add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	pattern := ignorePattern{}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.HasPrefix(line, "/") {
+		pattern.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		pattern.anchored = true
+	}
+
+	if line == "" {
+		return
+	}
+
+	pattern.segments = strings.Split(line, "/")
+	c.patterns = append(c.patterns, pattern)
+}
+
+// Ignored reports whether relPath should be left out. As with .gitignore the
+// last matching pattern wins, and nothing below an ignored directory can be
+// re-included.
+func (c *CFIgnore) Ignored(relPath string, isDir bool) bool {
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	ignored := false
+
+	for _, pattern := range c.patterns {
+		if pattern.matches(segments, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+
+	return ignored
+}
+
+func (p ignorePattern) matches(segments []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if !p.anchored {
+		matched, err := path.Match(p.segments[0], segments[len(segments)-1])
+
+		return err == nil && matched
+	}
+
+	return matchSegments(p.segments, segments)
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	matched, err := path.Match(pattern[0], segments[0])
+	if err != nil || !matched {
+		return false
+	}
+
+	return matchSegments(pattern[1:], segments[1:])
+}
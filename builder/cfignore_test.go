@@ -0,0 +1,129 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CFIgnore", func() {
+	var (
+		contents string
+		ignore   *builder.CFIgnore
+	)
+
+	BeforeEach(func() {
+		contents = ""
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ignore, err = builder.ParseCFIgnore(strings.NewReader(contents))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("ignores the cf CLI defaults", func() {
+		Expect(ignore.Ignored(".git", true)).To(BeTrue())
+		Expect(ignore.Ignored("vendor/lib/.svn", true)).To(BeTrue())
+		Expect(ignore.Ignored(".cfignore", false)).To(BeTrue())
+		Expect(ignore.Ignored("manifest.yml", false)).To(BeTrue())
+		Expect(ignore.Ignored("config/manifest.yml", false)).To(BeFalse())
+		Expect(ignore.Ignored("app.rb", false)).To(BeFalse())
+	})
+
+	When("the .cfignore has patterns", func() {
+		BeforeEach(func() {
+			contents = `
+# comments and blank lines are skipped
+
+*.log
+!important.log
+/build
+docs/
+assets/**/*.map
+`
+		})
+
+		It("matches unanchored patterns at any level", func() {
+			Expect(ignore.Ignored("debug.log", false)).To(BeTrue())
+			Expect(ignore.Ignored("log/debug.log", false)).To(BeTrue())
+		})
+
+		It("lets later negations re-include paths", func() {
+			Expect(ignore.Ignored("important.log", false)).To(BeFalse())
+		})
+
+		It("matches anchored patterns only at the app root", func() {
+			Expect(ignore.Ignored("build", true)).To(BeTrue())
+			Expect(ignore.Ignored("src/build", true)).To(BeFalse())
+		})
+
+		It("matches directory-only patterns only against directories", func() {
+			Expect(ignore.Ignored("docs", true)).To(BeTrue())
+			Expect(ignore.Ignored("docs", false)).To(BeFalse())
+		})
+
+		It("supports ** in patterns", func() {
+			Expect(ignore.Ignored("assets/app.js.map", false)).To(BeTrue())
+			Expect(ignore.Ignored("assets/js/vendor/app.js.map", false)).To(BeTrue())
+			Expect(ignore.Ignored("assets/js/app.js", false)).To(BeFalse())
+		})
+
+		It("ignores comments", func() {
+			Expect(ignore.Ignored("# comments and blank lines are skipped", false)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("RemoveCFIgnored", func() {
+	var (
+		appDir string
+		stats  builder.CopyStats
+		err    error
+	)
+
+	BeforeEach(func() {
+		tmpDir, tmpErr := ioutil.TempDir("", "cfignore")
+		Expect(tmpErr).NotTo(HaveOccurred())
+
+		appDir = filepath.Join(tmpDir, "app")
+		Expect(exec.Command("cp", "-a", filepath.Join("fixtures", "apps", "with-cfignore"), appDir).Run()).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(appDir, ".git"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		stats, err = builder.RemoveCFIgnored(appDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filepath.Dir(appDir))).To(Succeed())
+	})
+
+	It("removes ignored files from the app", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(appDir, "test")).NotTo(BeADirectory())
+		Expect(filepath.Join(appDir, "debug.log")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "tmp-cache")).NotTo(BeADirectory())
+		Expect(filepath.Join(appDir, ".git")).NotTo(BeADirectory())
+		Expect(filepath.Join(appDir, ".cfignore")).NotTo(BeAnExistingFile())
+	})
+
+	It("keeps everything else", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(appDir, "app.sh")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "keep.log")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "lib", "tmp-cache", "lib.rb")).To(BeAnExistingFile())
+	})
+
+	It("counts what was excluded", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.ExcludedFiles).To(Equal(5))
+	})
+})
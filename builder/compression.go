@@ -0,0 +1,258 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	CodecGzip = "gzip"
+	CodecPigz = "pigz"
+	CodecZstd = "zstd"
+
+	FormatGzip = "gzip"
+	FormatZstd = "zstd"
+
+	defaultZstdLevel = 3
+	maxGzipLevel     = 9
+	maxZstdLevel     = 19
+)
+
+// Compression describes how droplets and the build artifacts cache are
+// compressed. The zero value is gzip at the default level.
+type Compression struct {
+	Codec string
+	Level int
+}
+
+// ParseCompression parses a compression spec of the form "codec" or
+// "codec:level", e.g. "gzip:1", "pigz" or "zstd:19". An empty spec selects
+// gzip at the default level.
+func ParseCompression(spec string) (Compression, error) {
+	if spec == "" {
+		return Compression{Codec: CodecGzip}, nil
+	}
+
+	parts := strings.SplitN(spec, ":", 2)
+	compression := Compression{Codec: parts[0]}
+
+	var maxLevel int
+
+	switch compression.Codec {
+	case CodecGzip, CodecPigz:
+		maxLevel = maxGzipLevel
+	case CodecZstd:
+		maxLevel = maxZstdLevel
+	default:
+		return Compression{}, fmt.Errorf("unsupported compression codec %q", compression.Codec)
+	}
+
+	if len(parts) == 2 {
+		level, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return Compression{}, fmt.Errorf("invalid compression level %q: %w", parts[1], err)
+		}
+
+		if level < 1 || level > maxLevel {
+			return Compression{}, fmt.Errorf("compression level for %s must be between 1 and %d, got %d", compression.Codec, maxLevel, level)
+		}
+
+		compression.Level = level
+	}
+
+	return compression, nil
+}
+
+// Format is the on-disk format produced by the codec. Parallel gzip produces
+// plain gzip streams, so consumers only need to distinguish gzip and zstd.
+func (c Compression) Format() string {
+	if c.Codec == CodecZstd {
+		return FormatZstd
+	}
+
+	return FormatGzip
+}
+
+// MediaType is the content type of an artifact compressed with this codec.
+func (c Compression) MediaType() string {
+	if c.Format() == FormatZstd {
+		return "application/zstd"
+	}
+
+	return "application/gzip"
+}
+
+// Extension is the file extension of a tarball compressed with this codec.
+func (c Compression) Extension() string {
+	if c.Format() == FormatZstd {
+		return ".tar.zst"
+	}
+
+	return ".tgz"
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectFormat tells gzip and zstd archives apart by their magic number. It
+// returns an empty format for anything else.
+func DetectFormat(path string) (string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(zstdMagic))
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	switch header = header[:n]; {
+	case bytes.HasPrefix(header, zstdMagic):
+		return FormatZstd, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return FormatGzip, nil
+	default:
+		return "", nil
+	}
+}
+
+// ExtractArchive extracts a tarball written with any codec into dir, so
+// that a build artifacts cache can be restored whatever compression the
+// staging that wrote it used.
+func ExtractArchive(archive, dir string) error {
+	format, err := DetectFormat(archive)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+
+	switch format {
+	case FormatGzip:
+		args = []string{"-xzf", archive, "-C", dir}
+	case FormatZstd:
+		if _, err = exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("failed to find `zstd` in the path: %w", err)
+		}
+
+		args = []string{"-I", "zstd", "-xf", archive, "-C", dir}
+	default:
+		return fmt.Errorf("%s is neither a gzip nor a zstd archive", archive)
+	}
+
+	if output, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to extract %s: %w: %s", archive, err, output)
+	}
+
+	return nil
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return c.codec()
+	}
+
+	return fmt.Sprintf("%s:%d", c.codec(), c.Level)
+}
+
+func (c Compression) codec() string {
+	if c.Codec == "" {
+		return CodecGzip
+	}
+
+	return c.Codec
+}
+
+// writer wraps dest so that everything written to it is compressed. Closing
+// the returned writer flushes the compressed stream but does not close dest.
+func (c Compression) writer(dest io.Writer) (io.WriteCloser, error) {
+	switch c.codec() {
+	case CodecPigz:
+		pigzPath, err := exec.LookPath("pigz")
+		if err != nil {
+			log.Println("WARNING: `pigz` not found in the path, falling back to gzip")
+
+			return c.gzipWriter(dest)
+		}
+
+		// -n leaves the time out of the gzip header, like compress/gzip does
+		return startCompressor(dest, pigzPath, "-c", "-n", fmt.Sprintf("-%d", c.levelOr(gzip.DefaultCompression)))
+	case CodecZstd:
+		zstdPath, err := exec.LookPath("zstd")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find `zstd` in the path: %w", err)
+		}
+
+		return startCompressor(dest, zstdPath, "-q", "-c", fmt.Sprintf("-%d", c.levelOr(defaultZstdLevel)))
+	default:
+		return c.gzipWriter(dest)
+	}
+}
+
+func (c Compression) gzipWriter(dest io.Writer) (io.WriteCloser, error) {
+	gzipWriter, err := gzip.NewWriterLevel(dest, c.levelOr(gzip.DefaultCompression))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+
+	return gzipWriter, nil
+}
+
+func (c Compression) levelOr(defaultLevel int) int {
+	if c.Level == 0 {
+		return defaultLevel
+	}
+
+	return c.Level
+}
+
+type compressorProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func startCompressor(dest io.Writer, path string, args ...string) (io.WriteCloser, error) {
+	cmd := exec.Command(path, args...) // #nosec G204
+	cmd.Stdout = dest
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open compressor stdin: %w", err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start compressor %s: %w", path, err)
+	}
+
+	return &compressorProcess{cmd: cmd, stdin: stdin}, nil
+}
+
+func (p *compressorProcess) Write(data []byte) (int, error) {
+	return p.stdin.Write(data)
+}
+
+func (p *compressorProcess) Close() error {
+	if err := p.stdin.Close(); err != nil {
+		return fmt.Errorf("failed to close compressor stdin: %w", err)
+	}
+
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("compressor failed: %w", err)
+	}
+
+	return nil
+}
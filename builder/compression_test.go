@@ -0,0 +1,110 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	Describe("ParseCompression", func() {
+		It("defaults to gzip for an empty spec", func() {
+			compression, err := builder.ParseCompression("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compression).To(Equal(builder.Compression{Codec: "gzip"}))
+		})
+
+		It("parses a codec with a level", func() {
+			compression, err := builder.ParseCompression("gzip:1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compression).To(Equal(builder.Compression{Codec: "gzip", Level: 1}))
+		})
+
+		It("parses parallel gzip", func() {
+			compression, err := builder.ParseCompression("pigz:6")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compression).To(Equal(builder.Compression{Codec: "pigz", Level: 6}))
+		})
+
+		It("parses zstd", func() {
+			compression, err := builder.ParseCompression("zstd:19")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compression).To(Equal(builder.Compression{Codec: "zstd", Level: 19}))
+		})
+
+		It("rejects unknown codecs", func() {
+			_, err := builder.ParseCompression("bzip2")
+			Expect(err).To(MatchError(`unsupported compression codec "bzip2"`))
+		})
+
+		It("rejects non-numeric levels", func() {
+			_, err := builder.ParseCompression("gzip:fast")
+			Expect(err).To(MatchError(ContainSubstring(`invalid compression level "fast"`)))
+		})
+
+		It("rejects levels out of range", func() {
+			_, err := builder.ParseCompression("gzip:10")
+			Expect(err).To(MatchError("compression level for gzip must be between 1 and 9, got 10"))
+
+			_, err = builder.ParseCompression("zstd:0")
+			Expect(err).To(MatchError("compression level for zstd must be between 1 and 19, got 0"))
+		})
+	})
+
+	Describe("Format, MediaType and Extension", func() {
+		It("reports gzip for the zero value", func() {
+			Expect(builder.Compression{}.Format()).To(Equal("gzip"))
+			Expect(builder.Compression{}.MediaType()).To(Equal("application/gzip"))
+			Expect(builder.Compression{}.Extension()).To(Equal(".tgz"))
+		})
+
+		It("reports gzip for parallel gzip", func() {
+			Expect(builder.Compression{Codec: "pigz"}.Format()).To(Equal("gzip"))
+			Expect(builder.Compression{Codec: "pigz"}.Extension()).To(Equal(".tgz"))
+		})
+
+		It("reports zstd for zstd", func() {
+			Expect(builder.Compression{Codec: "zstd"}.Format()).To(Equal("zstd"))
+			Expect(builder.Compression{Codec: "zstd"}.MediaType()).To(Equal("application/zstd"))
+			Expect(builder.Compression{Codec: "zstd"}.Extension()).To(Equal(".tar.zst"))
+		})
+	})
+
+	Describe("DetectFormat and ExtractArchive", func() {
+		var tmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "compression")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		It("detects gzip and zstd by their magic number", func() {
+			gzipPath := filepath.Join(tmpDir, "gzip")
+			Expect(ioutil.WriteFile(gzipPath, []byte{0x1f, 0x8b, 0x08}, 0644)).To(Succeed())
+			Expect(builder.DetectFormat(gzipPath)).To(Equal(builder.FormatGzip))
+
+			zstdPath := filepath.Join(tmpDir, "zstd")
+			Expect(ioutil.WriteFile(zstdPath, []byte{0x28, 0xb5, 0x2f, 0xfd}, 0644)).To(Succeed())
+			Expect(builder.DetectFormat(zstdPath)).To(Equal(builder.FormatZstd))
+
+			emptyPath := filepath.Join(tmpDir, "empty")
+			Expect(ioutil.WriteFile(emptyPath, nil, 0644)).To(Succeed())
+			Expect(builder.DetectFormat(emptyPath)).To(BeEmpty())
+		})
+
+		It("refuses to extract archives of an unknown format", func() {
+			path := filepath.Join(tmpDir, "plain")
+			Expect(ioutil.WriteFile(path, []byte("not an archive"), 0644)).To(Succeed())
+			Expect(builder.ExtractArchive(path, tmpDir)).To(MatchError(ContainSubstring("neither a gzip nor a zstd archive")))
+		})
+	})
+})
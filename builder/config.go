@@ -15,6 +15,32 @@ type Config struct {
 	BuildpackOrder            []string
 	SkipDetect                bool
 	BuildArtifactsCache       string
+	Compression               Compression
+	OutputImageLayout         string
+	OutputLayersDir           string
+	StagingEnvironment        StagingEnvironment
+	// EnvAllowList overrides DefaultEnvAllowList when set.
+	EnvAllowList   []string
+	ResourceLimits ResourceLimits
+	// OutputSBOMLocation is where a copy of the droplet's SBOM is written
+	// for upload. The SBOM is always stored in the droplet.
+	OutputSBOMLocation string
+	// Stack is the stack staging runs on. It defaults to the stack of the
+	// staging environment.
+	Stack string
+	// PreStagingHooksDir and PostStagingHooksDir hold executables run in
+	// lexical order before detect and after finalize or compile.
+	PreStagingHooksDir  string
+	PostStagingHooksDir string
+	// Raw stages the app without buildpacks, packaging the app directory
+	// as-is. StartCommand is its start command unless the Procfile declares
+	// a web process.
+	Raw          bool
+	StartCommand string
+	// BuildpackPaths maps buildpack names to the directories they were
+	// installed in. Buildpacks without a path are looked up by the md5 of
+	// their name in BuildpacksDir.
+	BuildpackPaths map[string]string
 }
 
 func (s *Config) InitBuildpacks(buildpacksJSON string) error {
@@ -37,6 +63,22 @@ func (s *Config) InitBuildpacks(buildpacksJSON string) error {
 	return nil
 }
 
+// InitFromInstallManifest sets the buildpack order and paths from the
+// buildpacks installed by the downloader.
+func (s *Config) InitFromInstallManifest(manifest InstallManifest) {
+	s.BuildpackPaths = map[string]string{}
+
+	if len(manifest.Buildpacks) > 0 {
+		s.SkipDetect = true
+	}
+
+	for _, installed := range manifest.Buildpacks {
+		s.BuildpackOrder = append(s.BuildpackOrder, installed.Name)
+		s.SkipDetect = s.SkipDetect && installed.SkipDetect
+		s.BuildpackPaths[installed.Name] = installed.Path
+	}
+}
+
 func (s Config) BuildArtifactsCacheDir() string {
 	return s.BuildArtifactsCache
 }
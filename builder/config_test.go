@@ -78,4 +78,64 @@ var _ = Describe("Config", func() {
 		})
 	})
 
+	Describe("Init from the install manifest", func() {
+
+		var manifest builder.InstallManifest
+
+		BeforeEach(func() {
+			manifest = builder.InstallManifest{
+				Buildpacks: []builder.InstalledBuildpack{
+					{
+						Buildpack: builder.Buildpack{Name: "java_buildpack", SkipDetect: true},
+						Path:      "/buildpacks/java",
+					},
+					{
+						Buildpack: builder.Buildpack{Name: "ruby_buildpack", SkipDetect: true},
+						Path:      "/buildpacks/ruby/ruby-buildpack",
+					},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			config.InitFromInstallManifest(manifest)
+		})
+
+		It("should set the buildpack order", func() {
+			Expect(config.BuildpackOrder).To(Equal([]string{"java_buildpack", "ruby_buildpack"}))
+		})
+
+		It("should set the installed buildpack paths", func() {
+			Expect(config.BuildpackPaths).To(Equal(map[string]string{
+				"java_buildpack": "/buildpacks/java",
+				"ruby_buildpack": "/buildpacks/ruby/ruby-buildpack",
+			}))
+		})
+
+		It("should skip detect", func() {
+			Expect(config.SkipDetect).To(BeTrue())
+		})
+
+		When("a buildpack needs detection", func() {
+			BeforeEach(func() {
+				manifest.Buildpacks[1].SkipDetect = false
+			})
+
+			It("should not skip detect", func() {
+				Expect(config.SkipDetect).To(BeFalse())
+			})
+		})
+
+		When("no buildpacks were installed", func() {
+			BeforeEach(func() {
+				manifest = builder.InstallManifest{}
+			})
+
+			It("should not change the defaults", func() {
+				Expect(config.BuildpackOrder).To(BeEmpty())
+				Expect(config.SkipDetect).To(BeFalse())
+			})
+		})
+	})
+
 })
@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigVarsProfileFile is the profile.d script exporting the config_vars of
+// the release output. The prefix makes it run before buildpack scripts, so
+// they can still extend the variables it sets.
+const ConfigVarsProfileFile = "00-config-vars.sh"
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// IsEnvVarName tells whether name can be exported as a shell variable.
+func IsEnvVarName(name string) bool {
+	return envVarName.MatchString(name)
+}
+
+// writeConfigVarsProfile writes a profile.d script exporting the given
+// config vars. Values are double quoted, so references to other variables
+// such as $PATH or $HOME are expanded at launch.
+func (runner *Runner) writeConfigVarsProfile(configVars map[string]string) error {
+	if len(configVars) == 0 {
+		return nil
+	}
+
+	script := configVarsScript(configVars)
+
+	profilePath := filepath.Join(runner.profileDir, ConfigVarsProfileFile)
+	if err := ioutil.WriteFile(profilePath, []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", profilePath, err)
+	}
+
+	return nil
+}
+
+func configVarsScript(configVars map[string]string) string {
+	names := make([]string, 0, len(configVars))
+
+	for name := range configVars {
+		if !IsEnvVarName(name) {
+			log.Printf("WARNING: Ignoring config var with invalid name %q\n", name)
+
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var script strings.Builder
+
+	script.WriteString("# config_vars from the buildpack release output\n")
+
+	for _, name := range names {
+		fmt.Fprintf(&script, "export %s=\"%s\"\n", name, escapeDoubleQuoted(configVars[name]))
+	}
+
+	return script.String()
+}
+
+// escapeDoubleQuoted escapes everything that is special inside double quotes
+// except for $, which is kept for variable expansion.
+func escapeDoubleQuoted(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(value)
+}
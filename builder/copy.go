@@ -0,0 +1,153 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CopyStats summarises what a copy left out.
+type CopyStats struct {
+	ExcludedFiles int
+	ExcludedBytes int64
+	SkippedFiles  int
+}
+
+type dirAttributes struct {
+	path string
+	info os.FileInfo
+}
+
+// CopyDir copies the tree at src to dst, which must not exist yet. Modes,
+// modification times and symlinks are preserved; special files such as
+// sockets and devices are skipped. Paths matched by ignore are left out.
+func CopyDir(src, dst string, ignore *CFIgnore) (CopyStats, error) {
+	stats := CopyStats{}
+	dirs := []dirAttributes{}
+
+	err := filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", srcPath, err)
+		}
+
+		dstPath := filepath.Join(dst, relPath)
+
+		if relPath != "." && ignore != nil && ignore.Ignored(relPath, info.IsDir()) {
+			return stats.exclude(srcPath, info)
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			// directories are created writable and get their real mode once
+			// their contents have been copied
+			if err = os.Mkdir(dstPath, 0700); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", dstPath, err)
+			}
+
+			dirs = append(dirs, dirAttributes{path: dstPath, info: info})
+
+			return nil
+		case mode&os.ModeSymlink != 0:
+			return copySymlink(srcPath, dstPath)
+		case mode.IsRegular():
+			return copyFile(srcPath, dstPath, info)
+		default:
+			stats.SkippedFiles++
+
+			return nil
+		}
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// deepest directories first, so that setting a parent's mtime is not
+	// undone by changes to its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = os.Chmod(dirs[i].path, dirs[i].info.Mode().Perm()); err != nil {
+			return stats, fmt.Errorf("failed to set mode of %s: %w", dirs[i].path, err)
+		}
+
+		if err = os.Chtimes(dirs[i].path, dirs[i].info.ModTime(), dirs[i].info.ModTime()); err != nil {
+			return stats, fmt.Errorf("failed to set times of %s: %w", dirs[i].path, err)
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *CopyStats) exclude(srcPath string, info os.FileInfo) error {
+	if !info.IsDir() {
+		s.ExcludedFiles++
+		s.ExcludedBytes += info.Size()
+
+		return nil
+	}
+
+	err := filepath.Walk(srcPath, func(_ string, child os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !child.IsDir() {
+			s.ExcludedFiles++
+			s.ExcludedBytes += child.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to inspect excluded directory %s: %w", srcPath, err)
+	}
+
+	return filepath.SkipDir
+}
+
+func copySymlink(srcPath, dstPath string) error {
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s: %w", srcPath, err)
+	}
+
+	if err = os.Symlink(target, dstPath); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", dstPath, err)
+	}
+
+	return nil
+}
+
+func copyFile(srcPath, dstPath string, info os.FileInfo) error {
+	srcFile, err := os.Open(filepath.Clean(srcPath))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", srcPath, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dstPath, err)
+	}
+	defer dstFile.Close()
+
+	if _, err = io.Copy(dstFile, srcFile); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+	}
+
+	// the umask applies to OpenFile, and setuid/setgid/sticky bits are not
+	// part of Perm()
+	if err = dstFile.Chmod(info.Mode()); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", dstPath, err)
+	}
+
+	if err = dstFile.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", dstPath, err)
+	}
+
+	return os.Chtimes(dstPath, info.ModTime(), info.ModTime())
+}
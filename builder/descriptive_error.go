@@ -15,6 +15,8 @@ const (
 	NoSupplyScriptFailMsg  = "Error: one of the buildpacks chosen to supply dependencies does not support multi-buildpack apps"
 	MissingFinalizeWarnMsg = "Warning: the last buildpack is not compatible with multi-buildpack apps and cannot make use of any dependencies supplied by the buildpacks specified before it"
 	FinalizeFailMsg        = "Failed to run finalize script"
+	StackIncompatibleMsg   = "BuildpackStackIncompatible"
+	HookFailMsg            = "StagingHookFailed"
 
 	SystemFailCode   = 1
 	DetectFailCode   = 222
@@ -22,12 +24,17 @@ const (
 	ReleaseFailCode  = 224
 	SupplyFailCode   = 225
 	FinalizeFailCode = 227
+	StackFailCode    = 228
+	HookFailCode     = 229
 )
 
 type DescriptiveError struct {
 	ExitCode   int
 	InnerError error
 	Message    string
+	// Output is a redacted summary of the buildpack output leading up to
+	// the failure.
+	Output string
 }
 
 func (e DescriptiveError) Error() string {
@@ -54,6 +61,83 @@ func NewFinalizeFailError(err error) error {
 	return DescriptiveError{Message: FinalizeFailMsg, ExitCode: FinalizeFailCode, InnerError: err}
 }
 
+func NewStackIncompatibleError(err error) error {
+	return DescriptiveError{Message: StackIncompatibleMsg, ExitCode: StackFailCode, InnerError: err}
+}
+
+func NewHookFailError(err error) error {
+	return DescriptiveError{Message: HookFailMsg, ExitCode: HookFailCode, InnerError: err}
+}
+
 func NewNoSupplyScriptFailError(err error) error {
 	return DescriptiveError{Message: NoSupplyScriptFailMsg, ExitCode: SupplyFailCode, InnerError: err}
 }
+
+// outputError attaches the buildpack output to a DescriptiveError wrapped
+// by err, so that errors.As finds the error with its output.
+type outputError struct {
+	error
+	output string
+}
+
+func (e outputError) Unwrap() error {
+	return e.error
+}
+
+func (e outputError) Cause() error {
+	return e.error
+}
+
+func (e outputError) As(target interface{}) bool {
+	descriptiveErr, ok := target.(*DescriptiveError)
+	if !ok {
+		return false
+	}
+
+	inner, found := findDescriptiveError(e.error)
+	if !found {
+		return false
+	}
+
+	inner.Output = e.output
+	*descriptiveErr = inner
+
+	return true
+}
+
+// withOutput attaches the output to the DescriptiveError in the chain of
+// err, if there is one.
+func withOutput(err error, output string) error {
+	if descriptiveErr, ok := err.(DescriptiveError); ok {
+		descriptiveErr.Output = output
+
+		return descriptiveErr
+	}
+
+	if _, found := findDescriptiveError(err); found {
+		return outputError{error: err, output: output}
+	}
+
+	return err
+}
+
+// findDescriptiveError follows Unwrap as well as the Cause of pkg/errors,
+// which does not implement Unwrap.
+func findDescriptiveError(err error) (DescriptiveError, bool) {
+	for err != nil {
+		if descriptiveErr, ok := err.(DescriptiveError); ok {
+			return descriptiveErr, true
+		}
+
+		switch wrapper := err.(type) {
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		default:
+			return DescriptiveError{}, false
+		}
+	}
+
+	return DescriptiveError{}, false
+}
@@ -0,0 +1,49 @@
+package builder_test
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	exterrors "github.com/pkg/errors"
+)
+
+var _ = Describe("DescriptiveError output", func() {
+	var descriptiveErr builder.DescriptiveError
+
+	BeforeEach(func() {
+		descriptiveErr = builder.DescriptiveError{}
+	})
+
+	It("attaches the output to a descriptive error", func() {
+		err := builder.WithOutput(builder.NewCompileFailError(errors.New("sploded")), "it broke")
+
+		Expect(errors.As(err, &descriptiveErr)).To(BeTrue())
+		Expect(descriptiveErr.Output).To(Equal("it broke"))
+	})
+
+	It("attaches the output to a descriptive error wrapped with %w", func() {
+		err := builder.WithOutput(fmt.Errorf("staging: %w", builder.NewCompileFailError(errors.New("sploded"))), "it broke")
+
+		Expect(err).To(MatchError("staging: BuildpackCompileFailed: exit status 223 - internal error: sploded"))
+		Expect(errors.As(err, &descriptiveErr)).To(BeTrue())
+		Expect(descriptiveErr.ExitCode).To(Equal(builder.CompileFailCode))
+		Expect(descriptiveErr.Output).To(Equal("it broke"))
+	})
+
+	It("attaches the output to a descriptive error wrapped by pkg/errors", func() {
+		err := builder.WithOutput(exterrors.Wrap(builder.NewCompileFailError(errors.New("sploded")), "staging"), "it broke")
+
+		Expect(errors.As(err, &descriptiveErr)).To(BeTrue())
+		Expect(descriptiveErr.Output).To(Equal("it broke"))
+		Expect(exterrors.Cause(err)).To(MatchError(ContainSubstring("sploded")))
+	})
+
+	It("leaves other errors alone", func() {
+		failure := errors.New("sploded")
+
+		Expect(builder.WithOutput(failure, "it broke")).To(Equal(failure))
+	})
+})
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	dropletAppDir      = "app/"
+	dropletProfileDir  = "profile.d/"
+	dropletStagingInfo = "staging_info.yml"
+)
+
+// ImportDroplet produces the staging output for a prebuilt droplet instead of
+// running buildpacks: the droplet is validated and copied to the output
+// droplet location, and the staging result is derived from its
+// staging_info.yml.
+func (runner *Runner) ImportDroplet(dropletPath string) error {
+	endPhase := runner.startPhase(PhaseImport, "")
+	err := runner.importDroplet(dropletPath)
+	endPhase()
+
+	runner.writeReport(err)
+
+	return err
+}
+
+func (runner *Runner) importDroplet(dropletPath string) error {
+	tarPath, err := runner.findTar()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Validating droplet")
+
+	stagingInfoEntry, err := runner.validateDroplet(tarPath, dropletPath)
+	if err != nil {
+		return errors.Wrap(err, "invalid droplet")
+	}
+
+	stagingInfo, err := runner.readDropletStagingInfo(tarPath, dropletPath, stagingInfoEntry)
+	if err != nil {
+		return errors.Wrap(err, "invalid droplet")
+	}
+
+	log.Println("Importing droplet")
+
+	runner.dropletDigests, err = copyWithDigests(dropletPath, runner.config.OutputDropletLocation)
+	if err != nil {
+		return errors.Wrap(err, "failed to import droplet")
+	}
+
+	processTypes := ProcessTypes{}
+	if stagingInfo.StartCommand != "" {
+		processTypes["web"] = stagingInfo.StartCommand
+	} else {
+		logError("No start command specified by the droplet.")
+		logError("App will not start unless a command is provided at runtime.")
+	}
+
+	stagingResult := NewStagingResult(processTypes, LifecycleMetadata{
+		DetectedBuildpack: stagingInfo.DetectedBuildpack,
+		Buildpacks:        []BuildpackMetadata{},
+	})
+	stagingResult.DropletCompression = FormatGzip
+	stagingResult.DropletDigests = runner.dropletDigests
+
+	return errors.Wrap(runner.writeStagingResult(stagingResult), "Failed to encode generated metadata")
+}
+
+// validateDroplet checks that the droplet is a gzipped tarball with the
+// layout produced by staging and returns the name of its staging_info.yml
+// entry.
+func (runner *Runner) validateDroplet(tarPath, dropletPath string) (string, error) {
+	output, err := exec.Command(tarPath, "-tzf", dropletPath).Output() // #nosec G204
+	if err != nil {
+		return "", fmt.Errorf("failed to list the droplet contents: %w", err)
+	}
+
+	var stagingInfoEntry string
+
+	found := map[string]bool{}
+
+	for _, entry := range strings.Split(string(output), "\n") {
+		name := strings.TrimPrefix(entry, "./")
+
+		switch {
+		case name == dropletStagingInfo:
+			stagingInfoEntry = entry
+			found[dropletStagingInfo] = true
+		case strings.HasPrefix(name, dropletAppDir):
+			found[dropletAppDir] = true
+		case strings.HasPrefix(name, dropletProfileDir):
+			found[dropletProfileDir] = true
+		}
+	}
+
+	missing := []string{}
+
+	for _, required := range []string{dropletAppDir, dropletStagingInfo, dropletProfileDir} {
+		if !found[required] {
+			missing = append(missing, required)
+		}
+	}
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("droplet is missing %s", strings.Join(missing, ", "))
+	}
+
+	return stagingInfoEntry, nil
+}
+
+func (runner *Runner) readDropletStagingInfo(tarPath, dropletPath, entry string) (StagingInfo, error) {
+	var stagingInfo StagingInfo
+
+	contents, err := exec.Command(tarPath, "-xzOf", dropletPath, entry).Output() // #nosec G204
+	if err != nil {
+		return stagingInfo, fmt.Errorf("failed to read %s: %w", dropletStagingInfo, err)
+	}
+
+	if err = yaml.Unmarshal(contents, &stagingInfo); err != nil {
+		return stagingInfo, fmt.Errorf("failed to parse %s: %w", dropletStagingInfo, err)
+	}
+
+	return stagingInfo, nil
+}
+
+func copyWithDigests(src, dst string) (*Digests, error) {
+	srcFile, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer dstFile.Close()
+
+	sha256Hash := sha256.New()
+	sha1Hash := sha1.New() // #nosec G401
+
+	if _, err = io.Copy(io.MultiWriter(dstFile, sha256Hash, sha1Hash), srcFile); err != nil {
+		return nil, fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+
+	if err = dstFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close %s: %w", dst, err)
+	}
+
+	return &Digests{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+	}, nil
+}
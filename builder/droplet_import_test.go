@@ -0,0 +1,157 @@
+package builder_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ImportDroplet", func() {
+	var (
+		tmpDir         string
+		dropletDir     string
+		dropletPath    string
+		outputDroplet  string
+		outputMetadata string
+		importErr      error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "droplet-import")
+		Expect(err).NotTo(HaveOccurred())
+
+		dropletDir = filepath.Join(tmpDir, "droplet")
+		Expect(os.MkdirAll(filepath.Join(dropletDir, "app"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dropletDir, "profile.d"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dropletDir, "app", "app.sh"), []byte("#!/bin/bash\n"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dropletDir, "staging_info.yml"), []byte(`{"detected_buildpack":"Always Matching","start_command":"./app.sh"}`), 0644)).To(Succeed())
+
+		dropletPath = filepath.Join(tmpDir, "droplet.tgz")
+		outputDroplet = filepath.Join(tmpDir, "out", "droplet.tgz")
+		outputMetadata = filepath.Join(tmpDir, "out", "result.json")
+		Expect(os.MkdirAll(filepath.Join(tmpDir, "out"), 0755)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		Expect(exec.Command("tar", "-czf", dropletPath, "-C", dropletDir, ".").Run()).To(Succeed())
+
+		runner := builder.NewRunner(&builder.Config{
+			OutputDropletLocation:  outputDroplet,
+			OutputMetadataLocation: outputMetadata,
+		})
+		defer runner.CleanUp()
+
+		importErr = runner.ImportDroplet(dropletPath)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("copies the droplet to the output droplet location", func() {
+		Expect(importErr).NotTo(HaveOccurred())
+
+		expected, err := ioutil.ReadFile(dropletPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.ReadFile(outputDroplet)).To(Equal(expected))
+	})
+
+	It("derives the staging result from staging_info.yml", func() {
+		Expect(importErr).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(outputMetadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		var stagingResult builder.StagingResult
+		Expect(json.Unmarshal(contents, &stagingResult)).To(Succeed())
+
+		Expect(stagingResult.LifecycleType).To(Equal("buildpack"))
+		Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{"web": "./app.sh"}))
+		Expect(stagingResult.ExecutionMetadata).To(MatchJSON(`{"start_command":"./app.sh"}`))
+		Expect(stagingResult.LifecycleMetadata).To(Equal(builder.LifecycleMetadata{
+			DetectedBuildpack: "Always Matching",
+			Buildpacks:        []builder.BuildpackMetadata{},
+		}))
+		Expect(stagingResult.DropletCompression).To(Equal("gzip"))
+	})
+
+	It("records the digests of the droplet", func() {
+		Expect(importErr).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(outputMetadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		var stagingResult builder.StagingResult
+		Expect(json.Unmarshal(contents, &stagingResult)).To(Succeed())
+
+		droplet, err := ioutil.ReadFile(dropletPath)
+		Expect(err).NotTo(HaveOccurred())
+		sum := sha256.Sum256(droplet)
+
+		Expect(stagingResult.DropletDigests).NotTo(BeNil())
+		Expect(stagingResult.DropletDigests.SHA256).To(Equal(hex.EncodeToString(sum[:])))
+	})
+
+	It("reports the import phase", func() {
+		report, err := builder.ReadStagingReport(filepath.Join(tmpDir, "out", builder.StagingReportFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Phases).To(HaveLen(1))
+		Expect(report.Phases[0].Name).To(Equal(builder.PhaseImport))
+	})
+
+	Context("when the droplet has no start command", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(dropletDir, "staging_info.yml"), []byte(`{"detected_buildpack":"Always Matching"}`), 0644)).To(Succeed())
+		})
+
+		It("imports it without process types", func() {
+			Expect(importErr).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(outputMetadata)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contents).To(ContainSubstring(`"process_types":{}`))
+		})
+	})
+
+	Context("when the droplet misses parts of the layout", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(dropletDir, "staging_info.yml"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(dropletDir, "profile.d"))).To(Succeed())
+		})
+
+		It("fails naming the missing entries", func() {
+			Expect(importErr).To(MatchError(ContainSubstring("droplet is missing staging_info.yml, profile.d/")))
+		})
+
+		It("does not write a staging result", func() {
+			Expect(outputMetadata).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the droplet is not a gzipped tarball", func() {
+		JustBeforeEach(func() {
+			Expect(ioutil.WriteFile(dropletPath, []byte("not a droplet"), 0644)).To(Succeed())
+
+			runner := builder.NewRunner(&builder.Config{
+				OutputDropletLocation:  outputDroplet,
+				OutputMetadataLocation: outputMetadata,
+			})
+			defer runner.CleanUp()
+
+			importErr = runner.ImportDroplet(dropletPath)
+		})
+
+		It("fails", func() {
+			Expect(importErr).To(MatchError(ContainSubstring("failed to list the droplet contents")))
+		})
+	})
+})
@@ -0,0 +1,3 @@
+package builder
+
+var WithOutput = withOutput
@@ -0,0 +1 @@
+echo 'Hi!  Will you be my friend?'
@@ -21,6 +21,16 @@ func BuildpackPath(baseDir, buildpackName string) string {
 	return filepath.Join(baseDir, fmt.Sprintf("%x", md5.Sum([]byte(buildpackName)))) // #nosec G401
 }
 
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
 func logError(message string) {
 	log.Println(message)
 }
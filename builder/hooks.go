@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PhasePreStagingHook  = "pre-staging-hook"
+	PhasePostStagingHook = "post-staging-hook"
+
+	// hooksCacheDir holds the cache dirs of hooks in the build artifacts
+	// cache, one per hook.
+	hooksCacheDir = "hooks"
+)
+
+// runHooks runs the executables in hooksDir in lexical order. Hooks get the
+// same arguments and environment as finalize scripts:
+//
+//	<hook> <build dir> <cache dir> <deps dir> <deps index> <profile dir>
+//
+// where the deps index is the one of the final buildpack. What pre-staging
+// hooks write there is kept even when the final buildpack only has compile.
+func (runner *Runner) runHooks(phase, hooksDir string) error {
+	if hooksDir == "" {
+		return nil
+	}
+
+	hooks, err := listHooks(hooksDir)
+	if err != nil {
+		return NewHookFailError(err)
+	}
+
+	depsIdx := runner.config.DepsIndex(len(runner.config.SupplyBuildpacks()))
+
+	for _, hook := range hooks {
+		name := filepath.Base(hook)
+		log.Printf("Running %s %s", phase, name)
+
+		cacheDir := filepath.Join(runner.config.BuildArtifactsCacheDir(), hooksCacheDir, name)
+		if err = os.MkdirAll(cacheDir, 0755); err != nil {
+			return NewHookFailError(fmt.Errorf("failed to create cache dir for hook %s: %w", name, err))
+		}
+
+		err = runner.run(exec.Command(hook, runner.config.BuildDir, cacheDir, runner.depsDir, depsIdx, runner.profileDir)) // #nosec G204
+		if err != nil {
+			logError(fmt.Sprintf("%s %s failed %s", phase, name, err.Error()))
+
+			return NewHookFailError(errors.Wrapf(err, "%s %s failed", phase, name))
+		}
+	}
+
+	return nil
+}
+
+// listHooks returns the executables in hooksDir, sorted by name. Hidden
+// files are ignored and other non-executable files are skipped with a
+// warning. A missing directory has no hooks.
+func listHooks(hooksDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(hooksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logError(fmt.Sprintf("WARNING: hooks directory %s does not exist", hooksDir))
+
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to read hooks directory: %w", err)
+	}
+
+	hooks := []string{}
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") || file.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(hooksDir, file.Name())
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat hook %s: %w", file.Name(), err)
+		}
+
+		if !info.Mode().IsRegular() || info.Mode()&ExecutableBits == 0 {
+			logError(fmt.Sprintf("WARNING: skipping hook %s: not an executable file", file.Name()))
+
+			continue
+		}
+
+		hooks = append(hooks, path)
+	}
+
+	return hooks, nil
+}
+
+// hookPhase tells whether a script is a hook and, if so, which phase it
+// runs in.
+func (runner *Runner) hookPhase(scriptPath string) (string, bool) {
+	for phase, hooksDir := range map[string]string{
+		PhasePreStagingHook:  runner.config.PreStagingHooksDir,
+		PhasePostStagingHook: runner.config.PostStagingHooksDir,
+	} {
+		if hooksDir != "" && filepath.Dir(scriptPath) == filepath.Clean(hooksDir) {
+			return phase, true
+		}
+	}
+
+	return "", false
+}
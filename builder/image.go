@@ -0,0 +1,111 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/oci"
+)
+
+const (
+	ImageHome    = "/home/vcap"
+	ImageUser    = "2000:2000"
+	ImageUID     = 2000
+	ImageGID     = 2000
+	ImageTag     = "latest"
+	ImagePathEnv = "PATH=/usr/local/bin:/usr/bin:/bin"
+
+	LabelDetectedBuildpack = "org.cloudfoundry.detected-buildpack"
+	LabelProcessTypes      = "org.cloudfoundry.process-types"
+	LabelStack             = "org.cloudfoundry.stack"
+
+	// imageLaunchScript mirrors what the CF launcher does before starting a
+	// process: source profile.d scripts and .profile, then run the command
+	// passed as the first argument through a shell.
+	imageLaunchScript = `cd "$HOME" && ` +
+		`for f in /home/vcap/profile.d/*.sh /home/vcap/app/.profile.d/*.sh; do if [ -f "$f" ]; then . "$f"; fi; done; ` +
+		`if [ -f /home/vcap/app/.profile ]; then . /home/vcap/app/.profile; fi; ` +
+		`exec /bin/bash -c "$1"`
+)
+
+// ImageConfig builds the image config for a droplet. The start command is
+// passed to the entrypoint as its only argument, so other process types can
+// be run by overriding the image's command.
+func ImageConfig(stagingInfo StagingInfo, processTypes ProcessTypes) (oci.Config, error) {
+	processTypesJSON, err := json.Marshal(processTypes)
+	if err != nil {
+		return oci.Config{}, fmt.Errorf("failed to marshal process types: %w", err)
+	}
+
+	config := oci.Config{
+		User: ImageUser,
+		Env: []string{
+			ImagePathEnv,
+			"HOME=" + path.Join(ImageHome, "app"),
+			"TMPDIR=" + path.Join(ImageHome, "tmp"),
+			"DEPS_DIR=" + path.Join(ImageHome, "deps"),
+			"LANG=en_US.UTF-8",
+		},
+		Entrypoint: []string{"/bin/bash", "-c", imageLaunchScript, "launcher"},
+		WorkingDir: path.Join(ImageHome, "app"),
+		Labels: map[string]string{
+			LabelDetectedBuildpack: stagingInfo.DetectedBuildpack,
+			LabelProcessTypes:      string(processTypesJSON),
+		},
+	}
+
+	if stagingInfo.StartCommand != "" {
+		config.Cmd = []string{stagingInfo.StartCommand}
+	}
+
+	return config, nil
+}
+
+// exportImage writes the droplet as an image with a single layer rooted at
+// /home/vcap. The image has no base layer: like a droplet, it only runs on
+// top of the stack it was staged on, which provides /bin/bash and the
+// libraries the buildpacks compiled against. Whoever runs it must layer it
+// onto the image of the stack named by the org.cloudfoundry.stack label.
+func (runner *Runner) exportImage(processTypes ProcessTypes) error {
+	stagingInfo, err := runner.readStagingInfo()
+	if err != nil {
+		return err
+	}
+
+	config, err := ImageConfig(stagingInfo, processTypes)
+	if err != nil {
+		return err
+	}
+
+	if runner.config.Stack != "" {
+		config.Labels[LabelStack] = runner.config.Stack
+	}
+
+	_, err = oci.WriteImage(runner.config.OutputImageLayout, ImageTag, config, oci.Layer{
+		SourceDir: runner.contentsDir,
+		Prefix:    ImageHome,
+		UID:       ImageUID,
+		GID:       ImageGID,
+		CreatedBy: "eirini-staging: droplet",
+	})
+
+	return err
+}
+
+func (runner *Runner) readStagingInfo() (StagingInfo, error) {
+	var stagingInfo StagingInfo
+
+	contents, err := ioutil.ReadFile(filepath.Join(runner.contentsDir, "staging_info.yml"))
+	if err != nil {
+		return stagingInfo, fmt.Errorf("failed to read staging_info.yml: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &stagingInfo); err != nil {
+		return stagingInfo, fmt.Errorf("failed to parse staging_info.yml: %w", err)
+	}
+
+	return stagingInfo, nil
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// InstallManifestFile is written by the downloader into the buildpacks
+// directory and handed on by the executor next to the staging result.
+const InstallManifestFile = "install_manifest.json"
+
+// Source types of installed buildpacks.
+const (
+	SourceZip  = "zip"
+	SourceTgz  = "tgz"
+	SourceGit  = "git"
+	SourceFile = "file"
+)
+
+// InstalledBuildpack records where and from what a buildpack was installed.
+type InstalledBuildpack struct {
+	Buildpack
+	// Path is the directory holding the buildpack's bin directory.
+	Path       string `json:"path"`
+	SourceType string `json:"source_type"`
+	// Digest is the sha256 of the downloaded archive, e.g. "sha256:<hex>".
+	Digest string `json:"digest,omitempty"`
+	// ResolvedURL is the URL the buildpack was downloaded from after
+	// following redirects.
+	ResolvedURL string `json:"resolved_url"`
+	GitCommit   string `json:"git_commit,omitempty"`
+}
+
+// InstallManifest lists the installed buildpacks in staging order.
+type InstallManifest struct {
+	Buildpacks []InstalledBuildpack `json:"buildpacks"`
+}
+
+func ReadInstallManifest(path string) (InstallManifest, error) {
+	manifest := InstallManifest{}
+
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read install manifest: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to unmarshal install manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func (m InstallManifest) Write(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal install manifest: %w", err)
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write install manifest: %w", err)
+	}
+
+	return nil
+}
+
+// BuildpacksJSON encodes the installed buildpacks in the format of the
+// BUILDPACKS environment variable.
+func (m InstallManifest) BuildpacksJSON() (string, error) {
+	buildpacks := make([]Buildpack, len(m.Buildpacks))
+	for i, installed := range m.Buildpacks {
+		buildpacks[i] = installed.Buildpack
+	}
+
+	data, err := json.Marshal(buildpacks)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal buildpacks JSON: %w", err)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,86 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const LaunchYMLFile = "launch.yml"
+
+// Sidecar is a process that CC runs next to the given process types.
+type Sidecar struct {
+	Name         string   `json:"name"`
+	ProcessTypes []string `json:"process_types"`
+	Command      string   `json:"command"`
+	Memory       int      `json:"memory,omitempty"`
+}
+
+// LaunchYML is the launch.yml a buildpack may write into its deps directory
+// to declare processes and sidecars.
+type LaunchYML struct {
+	Processes []LaunchProcess `yaml:"processes"`
+}
+
+type LaunchProcess struct {
+	Type      string `yaml:"type"`
+	Command   string `yaml:"command"`
+	Platforms struct {
+		Cloudfoundry struct {
+			SidecarFor []string `yaml:"sidecar_for"`
+		} `yaml:"cloudfoundry"`
+	} `yaml:"platforms"`
+	Limits struct {
+		Memory int `yaml:"memory"`
+	} `yaml:"limits"`
+}
+
+// readLaunchYMLs collects the launch.yml files of all buildpacks in order.
+// Process types declared by later buildpacks override earlier ones.
+func (runner *Runner) readLaunchYMLs() (ProcessTypes, []Sidecar, error) {
+	processTypes := ProcessTypes{}
+	sidecars := []Sidecar{}
+
+	for i := 0; i <= len(runner.config.SupplyBuildpacks()); i++ {
+		launchPath := filepath.Join(runner.depsDir, runner.config.DepsIndex(i), LaunchYMLFile)
+
+		contents, err := ioutil.ReadFile(launchPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, nil, fmt.Errorf("failed to read %s: %w", launchPath, err)
+		}
+
+		var launch LaunchYML
+		if err = yaml.Unmarshal(contents, &launch); err != nil {
+			return nil, nil, fmt.Errorf("invalid launch.yml for buildpack %d: %w", i, err)
+		}
+
+		for _, process := range launch.Processes {
+			if process.Type == "" {
+				return nil, nil, fmt.Errorf("invalid launch.yml for buildpack %d: process without a type", i)
+			}
+
+			sidecarFor := process.Platforms.Cloudfoundry.SidecarFor
+			if len(sidecarFor) == 0 {
+				processTypes[process.Type] = process.Command
+
+				continue
+			}
+
+			sidecars = append(sidecars, Sidecar{
+				Name:         process.Type,
+				ProcessTypes: sidecarFor,
+				Command:      process.Command,
+				Memory:       process.Limits.Memory,
+			})
+		}
+	}
+
+	return processTypes, sidecars, nil
+}
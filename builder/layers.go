@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/eirini-staging/oci"
+)
+
+const (
+	LayersManifestFile = "layers.json"
+
+	DepsLayerName = "deps"
+	AppLayerName  = "app"
+)
+
+// layerModTime is stamped on every entry of the layers. Buildpacks reinstall
+// dependencies and rewrite the app on every staging, so without it unchanged
+// layers would never produce the same digest twice.
+var layerModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DropletLayer is a content-addressed part of a layered droplet. Extracting
+// all layers of a droplet in order yields the same tree as the droplet itself.
+type DropletLayer struct {
+	Name      string `json:"name"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type"`
+}
+
+type LayersManifest struct {
+	Layers []DropletLayer `json:"layers"`
+}
+
+// LayerPath is where a layer is stored in a layers output directory. The
+// extension follows the codec the layer was compressed with.
+func LayerPath(layersDir string, layer DropletLayer) string {
+	compression := Compression{Codec: CodecGzip}
+	if layer.MediaType == (Compression{Codec: CodecZstd}).MediaType() {
+		compression.Codec = CodecZstd
+	}
+
+	return filepath.Join(layersDir, digestHex(layer.Digest)+compression.Extension())
+}
+
+func ReadLayersManifest(layersDir string) (LayersManifest, error) {
+	var manifest LayersManifest
+
+	contents, err := ioutil.ReadFile(filepath.Join(layersDir, LayersManifestFile))
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read layers manifest: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to unmarshal layers manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func (runner *Runner) createLayers() ([]DropletLayer, error) {
+	layersDir := runner.config.OutputLayersDir
+	if err := os.MkdirAll(layersDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create layers dir: %w", err)
+	}
+
+	entries, err := ioutil.ReadDir(runner.contentsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read droplet contents: %w", err)
+	}
+
+	appEntries := []string{}
+	for _, entry := range entries {
+		if entry.Name() != DepsLayerName {
+			appEntries = append(appEntries, entry.Name())
+		}
+	}
+
+	depsLayer, err := runner.writeLayer(DepsLayerName, oci.Layer{
+		SourceDir: runner.contentsDir,
+		Include:   []string{DepsLayerName},
+		UID:       ImageUID,
+		GID:       ImageGID,
+		ModTime:   layerModTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	appLayer, err := runner.writeLayer(AppLayerName, oci.Layer{
+		SourceDir: runner.contentsDir,
+		Include:   appEntries,
+		UID:       ImageUID,
+		GID:       ImageGID,
+		ModTime:   layerModTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	layers := []DropletLayer{depsLayer, appLayer}
+
+	contents, err := json.Marshal(LayersManifest{Layers: layers})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal layers manifest: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(layersDir, LayersManifestFile), contents, 0644); err != nil { //nolint:gosec
+		return nil, fmt.Errorf("failed to write layers manifest: %w", err)
+	}
+
+	return layers, nil
+}
+
+func (runner *Runner) writeLayer(name string, layer oci.Layer) (DropletLayer, error) {
+	tmpFile, err := ioutil.TempFile(runner.config.OutputLayersDir, name)
+	if err != nil {
+		return DropletLayer{}, fmt.Errorf("failed to create %s layer file: %w", name, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	digester := sha256.New()
+
+	compressor, err := runner.config.Compression.writer(io.MultiWriter(tmpFile, digester))
+	if err != nil {
+		return DropletLayer{}, err
+	}
+
+	if _, err = layer.WriteTar(compressor); err != nil {
+		compressor.Close()
+
+		return DropletLayer{}, fmt.Errorf("failed to write %s layer: %w", name, err)
+	}
+
+	if err = compressor.Close(); err != nil {
+		return DropletLayer{}, fmt.Errorf("failed to compress %s layer: %w", name, err)
+	}
+
+	fileInfo, err := tmpFile.Stat()
+	if err != nil {
+		return DropletLayer{}, fmt.Errorf("failed to stat %s layer: %w", name, err)
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return DropletLayer{}, fmt.Errorf("failed to close %s layer: %w", name, err)
+	}
+
+	dropletLayer := DropletLayer{
+		Name:      name,
+		Digest:    fmt.Sprintf("sha256:%x", digester.Sum(nil)),
+		Size:      fileInfo.Size(),
+		MediaType: runner.config.Compression.MediaType(),
+	}
+
+	if err = os.Rename(tmpFile.Name(), LayerPath(runner.config.OutputLayersDir, dropletLayer)); err != nil {
+		return DropletLayer{}, fmt.Errorf("failed to move %s layer into place: %w", name, err)
+	}
+
+	return dropletLayer, nil
+}
+
+func digestHex(digest string) string {
+	if idx := strings.Index(digest, ":"); idx != -1 {
+		return digest[idx+1:]
+	}
+
+	return digest
+}
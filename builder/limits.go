@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// signalExitBase is added to the signal number in the exit status of a
+	// shell whose child was killed by that signal.
+	signalExitBase = 128
+
+	// cpuTimeGrace is how long a script may run past its CPU time limit
+	// after it is sent SIGXCPU, before the hard limit kills it.
+	cpuTimeGrace = 5
+)
+
+// ResourceLimits are the rlimits applied to buildpack scripts. Zero fields
+// are left at the executor's own limits.
+//
+// Only the file size and CPU time limits can be told apart from ordinary
+// script failures, as the kernel signals the offending process. The
+// offending process is often a child of the script, so a script exiting with
+// 128 plus SIGXFSZ or SIGXCPU is taken to have hit that limit as well, as
+// long as the limit is set. Hitting the process or open files limit makes
+// fork or open fail, which the script reports like any other error.
+type ResourceLimits struct {
+	Processes uint64
+	OpenFiles uint64
+	// FileSize is the largest file a script may write, in bytes.
+	FileSize uint64
+	CPUTime  time.Duration
+}
+
+// ResourceLimitError is returned when a buildpack script is killed for
+// exceeding one of its resource limits.
+type ResourceLimitError struct {
+	Buildpack string
+	Phase     string
+	Limit     string
+}
+
+func (e ResourceLimitError) Error() string {
+	return fmt.Sprintf("buildpack %s exceeded the %s during %s", e.Buildpack, e.Limit, e.Phase)
+}
+
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// apply rewrites cmd to run through bash, which lowers its own limits before
+// exec'ing the original command. Limits set this way are inherited by every
+// process the script starts.
+func (l ResourceLimits) apply(cmd *exec.Cmd) error {
+	if l.IsZero() {
+		return nil
+	}
+
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		return fmt.Errorf("failed to find `bash` to apply resource limits: %w", err)
+	}
+
+	ulimits := []string{}
+	if l.Processes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -u %d", l.Processes))
+	}
+
+	if l.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+
+	if l.FileSize > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -f %d", l.fileSizeBlocks()))
+	}
+
+	if l.CPUTime > 0 {
+		ulimits = append(ulimits,
+			fmt.Sprintf("ulimit -S -t %d", l.cpuSeconds()),
+			fmt.Sprintf("ulimit -H -t %d", l.cpuSeconds()+cpuTimeGrace),
+		)
+	}
+
+	script := strings.Join(append(ulimits, `exec "$0" "$@"`), " && ")
+	cmd.Args = append([]string{bashPath, "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = bashPath
+
+	return nil
+}
+
+// exceeded reports which limit, if any, caused err.
+func (l ResourceLimits) exceeded(err error) (string, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "", false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return "", false
+	}
+
+	var signal syscall.Signal
+
+	switch {
+	case status.Signaled():
+		signal = status.Signal()
+	case status.ExitStatus() == signalExitBase+int(syscall.SIGXFSZ):
+		signal = syscall.SIGXFSZ
+	case status.ExitStatus() == signalExitBase+int(syscall.SIGXCPU):
+		signal = syscall.SIGXCPU
+	default:
+		return "", false
+	}
+
+	cpuUsed := exitErr.UserTime() + exitErr.SystemTime()
+
+	switch {
+	case signal == syscall.SIGXFSZ && l.FileSize > 0:
+		return fmt.Sprintf("file size limit of %d bytes", l.FileSize), true
+	case signal == syscall.SIGXCPU && l.CPUTime > 0:
+		return fmt.Sprintf("CPU time limit of %s", l.CPUTime), true
+	case signal == syscall.SIGKILL && l.CPUTime > 0 && cpuUsed >= l.CPUTime:
+		// the hard CPU limit is enforced with SIGKILL
+		return fmt.Sprintf("CPU time limit of %s", l.CPUTime), true
+	default:
+		return "", false
+	}
+}
+
+// fileSizeBlocks converts FileSize to the 1024 byte blocks bash's ulimit
+// expects, rounding up.
+func (l ResourceLimits) fileSizeBlocks() uint64 {
+	return (l.FileSize + 1023) / 1024
+}
+
+func (l ResourceLimits) cpuSeconds() int64 {
+	return int64((l.CPUTime + time.Second - 1) / time.Second)
+}
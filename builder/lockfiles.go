@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lockfileParser extracts package names and versions from a lockfile.
+type lockfileParser struct {
+	name     string
+	purlType string
+	parse    func(contents []byte) ([]lockedPackage, error)
+}
+
+// lockedPackage is a package version pinned by a lockfile. A lockfile may
+// pin several versions of the same package.
+type lockedPackage struct {
+	name    string
+	version string
+}
+
+// packageSet collects the distinct packages of a lockfile.
+type packageSet map[lockedPackage]bool
+
+func (s packageSet) add(name, version string) {
+	s[lockedPackage{name: name, version: version}] = true
+}
+
+// sorted lists the packages by name and then by version.
+func (s packageSet) sorted() []lockedPackage {
+	packages := make([]lockedPackage, 0, len(s))
+	for pkg := range s {
+		packages = append(packages, pkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].name != packages[j].name {
+			return packages[i].name < packages[j].name
+		}
+
+		return packages[i].version < packages[j].version
+	})
+
+	return packages
+}
+
+var lockfileParsers = []lockfileParser{
+	{name: "package-lock.json", purlType: "npm", parse: parsePackageLock},
+	{name: "Gemfile.lock", purlType: "gem", parse: parseGemfileLock},
+	{name: "requirements.txt", purlType: "pypi", parse: parseRequirements},
+}
+
+var (
+	gemSpecRegex     = regexp.MustCompile(`^    ([^ ]+) \(([^)]+)\)$`)
+	requirementRegex = regexp.MustCompile(`^([A-Za-z0-9._-]+)(\[[^\]]*\])?\s*==\s*([^\s;#]+)`)
+)
+
+// parsePackageLock supports both the "packages" map of lockfile version 2
+// and later, and the nested "dependencies" of version 1. Packages installed
+// at several versions in nested node_modules are listed once per version.
+func parsePackageLock(contents []byte) ([]lockedPackage, error) {
+	type v1Dependency struct {
+		Version      string                     `json:"version"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+
+	lock := struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+		} `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}{}
+
+	if err := json.Unmarshal(contents, &lock); err != nil {
+		return nil, fmt.Errorf("invalid package-lock.json: %w", err)
+	}
+
+	packages := packageSet{}
+
+	if len(lock.Packages) > 0 {
+		for path, pkg := range lock.Packages {
+			idx := strings.LastIndex(path, "node_modules/")
+			if idx == -1 || pkg.Version == "" {
+				continue
+			}
+
+			packages.add(path[idx+len("node_modules/"):], pkg.Version)
+		}
+
+		return packages.sorted(), nil
+	}
+
+	var collect func(dependencies map[string]json.RawMessage) error
+	collect = func(dependencies map[string]json.RawMessage) error {
+		for name, raw := range dependencies {
+			var dependency v1Dependency
+			if err := json.Unmarshal(raw, &dependency); err != nil {
+				return fmt.Errorf("invalid package-lock.json entry for %s: %w", name, err)
+			}
+
+			if dependency.Version != "" {
+				packages.add(name, dependency.Version)
+			}
+
+			if err := collect(dependency.Dependencies); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := collect(lock.Dependencies); err != nil {
+		return nil, err
+	}
+
+	return packages.sorted(), nil
+}
+
+// parseGemfileLock reads the gem specs of the GEM, GIT and PATH sections.
+func parseGemfileLock(contents []byte) ([]lockedPackage, error) {
+	packages := packageSet{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	for scanner.Scan() {
+		if match := gemSpecRegex.FindStringSubmatch(scanner.Text()); match != nil {
+			packages.add(match[1], match[2])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Gemfile.lock: %w", err)
+	}
+
+	return packages.sorted(), nil
+}
+
+// parseRequirements only reads requirements pinned with ==, as ranges do
+// not say what was installed.
+func parseRequirements(contents []byte) ([]lockedPackage, error) {
+	packages := packageSet{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	for scanner.Scan() {
+		if match := requirementRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text())); match != nil {
+			packages.add(strings.ToLower(match[1]), match[3])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read requirements.txt: %w", err)
+	}
+
+	return packages.sorted(), nil
+}
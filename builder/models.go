@@ -1,7 +1,13 @@
 package builder
 
+import "encoding/json"
+
+// Release is the output of a buildpack's bin/release. ConfigVars are set in
+// the app's environment at launch, Addons are recorded but not provisioned.
 type Release struct {
-	DefaultProcessTypes ProcessTypes `yaml:"default_process_types"`
+	DefaultProcessTypes ProcessTypes      `yaml:"default_process_types"`
+	ConfigVars          map[string]string `yaml:"config_vars"`
+	Addons              []string          `yaml:"addons"`
 }
 
 // StagingInfo is used for export/import droplets.
@@ -36,6 +42,43 @@ type StagingResult struct {
 	ProcessTypes      `json:"process_types"`
 	ExecutionMetadata string `json:"execution_metadata"`
 	LifecycleType     string `json:"lifecycle_type"`
+	// DropletCompression is the format of the droplet and build artifacts
+	// cache archives, either "gzip" or "zstd".
+	DropletCompression string `json:"droplet_compression,omitempty"`
+	// DropletLayers lists the content-addressed layers of the droplet when
+	// layered output is enabled.
+	DropletLayers []DropletLayer `json:"droplet_layers,omitempty"`
+	// Sidecars are the sidecars declared by buildpacks in launch.yml.
+	Sidecars []Sidecar `json:"sidecars,omitempty"`
+	// DropletDigests and BuildArtifactsCacheDigests are the checksums of the
+	// compressed droplet and build artifacts cache.
+	DropletDigests             *Digests `json:"droplet_digests,omitempty"`
+	BuildArtifactsCacheDigests *Digests `json:"build_artifacts_cache_digests,omitempty"`
+	// ConfigVars and Addons are passed through from the release output.
+	ConfigVars map[string]string `json:"config_vars,omitempty"`
+	Addons     []string          `json:"addons,omitempty"`
+	// Image is recorded by the upload when it pushed the droplet image.
+	Image *PushedImage `json:"image,omitempty"`
+}
+
+// PushedImage is the registry reference the droplet image was pushed to and
+// the digest of its manifest.
+type PushedImage struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+}
+
+// Digests are the hex encoded checksums of a file. SHA1 is kept for Cloud
+// Controller, which identifies droplets by their sha1.
+type Digests struct {
+	SHA256 string `json:"sha256"`
+	SHA1   string `json:"sha1"`
+}
+
+// ExecutionMetadata is the JSON stored in the execution_metadata of a
+// staging result: the start command of the web process.
+type ExecutionMetadata struct {
+	StartCommand string `json:"start_command"`
 }
 
 func NewStagingResult(procTypes ProcessTypes, lifeMeta LifecycleMetadata) StagingResult {
@@ -43,6 +86,17 @@ func NewStagingResult(procTypes ProcessTypes, lifeMeta LifecycleMetadata) Stagin
 		LifecycleType:     "buildpack",
 		LifecycleMetadata: lifeMeta,
 		ProcessTypes:      procTypes,
-		ExecutionMetadata: "",
+		ExecutionMetadata: NewExecutionMetadata(procTypes),
 	}
 }
+
+// NewExecutionMetadata encodes the execution metadata for the given process
+// types, using the web process as the start command.
+func NewExecutionMetadata(procTypes ProcessTypes) string {
+	// encoding a struct of strings cannot fail
+	metadata, _ := json.Marshal(ExecutionMetadata{
+		StartCommand: procTypes["web"],
+	})
+
+	return string(metadata)
+}
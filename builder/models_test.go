@@ -0,0 +1,44 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var _ = Describe("NewStagingResult", func() {
+	// The fixtures pair a buildpack release output with the execution_metadata
+	// expected for it, see fixtures/execution-metadata/README.md.
+	expectExecutionMetadata := func(fixture string) {
+		dir := filepath.Join("fixtures", "execution-metadata", fixture)
+
+		releaseOutput, err := ioutil.ReadFile(filepath.Join(dir, "release.yml"))
+		Expect(err).NotTo(HaveOccurred())
+
+		var release builder.Release
+		Expect(yaml.Unmarshal(releaseOutput, &release)).To(Succeed())
+
+		expected, err := ioutil.ReadFile(filepath.Join(dir, "execution_metadata.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		result := builder.NewStagingResult(release.DefaultProcessTypes, builder.LifecycleMetadata{})
+		Expect(result.ExecutionMetadata).To(MatchJSON(expected))
+	}
+
+	It("produces the execution_metadata for a web process", func() {
+		expectExecutionMetadata("web")
+	})
+
+	It("produces the execution_metadata without a web process", func() {
+		expectExecutionMetadata("no-web")
+	})
+
+	It("produces execution_metadata without any process types", func() {
+		result := builder.NewStagingResult(nil, builder.LifecycleMetadata{})
+		Expect(result.ExecutionMetadata).To(MatchJSON(`{"start_command":""}`))
+	})
+})
@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var processTypeNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ProcfileError points at the line of a Procfile that could not be parsed.
+type ProcfileError struct {
+	Line    int
+	Message string
+
+	// notLineOriented is set when the line does not have the
+	// "name: command" shape at all, as opposed to an invalid name or command.
+	notLineOriented bool
+}
+
+func (e ProcfileError) Error() string {
+	return fmt.Sprintf("Procfile line %d: %s", e.Line, e.Message)
+}
+
+// ParseProcfile parses a Procfile the way Heroku does: one "name: command"
+// per line, with blank lines and lines starting with '#' ignored. Commands
+// are taken verbatim, so they may contain ': ', '#' and unquoted flags.
+//
+// Procfiles that are not line-oriented but are valid YAML, such as ones with
+// multi-line values, are still accepted for backwards compatibility.
+func ParseProcfile(contents []byte) (ProcessTypes, error) {
+	processes, err := parseProcfileLines(contents)
+	if err == nil {
+		return processes, nil
+	}
+
+	var procfileErr ProcfileError
+	if !errors.As(err, &procfileErr) || !procfileErr.notLineOriented {
+		return nil, err
+	}
+
+	yamlProcesses := ProcessTypes{}
+	if yamlErr := yaml.Unmarshal(contents, &yamlProcesses); yamlErr != nil || !validProcessTypes(yamlProcesses) {
+		return nil, err
+	}
+
+	log.Printf("WARNING: %s; falling back to parsing the Procfile as YAML", err.Error())
+
+	return yamlProcesses, nil
+}
+
+func validProcessTypes(processes ProcessTypes) bool {
+	for name, command := range processes {
+		if !processTypeNameRegexp.MatchString(name) || command == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseProcfileLines(contents []byte) (ProcessTypes, error) {
+	processes := ProcessTypes{}
+	definedOn := map[string]int{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		separator := strings.Index(line, ":")
+		if separator == -1 {
+			return nil, ProcfileError{Line: lineNumber, Message: `expected "<process type>: <command>"`, notLineOriented: true}
+		}
+
+		name := strings.TrimSpace(line[:separator])
+		if !processTypeNameRegexp.MatchString(name) {
+			return nil, ProcfileError{Line: lineNumber, Message: fmt.Sprintf("invalid process type %q: only letters, digits, '-' and '_' are allowed", name)}
+		}
+
+		command := unquoteCommand(strings.TrimSpace(line[separator+1:]))
+		if command == "" {
+			return nil, ProcfileError{Line: lineNumber, Message: fmt.Sprintf("empty command for process type %q", name)}
+		}
+
+		if previous, ok := definedOn[name]; ok {
+			log.Printf("WARNING: Procfile line %d redefines process type %q from line %d", lineNumber, name, previous)
+		}
+
+		processes[name] = command
+		definedOn[name] = lineNumber
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Procfile: %w", err)
+	}
+
+	return processes, nil
+}
+
+// unquoteCommand strips the quotes from commands that were written as a
+// single quoted YAML string, which the previous YAML-based parser accepted.
+func unquoteCommand(command string) string {
+	if !strings.HasPrefix(command, `"`) && !strings.HasPrefix(command, "'") {
+		return command
+	}
+
+	var unquoted string
+	if err := yaml.Unmarshal([]byte(command), &unquoted); err != nil {
+		return command
+	}
+
+	return unquoted
+}
@@ -0,0 +1,124 @@
+package builder_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseProcfile", func() {
+	var (
+		contents  string
+		processes builder.ProcessTypes
+		parseErr  error
+		logOut    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		logOut = new(bytes.Buffer)
+		log.SetOutput(logOut)
+	})
+
+	AfterEach(func() {
+		log.SetOutput(os.Stderr)
+	})
+
+	JustBeforeEach(func() {
+		processes, parseErr = builder.ParseProcfile([]byte(contents))
+	})
+
+	Context("with a line-oriented Procfile", func() {
+		BeforeEach(func() {
+			contents = "# the web process\n" +
+				"web: bundle exec rails server -p $PORT -e production\n" +
+				"\n" +
+				"worker:   env QUEUE=* bundle exec rake resque:work # not a comment\r\n" +
+				"clock_1: echo 'time: now'\n"
+		})
+
+		It("takes the commands verbatim", func() {
+			Expect(parseErr).NotTo(HaveOccurred())
+			Expect(processes).To(Equal(builder.ProcessTypes{
+				"web":     "bundle exec rails server -p $PORT -e production",
+				"worker":  "env QUEUE=* bundle exec rake resque:work # not a comment",
+				"clock_1": "echo 'time: now'",
+			}))
+		})
+	})
+
+	Context("with quoted commands", func() {
+		BeforeEach(func() {
+			contents = "web: \"bundle exec rackup\"\n" +
+				"worker: 'it''s working'\n" +
+				"other: \"a\" && \"b\"\n"
+		})
+
+		It("unquotes commands that are a single quoted string", func() {
+			Expect(parseErr).NotTo(HaveOccurred())
+			Expect(processes).To(HaveKeyWithValue("web", "bundle exec rackup"))
+			Expect(processes).To(HaveKeyWithValue("worker", "it's working"))
+		})
+
+		It("leaves other quotes alone", func() {
+			Expect(processes).To(HaveKeyWithValue("other", `"a" && "b"`))
+		})
+	})
+
+	Context("when a process type is defined twice", func() {
+		BeforeEach(func() {
+			contents = "web: first\nweb: second\n"
+		})
+
+		It("uses the last definition and warns", func() {
+			Expect(parseErr).NotTo(HaveOccurred())
+			Expect(processes).To(HaveKeyWithValue("web", "second"))
+			Expect(logOut.String()).To(ContainSubstring(`Procfile line 2 redefines process type "web" from line 1`))
+		})
+	})
+
+	Context("with an invalid process type name", func() {
+		BeforeEach(func() {
+			contents = "web: ok\nmy worker: run\n"
+		})
+
+		It("reports the line", func() {
+			Expect(parseErr).To(MatchError(`Procfile line 2: invalid process type "my worker": only letters, digits, '-' and '_' are allowed`))
+		})
+	})
+
+	Context("with an empty command", func() {
+		BeforeEach(func() {
+			contents = "web:\n"
+		})
+
+		It("reports the line", func() {
+			Expect(parseErr).To(MatchError(`Procfile line 1: empty command for process type "web"`))
+		})
+	})
+
+	Context("with a YAML Procfile that is not line-oriented", func() {
+		BeforeEach(func() {
+			contents = "web: >\n  bundle exec\n  rackup\n"
+		})
+
+		It("falls back to YAML", func() {
+			Expect(parseErr).NotTo(HaveOccurred())
+			Expect(processes).To(HaveKeyWithValue("web", "bundle exec rackup\n"))
+			Expect(logOut.String()).To(ContainSubstring("falling back to parsing the Procfile as YAML"))
+		})
+	})
+
+	Context("with garbage", func() {
+		BeforeEach(func() {
+			contents = "["
+		})
+
+		It("reports the line-oriented error", func() {
+			Expect(parseErr).To(MatchError(`Procfile line 1: expected "<process type>: <command>"`))
+		})
+	})
+})
@@ -0,0 +1,20 @@
+package builder
+
+const (
+	// RawBuildpackKey and RawBuildpackName identify the synthetic buildpack
+	// reported for apps staged with the raw lifecycle.
+	RawBuildpackKey  = "raw"
+	RawBuildpackName = "Raw"
+)
+
+var rawBuildpackMetadata = BuildpackMetadata{Key: RawBuildpackKey, Name: RawBuildpackName}
+
+// rawRelease is the release of the raw lifecycle. The configured start
+// command becomes the web process, which a Procfile may still override.
+func (runner *Runner) rawRelease() Release {
+	if runner.config.StartCommand == "" {
+		return Release{}
+	}
+
+	return Release{DefaultProcessTypes: ProcessTypes{"web": runner.config.StartCommand}}
+}
@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// StagingReportFile is written next to the staging result.
+	StagingReportFile = "staging_report.json"
+
+	PhaseExtract    = "extract"
+	PhaseCleanCache = "clean-cache"
+	PhaseTar        = "tar"
+	PhaseLayers     = "layers"
+	PhaseImage      = "image"
+	PhaseCache      = "cache"
+	PhaseImport     = "import"
+)
+
+// PhaseReport records how long a staging phase took and the resources used
+// by the processes it started. Buildpack script phases are named after the
+// script, e.g. "detect" or "supply".
+type PhaseReport struct {
+	Name             string  `json:"name"`
+	Buildpack        string  `json:"buildpack,omitempty"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+	// MaxRSSKilobytes is the largest resident set size of any process
+	// started during the phase.
+	MaxRSSKilobytes int64 `json:"max_rss_kb"`
+}
+
+type StagingReport struct {
+	Phases       []PhaseReport `json:"phases"`
+	TotalSeconds float64       `json:"total_seconds"`
+	Error        string        `json:"error,omitempty"`
+}
+
+func ReadStagingReport(path string) (StagingReport, error) {
+	report := StagingReport{}
+
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return report, fmt.Errorf("failed to read staging report: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("failed to unmarshal staging report: %w", err)
+	}
+
+	return report, nil
+}
+
+// RecordPhase adds a phase that ran before the runner was started, such as
+// extracting the app bits.
+func (runner *Runner) RecordPhase(name string, duration time.Duration) {
+	runner.report.Phases = append(runner.report.Phases, PhaseReport{
+		Name:            name,
+		DurationSeconds: duration.Seconds(),
+	})
+}
+
+// startPhase starts timing a phase. Processes finishing before the returned
+// function is called are accounted to the phase.
+func (runner *Runner) startPhase(name, buildpack string) func() {
+	phase := &PhaseReport{Name: name, Buildpack: buildpack}
+	runner.currentPhase = phase
+	start := time.Now()
+
+	return func() {
+		phase.DurationSeconds = time.Since(start).Seconds()
+		runner.report.Phases = append(runner.report.Phases, *phase)
+		runner.currentPhase = nil
+	}
+}
+
+func (runner *Runner) recordUsage(state *os.ProcessState) {
+	if runner.currentPhase == nil || state == nil {
+		return
+	}
+
+	runner.currentPhase.UserCPUSeconds += state.UserTime().Seconds()
+	runner.currentPhase.SystemCPUSeconds += state.SystemTime().Seconds()
+
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok && rusage.Maxrss > runner.currentPhase.MaxRSSKilobytes {
+		runner.currentPhase.MaxRSSKilobytes = rusage.Maxrss
+	}
+}
+
+// writeReport writes the staging report next to the staging result and logs
+// a summary. Failing to write it does not fail staging.
+func (runner *Runner) writeReport(stagingErr error) {
+	for _, phase := range runner.report.Phases {
+		runner.report.TotalSeconds += phase.DurationSeconds
+	}
+
+	if stagingErr != nil {
+		runner.report.Error = stagingErr.Error()
+	}
+
+	runner.logReport()
+
+	reportPath := filepath.Join(filepath.Dir(runner.config.OutputMetadataLocation), StagingReportFile)
+
+	data, err := json.Marshal(runner.report)
+	if err != nil {
+		logError(fmt.Sprintf("failed to encode staging report: %s", err.Error()))
+
+		return
+	}
+
+	if err = ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		logError(fmt.Sprintf("failed to write staging report: %s", err.Error()))
+	}
+}
+
+func (runner *Runner) logReport() {
+	log.Printf("Staging took %.2fs", runner.report.TotalSeconds)
+
+	for _, phase := range runner.report.Phases {
+		name := phase.Name
+		if phase.Buildpack != "" {
+			name = fmt.Sprintf("%s (%s)", phase.Name, phase.Buildpack)
+		}
+
+		line := []string{fmt.Sprintf("  %s: %.2fs", name, phase.DurationSeconds)}
+		if phase.UserCPUSeconds+phase.SystemCPUSeconds > 0 {
+			line = append(line, fmt.Sprintf("cpu %.2fs user, %.2fs system", phase.UserCPUSeconds, phase.SystemCPUSeconds))
+		}
+
+		if phase.MaxRSSKilobytes > 0 {
+			line = append(line, fmt.Sprintf("max rss %d KB", phase.MaxRSSKilobytes))
+		}
+
+		log.Println(strings.Join(line, ", "))
+	}
+}
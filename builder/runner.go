@@ -2,6 +2,9 @@ package builder
 
 import (
 	"bytes"
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,28 +19,50 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-const (
-	executableMask = 0111
-)
+// ExecutableBits are the permission bits that make a file executable by its
+// owner, group or others.
+const ExecutableBits = 0111
 
 type Runner struct {
-	config       *Config
-	depsDir      string
-	contentsDir  string
-	profileDir   string
-	BuildpackOut io.Writer
-	BuildpackErr io.Writer
+	config         *Config
+	depsDir        string
+	contentsDir    string
+	profileDir     string
+	dropletLayers  []DropletLayer
+	sidecars       []Sidecar
+	dropletDigests *Digests
+	cacheDigests   *Digests
+	env            []string
+	report         StagingReport
+	currentPhase   *PhaseReport
+	output         *outputTail
+	BuildpackOut   io.Writer
+	BuildpackErr   io.Writer
 }
 
 func NewRunner(config *Config) *Runner {
 	return &Runner{
 		config:       config,
+		output:       newOutputTail(),
 		BuildpackOut: os.Stdout,
 		BuildpackErr: os.Stderr,
 	}
 }
 
 func (runner *Runner) Run() error {
+	err := runner.stage()
+	runner.writeReport(err)
+
+	// attach the tail of the buildpack output, where the cause of a
+	// buildpack failure usually is
+	if err != nil {
+		err = withOutput(err, runner.output.Summary(MaxOutputSummaryBytes))
+	}
+
+	return err
+}
+
+func (runner *Runner) stage() error {
 	// set up the world
 	err := runner.makeDirectories()
 	if err != nil {
@@ -46,25 +71,30 @@ func (runner *Runner) Run() error {
 
 	// detect, compile, release
 	log.Println("Cleaning cache dir")
+	endPhase := runner.startPhase(PhaseCleanCache, "")
 	err = runner.cleanCacheDir()
+	endPhase()
+
 	if err != nil {
 		return errors.Wrap(err, "unable to clean cache dir")
 	}
 
-	log.Println("Detecting buildpack")
-	detectedBuildpackDir, buildpackMetadata, err := runner.supplyOrDetect()
+	if err = runner.runHooks(PhasePreStagingHook, runner.config.PreStagingHooksDir); err != nil {
+		return err
+	}
+
+	detectedBuildpackDir, buildpackMetadata, err := runner.runBuildpacks()
 	if err != nil {
-		// detect buildpack returns custom error
+		// runBuildpacks returns custom errors
 		return err
 	}
 
-	if err = runner.runFinalize(detectedBuildpackDir); err != nil {
-		// runFinalize returns custom error
+	if err = runner.runHooks(PhasePostStagingHook, runner.config.PostStagingHooksDir); err != nil {
 		return err
 	}
 
 	// re-evaluate metadata after finalize in case of multi-buildpack
-	if runner.config.SkipDetect {
+	if runner.config.SkipDetect && !runner.config.Raw {
 		buildpackMetadata = runner.buildpacksMetadata(runner.config.BuildpackOrder)
 	}
 
@@ -74,27 +104,68 @@ func (runner *Runner) Run() error {
 		return NewReleaseFailError(errors.Wrap(err, "Failed to build droplet release"))
 	}
 
+	if err = runner.writeConfigVarsProfile(releaseInfo.ConfigVars); err != nil {
+		return errors.Wrap(err, "unable to write the config vars profile script")
+	}
+
 	err = runner.writeStagingInfoYML(releaseInfo.DefaultProcessTypes["web"], buildpackMetadata)
 	if err != nil {
 		return errors.Wrap(err, "unable to build staging info for the droplet")
 	}
 
+	if err = runner.writeSBOM(buildpackMetadata); err != nil {
+		return errors.Wrap(err, "unable to write the SBOM")
+	}
+
 	tarPath, err := runner.findTar()
 	if err != nil {
 		return err
 	}
 
 	log.Println("Creating app artifact")
-	err = runner.createArtifacts(tarPath, buildpackMetadata, releaseInfo)
+	endPhase = runner.startPhase(PhaseTar, "")
+	err = runner.createArtifacts(tarPath)
+	endPhase()
+
 	if err != nil {
 		return errors.Wrap(err, "failed to find runnable app artifact")
 	}
 
+	if runner.config.OutputLayersDir != "" {
+		log.Println("Creating droplet layers")
+		endPhase = runner.startPhase(PhaseLayers, "")
+		runner.dropletLayers, err = runner.createLayers()
+		endPhase()
+
+		if err != nil {
+			return errors.Wrap(err, "failed to create droplet layers")
+		}
+	}
+
+	if runner.config.OutputImageLayout != "" {
+		log.Println("Exporting OCI image layout")
+		endPhase = runner.startPhase(PhaseImage, "")
+		err = runner.exportImage(releaseInfo.DefaultProcessTypes)
+		endPhase()
+
+		if err != nil {
+			return errors.Wrap(err, "failed to export OCI image layout")
+		}
+	}
+
+	endPhase = runner.startPhase(PhaseCache, "")
 	err = runner.createCache(tarPath)
+	endPhase()
+
 	if err != nil {
 		return errors.Wrap(err, "failed to cache runnable app artifact")
 	}
 
+	err = runner.saveInfo(buildpackMetadata, releaseInfo)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode generated metadata")
+	}
+
 	return nil
 }
 
@@ -105,6 +176,30 @@ func (runner *Runner) CleanUp() {
 	os.RemoveAll(runner.contentsDir)
 }
 
+// runBuildpacks detects or supplies, then finalizes the app and returns the
+// directory and metadata of the final buildpack.
+func (runner *Runner) runBuildpacks() (string, []BuildpackMetadata, error) {
+	if runner.config.Raw {
+		log.Println("Packaging the app as-is without buildpacks")
+
+		return "", []BuildpackMetadata{rawBuildpackMetadata}, nil
+	}
+
+	log.Println("Detecting buildpack")
+	detectedBuildpackDir, buildpackMetadata, err := runner.supplyOrDetect()
+	if err != nil {
+		// detect buildpack returns custom error
+		return "", nil, err
+	}
+
+	if err = runner.runFinalize(detectedBuildpackDir); err != nil {
+		// runFinalize returns custom error
+		return "", nil, err
+	}
+
+	return detectedBuildpackDir, buildpackMetadata, nil
+}
+
 func (runner *Runner) supplyOrDetect() (string, []BuildpackMetadata, error) {
 	if runner.config.SkipDetect {
 		return runner.runSupplyBuildpacks()
@@ -113,25 +208,20 @@ func (runner *Runner) supplyOrDetect() (string, []BuildpackMetadata, error) {
 	return runner.detect()
 }
 
-func (runner *Runner) createArtifacts(tarPath string, buildpackMetadata []BuildpackMetadata, releaseInfo Release) error {
-	err := runner.saveInfo(buildpackMetadata, releaseInfo)
-	if err != nil {
-		return errors.Wrap(err, "Failed to encode generated metadata")
-	}
-
+func (runner *Runner) createArtifacts(tarPath string) error {
 	for _, name := range []string{"tmp", "logs"} {
-		if err = os.MkdirAll(filepath.Join(runner.contentsDir, name), 0755); err != nil {
+		if err := os.MkdirAll(filepath.Join(runner.contentsDir, name), 0755); err != nil {
 			return errors.Wrap(err, "Failed to set up droplet filesystem")
 		}
 	}
 
 	appDir := filepath.Join(runner.contentsDir, "app")
-	err = runner.copyApp(runner.config.BuildDir, appDir)
+	err := runner.copyApp(runner.config.BuildDir, appDir)
 	if err != nil {
 		return errors.Wrap(err, "Failed to copy compiled droplet")
 	}
 
-	err = exec.Command(tarPath, "-czf", runner.config.OutputDropletLocation, "-C", runner.contentsDir, ".").Run()
+	runner.dropletDigests, err = runner.compressDir(tarPath, runner.contentsDir, runner.config.OutputDropletLocation)
 	if err != nil {
 		return errors.Wrap(err, "Failed to compress droplet filesystem")
 	}
@@ -145,11 +235,55 @@ func (runner *Runner) createCache(tarPath string) error {
 		return errors.Wrap(err, "Failed to create output build artifacts cache dir")
 	}
 
-	err = exec.Command(tarPath, "-czf", runner.config.OutputBuildArtifactsCache, "-C", runner.config.BuildArtifactsCacheDir(), ".").Run()
+	runner.cacheDigests, err = runner.compressDir(tarPath, runner.config.BuildArtifactsCacheDir(), runner.config.OutputBuildArtifactsCache)
 
 	return errors.Wrap(err, "Failed to compress build artifacts")
 }
 
+// compressDir archives srcDir into destination and returns the digests of
+// the compressed archive, computed while it is written.
+func (runner *Runner) compressDir(tarPath, srcDir, destination string) (*Digests, error) {
+	destFile, err := os.Create(destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", destination, err)
+	}
+	defer destFile.Close()
+
+	sha256Hash := sha256.New()
+	sha1Hash := sha1.New() // #nosec G401
+
+	compressedWriter, err := runner.config.Compression.writer(io.MultiWriter(destFile, sha256Hash, sha1Hash))
+	if err != nil {
+		return nil, err
+	}
+
+	tarCmd := exec.Command(tarPath, "-cf", "-", "-C", srcDir, ".")
+	tarCmd.Stdout = compressedWriter
+	tarCmd.Stderr = runner.BuildpackErr
+
+	err = tarCmd.Run()
+	runner.recordUsage(tarCmd.ProcessState)
+
+	if err != nil {
+		compressedWriter.Close()
+
+		return nil, fmt.Errorf("failed to archive %s: %w", srcDir, err)
+	}
+
+	if err = compressedWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress %s: %w", srcDir, err)
+	}
+
+	if err = destFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close %s: %w", destination, err)
+	}
+
+	return &Digests{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+	}, nil
+}
+
 func (runner *Runner) buildpacksMetadata(buildpacks []string) []BuildpackMetadata {
 	data := make([]BuildpackMetadata, len(buildpacks))
 	for i, key := range buildpacks {
@@ -213,7 +347,8 @@ func (runner *Runner) makeDirectories() error {
 
 func (runner *Runner) cleanCacheDir() error {
 	neededCacheDirs := map[string]bool{
-		filepath.Join(runner.config.BuildArtifactsCacheDir(), "final"): true,
+		filepath.Join(runner.config.BuildArtifactsCacheDir(), "final"):       true,
+		filepath.Join(runner.config.BuildArtifactsCacheDir(), hooksCacheDir): true,
 	}
 
 	for _, bp := range runner.config.SupplyBuildpacks() {
@@ -239,6 +374,14 @@ func (runner *Runner) cleanCacheDir() error {
 }
 
 func (runner *Runner) buildpackPath(buildpack string) (string, error) {
+	if installedPath, ok := runner.config.BuildpackPaths[buildpack]; ok {
+		if !runner.pathHasBinDirectory(installedPath) {
+			return "", errors.Errorf("malformed buildpack does not contain a /bin dir: %s", buildpack)
+		}
+
+		return installedPath, nil
+	}
+
 	buildpackPath := BuildpackPath(runner.config.BuildpacksDir, buildpack)
 
 	if runner.pathHasBinDirectory(buildpackPath) {
@@ -285,6 +428,12 @@ func fileExists(file string) (bool, error) {
 }
 
 func (runner *Runner) runSupplyBuildpacks() (string, []BuildpackMetadata, error) {
+	if err := runner.checkStacks(); err != nil {
+		logError(err.Error())
+
+		return "", nil, err
+	}
+
 	if err := runner.validateSupplyBuildpacks(); err != nil {
 		return "", nil, err
 	}
@@ -376,8 +525,9 @@ func (runner *Runner) handleNoFinalize(buildpackPath, cacheDir, depsIdx string)
 		logError(MissingFinalizeWarnMsg)
 	}
 
-	// remove unused deps sub dir
-	if err := os.RemoveAll(filepath.Join(runner.depsDir, depsIdx)); err != nil {
+	// remove the deps sub dir compile does not use, unless a pre-staging
+	// hook wrote to it
+	if err := removeIfEmpty(filepath.Join(runner.depsDir, depsIdx)); err != nil {
 		return NewCompileFailError(err)
 	}
 
@@ -420,7 +570,16 @@ func (runner *Runner) detect() (string, []BuildpackMetadata, error) {
 			continue
 		}
 
+		if err = runner.checkStack(buildpack, buildpackPath); err != nil {
+			logError(fmt.Sprintf("Skipping buildpack: %s", err.Error()))
+
+			continue
+		}
+
 		output, err := runner.runWithCapturing(exec.Command(filepath.Join(buildpackPath, "bin", "detect"), runner.config.BuildDir))
+		if _, ok := err.(ResourceLimitError); ok {
+			logError(err.Error())
+		}
 
 		if err == nil {
 			buildpacks := runner.buildpacksMetadata([]string{buildpack})
@@ -448,13 +607,7 @@ func (runner *Runner) readProcfile() (map[string]string, error) {
 		return processes, fmt.Errorf("error reading Procfile: %w", err)
 	}
 
-	err = yaml.Unmarshal(procFile, &processes)
-	if err != nil {
-		// clobber yaml parsing  error
-		return processes, errors.Wrap(err, "invalid YAML")
-	}
-
-	return processes, nil
+	return ParseProcfile(procFile)
 }
 
 func (runner *Runner) release(buildpackDir string) (Release, error) {
@@ -463,16 +616,37 @@ func (runner *Runner) release(buildpackDir string) (Release, error) {
 		return Release{}, errors.Wrap(err, "Failed to read command from Procfile")
 	}
 
-	output, err := runner.runWithCapturing(exec.Command(filepath.Join(buildpackDir, "bin", "release"), runner.config.BuildDir))
+	var parsedRelease Release
+
+	if runner.config.Raw {
+		parsedRelease = runner.rawRelease()
+	} else {
+		output, err := runner.runWithCapturing(exec.Command(filepath.Join(buildpackDir, "bin", "release"), runner.config.BuildDir))
+		if err != nil {
+			return Release{}, errors.Wrap(err, "no release script")
+		}
+
+		err = yaml.Unmarshal(output.Bytes(), &parsedRelease)
+		if err != nil {
+			return Release{}, errors.Wrap(err, "buildpack's release output invalid")
+		}
+	}
+
+	launchProcessTypes, sidecars, err := runner.readLaunchYMLs()
 	if err != nil {
-		return Release{}, errors.Wrap(err, "no release script")
+		return Release{}, err
 	}
 
-	parsedRelease := Release{}
+	runner.sidecars = sidecars
 
-	err = yaml.Unmarshal(output.Bytes(), &parsedRelease)
-	if err != nil {
-		return Release{}, errors.Wrap(err, "buildpack's release output invalid")
+	// launch.yml processes are defaults: the release output and the Procfile
+	// take precedence
+	for k, v := range parsedRelease.DefaultProcessTypes {
+		launchProcessTypes[k] = v
+	}
+
+	if len(launchProcessTypes) > 0 {
+		parsedRelease.DefaultProcessTypes = launchProcessTypes
 	}
 
 	if len(startCommands) > 0 {
@@ -499,39 +673,152 @@ func (runner *Runner) saveInfo(buildpacks []BuildpackMetadata, releaseInfo Relea
 		lastBuildpack = buildpacks[len(buildpacks)-1]
 	}
 
-	resultFile, err := os.Create(runner.config.OutputMetadataLocation)
-	if err != nil {
-		return fmt.Errorf("failed to create output metadata location: %w", err)
-	}
-	defer resultFile.Close()
-
-	return json.NewEncoder(resultFile).Encode(NewStagingResult(
+	stagingResult := NewStagingResult(
 		releaseInfo.DefaultProcessTypes,
 		LifecycleMetadata{
 			BuildpackKey:      lastBuildpack.Key,
 			DetectedBuildpack: lastBuildpack.Name,
 			Buildpacks:        buildpacks,
 		},
-	))
+	)
+	stagingResult.DropletCompression = runner.config.Compression.Format()
+	stagingResult.DropletLayers = runner.dropletLayers
+	stagingResult.Sidecars = runner.sidecars
+	stagingResult.DropletDigests = runner.dropletDigests
+	stagingResult.BuildArtifactsCacheDigests = runner.cacheDigests
+	stagingResult.ConfigVars = releaseInfo.ConfigVars
+	stagingResult.Addons = releaseInfo.Addons
+
+	return runner.writeStagingResult(stagingResult)
+}
+
+func (runner *Runner) writeStagingResult(stagingResult StagingResult) error {
+	resultFile, err := os.Create(runner.config.OutputMetadataLocation)
+	if err != nil {
+		return fmt.Errorf("failed to create output metadata location: %w", err)
+	}
+	defer resultFile.Close()
+
+	return json.NewEncoder(resultFile).Encode(stagingResult)
 }
 
 func (runner *Runner) run(cmd *exec.Cmd) error {
-	cmd.Stdout = runner.BuildpackOut
-	cmd.Stderr = runner.BuildpackErr
+	stdout := runner.output.writer()
+	defer stdout.Flush()
 
-	return cmd.Run()
+	stderr := runner.output.writer()
+	defer stderr.Flush()
+
+	cmd.Stdout = io.MultiWriter(runner.BuildpackOut, stdout)
+	cmd.Stderr = io.MultiWriter(runner.BuildpackErr, stderr)
+
+	return runner.runScript(cmd)
 }
 
 func (runner *Runner) runWithCapturing(cmd *exec.Cmd) (*bytes.Buffer, error) {
+	stderr := runner.output.writer()
+	defer stderr.Flush()
+
 	output := new(bytes.Buffer)
 	cmd.Stdout = output
-	cmd.Stderr = runner.BuildpackErr
+	cmd.Stderr = io.MultiWriter(runner.BuildpackErr, stderr)
+
+	return output, runner.runScript(cmd)
+}
+
+// runScript runs a buildpack script with the buildpack environment and
+// resource limits.
+func (runner *Runner) runScript(cmd *exec.Cmd) error {
+	scriptPath := cmd.Path
+	limits := runner.config.ResourceLimits
+
+	cmd.Env = runner.buildpackEnv()
+	if err := limits.apply(cmd); err != nil {
+		return err
+	}
+
+	phase, buildpack := runner.scriptPhase(scriptPath)
 
-	return output, cmd.Run()
+	endPhase := runner.startPhase(phase, buildpack)
+	err := cmd.Run()
+	runner.recordUsage(cmd.ProcessState)
+	endPhase()
+
+	if limit, exceeded := limits.exceeded(err); exceeded {
+		return ResourceLimitError{
+			Buildpack: buildpack,
+			Phase:     phase,
+			Limit:     limit,
+		}
+	}
+
+	return err
+}
+
+// scriptPhase names the phase a script runs in and the buildpack or hook it
+// belongs to.
+func (runner *Runner) scriptPhase(scriptPath string) (string, string) {
+	if phase, ok := runner.hookPhase(scriptPath); ok {
+		return phase, filepath.Base(scriptPath)
+	}
+
+	return filepath.Base(scriptPath), runner.buildpackName(scriptPath)
+}
+
+// buildpackName finds the buildpack a script belongs to, falling back to the
+// script's buildpack directory for buildpacks not in the configured order.
+func (runner *Runner) buildpackName(scriptPath string) string {
+	for _, buildpack := range runner.config.BuildpackOrder {
+		dir := BuildpackPath(runner.config.BuildpacksDir, buildpack)
+		if installedPath, ok := runner.config.BuildpackPaths[buildpack]; ok {
+			dir = installedPath
+		}
+
+		if strings.HasPrefix(scriptPath, dir+string(filepath.Separator)) {
+			return buildpack
+		}
+	}
+
+	return filepath.Dir(filepath.Dir(scriptPath))
 }
 
+// buildpackEnv is the environment buildpack scripts run with: the allowed
+// platform variables of the executor's environment plus the app and staging
+// group variables of the staging environment. It is computed once so that
+// removed variables are only logged once.
+func (runner *Runner) buildpackEnv() []string {
+	if runner.env != nil {
+		return runner.env
+	}
+
+	allowList := runner.config.EnvAllowList
+	if allowList == nil {
+		allowList = DefaultEnvAllowList
+	}
+
+	kept, removed := scrubEnv(os.Environ(), allowList)
+	if len(removed) > 0 {
+		log.Printf("Removed environment variables not passed to buildpacks: %s", strings.Join(removed, ", "))
+	}
+
+	runner.env = mergeEnv(kept, runner.config.StagingEnvironment.Vars())
+
+	return runner.env
+}
+
+// copyApp copies the compiled app in full. The .cfignore was applied to the
+// app bits before staging, and buildpacks may well create files it matches.
 func (runner *Runner) copyApp(buildDir, stageDir string) error {
-	return runner.run(exec.Command("cp", "-a", buildDir, stageDir))
+	stats, err := CopyDir(buildDir, stageDir, nil)
+	if err != nil {
+		return err
+	}
+
+	if stats.SkippedFiles > 0 {
+		log.Printf("Skipped %d special files (sockets, devices or pipes)", stats.SkippedFiles)
+	}
+
+	return nil
 }
 
 func (runner *Runner) warnIfDetectNotExecutable(buildpackPath string) error {
@@ -540,7 +827,7 @@ func (runner *Runner) warnIfDetectNotExecutable(buildpackPath string) error {
 		return errors.Wrap(err, "failed to find detect script")
 	}
 
-	if fileInfo.Mode()&executableMask != executableMask {
+	if fileInfo.Mode()&ExecutableBits != ExecutableBits {
 		log.Println("WARNING: buildpack script '/bin/detect' is not executable")
 	}
 
@@ -574,3 +861,21 @@ func (runner *Runner) writeStagingInfoYML(startCommand string, buildpacks []Buil
 		StartCommand:      startCommand,
 	})
 }
+
+// removeIfEmpty removes dir if it exists and is empty.
+func removeIfEmpty(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(files) > 0 {
+		return nil
+	}
+
+	return os.Remove(dir)
+}
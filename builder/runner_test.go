@@ -2,6 +2,8 @@ package builder_test
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,8 +14,10 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/oci"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
@@ -30,6 +34,19 @@ var _ = Describe("Building", func() {
 		outputBuildArtifactsCache string
 		skipDetect                bool
 		buildpackOrder            string
+		compression               builder.Compression
+		outputImageLayout         string
+		outputLayersDir           string
+		stagingEnvironment        builder.StagingEnvironment
+		envAllowList              []string
+		resourceLimits            builder.ResourceLimits
+		outputSBOM                string
+		stack                     string
+		preStagingHooksDir        string
+		postStagingHooksDir       string
+		raw                       bool
+		startCommand              string
+		buildpackPaths            map[string]string
 
 		runner *builder.Runner
 		logOut *gbytes.Buffer
@@ -73,6 +90,19 @@ var _ = Describe("Building", func() {
 		buildpackOrder = ""
 
 		skipDetect = false
+		compression = builder.Compression{}
+		outputImageLayout = ""
+		outputLayersDir = ""
+		stagingEnvironment = builder.StagingEnvironment{}
+		envAllowList = nil
+		resourceLimits = builder.ResourceLimits{}
+		outputSBOM = filepath.Join(tmpDir, "sbom.cdx.json")
+		stack = ""
+		preStagingHooksDir = ""
+		postStagingHooksDir = ""
+		raw = false
+		startCommand = ""
+		buildpackPaths = nil
 		logOut = gbytes.NewBuffer()
 		log.SetOutput(logOut)
 	})
@@ -93,6 +123,19 @@ var _ = Describe("Building", func() {
 			BuildpackOrder:            strings.Split(buildpackOrder, ","),
 			BuildArtifactsCache:       filepath.Join(tmpDir, "cache"),
 			SkipDetect:                skipDetect,
+			Compression:               compression,
+			OutputImageLayout:         outputImageLayout,
+			OutputLayersDir:           outputLayersDir,
+			StagingEnvironment:        stagingEnvironment,
+			EnvAllowList:              envAllowList,
+			ResourceLimits:            resourceLimits,
+			OutputSBOMLocation:        outputSBOM,
+			Stack:                     stack,
+			PreStagingHooksDir:        preStagingHooksDir,
+			PostStagingHooksDir:       postStagingHooksDir,
+			Raw:                       raw,
+			StartCommand:              startCommand,
+			BuildpackPaths:            buildpackPaths,
 		}
 
 		runner = builder.NewRunner(&conf)
@@ -101,13 +144,33 @@ var _ = Describe("Building", func() {
 		userFacingError = runner.Run()
 	})
 
+	// resultJSON returns result.json without the artifact digests, which
+	// change with the mtimes of the archived files.
 	resultJSON := func() []byte {
 		resultInfo, err := ioutil.ReadFile(outputMetadata)
 		Expect(err).NotTo(HaveOccurred())
 
+		result := map[string]interface{}{}
+		Expect(json.Unmarshal(resultInfo, &result)).To(Succeed())
+		delete(result, "droplet_digests")
+		delete(result, "build_artifacts_cache_digests")
+
+		resultInfo, err = json.Marshal(result)
+		Expect(err).NotTo(HaveOccurred())
+
 		return resultInfo
 	}
 
+	fileDigests := func(path string) *builder.Digests {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		return &builder.Digests{
+			SHA256: fmt.Sprintf("%x", sha256.Sum256(contents)),
+			SHA1:   fmt.Sprintf("%x", sha1.Sum(contents)),
+		}
+	}
+
 	resultJSONbuildpacks := func() []byte {
 		result := resultJSON()
 		var stagingResult builder.StagingResult
@@ -188,6 +251,10 @@ var _ = Describe("Building", func() {
 					Expect(files).NotTo(ContainElement(MatchRegexp("\\./tmp/.+")))
 				})
 
+				It("should contain the SBOM", func() {
+					Expect(files).To(ContainElement("./sbom.cdx.json"))
+				})
+
 				It("should contain an empty /logs directory", func() {
 					Expect(files).To(ContainElement("./logs/"))
 					Expect(files).NotTo(ContainElement(MatchRegexp("\\./logs/.+")))
@@ -222,7 +289,8 @@ var _ = Describe("Building", func() {
 								{"key": "always-detects", "name": "Always Matching"}
 							]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"the start command\"}",
+						"droplet_compression": "gzip"
 				}`))
 				})
 
@@ -242,7 +310,8 @@ var _ = Describe("Building", func() {
 									{ "key": "always-detects", "name": "Always Matching" }
 								]
 							},
-							"execution_metadata": ""
+							"execution_metadata": "{\"start_command\":\"procfile-provided start-command\"}",
+							"droplet_compression": "gzip"
 					 }`))
 					})
 
@@ -271,7 +340,8 @@ var _ = Describe("Building", func() {
 									{ "key": "always-detects", "name": "Always Matching" }
 								]
 							},
-							"execution_metadata": ""
+							"execution_metadata": "{\"start_command\":\"the start command\"}",
+							"droplet_compression": "gzip"
 					 }`))
 					})
 
@@ -320,7 +390,8 @@ var _ = Describe("Building", func() {
 									{ "key": "always-detects", "name": "" }
 							  ]
 							},
-							"execution_metadata": ""
+							"execution_metadata": "{\"start_command\":\"the start command\"}",
+							"droplet_compression": "gzip"
 					}`))
 				})
 			})
@@ -494,7 +565,8 @@ var _ = Describe("Building", func() {
 										{ "key": "release-without-command", "name": "Release Without Command" }
 									]
 								},
-								"execution_metadata": ""
+								"execution_metadata": "{\"start_command\":\"procfile-provided start-command\"}",
+								"droplet_compression": "gzip"
 							}`))
 					})
 				})
@@ -522,7 +594,8 @@ var _ = Describe("Building", func() {
 										{ "key": "release-without-command", "name": "Release Without Command" }
 									]
 								},
-								"execution_metadata": ""
+								"execution_metadata": "{\"start_command\":\"\"}",
+								"droplet_compression": "gzip"
 							}`))
 					})
 				})
@@ -572,7 +645,8 @@ var _ = Describe("Building", func() {
 							  { "key": "always-detects", "name": "Always Matching" }
 						  ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"procfile-provided start-command\"}",
+						"droplet_compression": "gzip"
 					}`))
 				})
 			})
@@ -597,7 +671,8 @@ var _ = Describe("Building", func() {
 							  { "key": "always-detects", "name": "Always Matching" }
 						  ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"the start command\"}",
+						"droplet_compression": "gzip"
 					}`))
 				})
 			})
@@ -619,7 +694,8 @@ var _ = Describe("Building", func() {
 							  { "key": "always-detects", "name": "Always Matching" }
 						  ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"the start command\"}",
+						"droplet_compression": "gzip"
 					}`))
 			})
 
@@ -656,7 +732,8 @@ var _ = Describe("Building", func() {
 	                { "key": "always-detects-non-web", "name": "Always Detects Non-Web" }
 	            ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"procfile-provided start-command\"}",
+						"droplet_compression": "gzip"
 					}`))
 				})
 			})
@@ -684,7 +761,8 @@ var _ = Describe("Building", func() {
 	                { "key": "always-detects-non-web", "name": "Always Detects Non-Web" }
 	            ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"\"}",
+						"droplet_compression": "gzip"
 					}`))
 				})
 			})
@@ -712,7 +790,8 @@ var _ = Describe("Building", func() {
 	                { "key": "always-detects-non-web", "name": "Always Detects Non-Web" }
 	            ]
 						},
-						"execution_metadata": ""
+						"execution_metadata": "{\"start_command\":\"\"}",
+						"droplet_compression": "gzip"
 					}`))
 			})
 		})
@@ -765,6 +844,48 @@ var _ = Describe("Building", func() {
 		})
 	})
 
+	Context("when a buildpack fails with output", func() {
+		var output string
+
+		BeforeEach(func() {
+			buildpackOrder = "fails-with-output"
+
+			cpBuildpack("fails-with-output")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			var descriptiveErr builder.DescriptiveError
+			Expect(errors.As(userFacingError, &descriptiveErr)).To(BeTrue())
+			output = descriptiveErr.Output
+		})
+
+		It("attaches the tail of the output to the error", func() {
+			Expect(output).To(ContainSubstring("noise line 60"))
+			Expect(output).To(HaveSuffix("last words without a newline"))
+			Expect(output).NotTo(ContainSubstring("noise line 1 "))
+		})
+
+		It("keeps error lines that scrolled out of the tail", func() {
+			Expect(output).To(ContainSubstring("**ERROR** Could not download"))
+		})
+
+		It("redacts credentials", func() {
+			Expect(output).To(ContainSubstring("https://[REDACTED]@example.com/dep.tgz"))
+			Expect(output).To(ContainSubstring("X-Amz-Signature=[REDACTED]&expires=1"))
+			Expect(output).NotTo(ContainSubstring("hunter2"))
+			Expect(output).NotTo(ContainSubstring("abc123"))
+		})
+
+		It("does not change the error message", func() {
+			Expect(userFacingError.Error()).NotTo(ContainSubstring("noise line"))
+		})
+
+		It("respects the size limit", func() {
+			Expect(len(output)).To(BeNumerically("<=", builder.MaxOutputSummaryBytes))
+		})
+	})
+
 	Context("when a buildpack fails a supply script", func() {
 		BeforeEach(func() {
 			buildpackOrder = "fails-to-supply,always-detects"
@@ -785,6 +906,73 @@ var _ = Describe("Building", func() {
 		})
 	})
 
+	Context("with resource limits", func() {
+		BeforeEach(func() {
+			buildpackOrder = "records-limits"
+
+			cpBuildpack("records-limits")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+
+			resourceLimits = builder.ResourceLimits{
+				Processes: 512,
+				OpenFiles: 256,
+				FileSize:  10 * 1024 * 1024,
+				CPUTime:   90 * time.Second,
+			}
+		})
+
+		It("applies them to the buildpack scripts", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			limits, err := ioutil.ReadFile(filepath.Join(buildDir, "limits"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(limits)).To(Equal("processes 512\nopen-files 256\nfile-size 10240\ncpu-time 90\n"))
+		})
+
+		Context("when a script exceeds the file size limit", func() {
+			BeforeEach(func() {
+				buildpackOrder = "exceeds-file-size"
+				cpBuildpack("exceeds-file-size")
+
+				resourceLimits = builder.ResourceLimits{FileSize: 1024 * 1024}
+			})
+
+			It("fails with an error naming the buildpack, phase and limit", func() {
+				Expect(userFacingError).To(MatchError(ContainSubstring("buildpack exceeds-file-size exceeded the file size limit of 1048576 bytes during compile")))
+				Expect(getDescriptiveErrorExitCode(userFacingError)).To(Equal(223))
+			})
+
+			Context("when the script exits with 128+SIGXFSZ but the file size limit is not set", func() {
+				BeforeEach(func() {
+					resourceLimits = builder.ResourceLimits{OpenFiles: 256}
+
+					compile := filepath.Join(builder.BuildpackPath(buildpacksDir, "exceeds-file-size"), "bin", "compile")
+					Expect(ioutil.WriteFile(compile, []byte("#!/bin/bash\nexit 153\n"), 0755)).To(Succeed())
+				})
+
+				It("fails like any other compile", func() {
+					Expect(userFacingError).NotTo(MatchError(ContainSubstring("exceeded the file size limit")))
+					Expect(getDescriptiveErrorExitCode(userFacingError)).To(Equal(223))
+				})
+			})
+		})
+
+		Context("when a script exceeds the CPU time limit", func() {
+			BeforeEach(func() {
+				buildpackOrder = "exceeds-cpu-time"
+				skipDetect = true
+				cpBuildpack("exceeds-cpu-time")
+
+				resourceLimits = builder.ResourceLimits{CPUTime: time.Second}
+			})
+
+			It("fails with an error naming the buildpack, phase and limit", func() {
+				Expect(userFacingError).To(MatchError(ContainSubstring("buildpack exceeds-cpu-time exceeded the CPU time limit of 1s during supply")))
+				Expect(getDescriptiveErrorExitCode(userFacingError)).To(Equal(225))
+			})
+		})
+	})
+
 	Context("when a buildpack that isn't last doesn't have a supply script", func() {
 		BeforeEach(func() {
 			buildpackOrder = "has-finalize-no-supply,has-finalize"
@@ -872,6 +1060,1048 @@ var _ = Describe("Building", func() {
 		})
 	})
 
+	Context("with a configured compression", func() {
+		BeforeEach(func() {
+			buildpackOrder = "always-detects"
+
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		Context("gzip with a level", func() {
+			BeforeEach(func() {
+				compression = builder.Compression{Codec: "gzip", Level: 1}
+			})
+
+			It("produces a gzipped droplet", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+				result, err := exec.Command("tar", "-tzf", outputDroplet).Output()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(result)).To(ContainSubstring("./app/app.sh"))
+			})
+
+			It("writes a build artifacts cache the next staging can restore", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+
+				restoreDir, err := ioutil.TempDir("", "restored-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(restoreDir)
+
+				Expect(builder.ExtractArchive(outputBuildArtifactsCache, restoreDir)).To(Succeed())
+				Expect(filepath.Join(restoreDir, "final", "compiled")).To(BeAnExistingFile())
+			})
+
+			It("records the format in the result.json", func() {
+				var stagingResult builder.StagingResult
+				Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+				Expect(stagingResult.DropletCompression).To(Equal("gzip"))
+			})
+		})
+
+		Context("zstd", func() {
+			BeforeEach(func() {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					Skip("zstd is not installed")
+				}
+				compression = builder.Compression{Codec: "zstd"}
+			})
+
+			It("produces a zstd compressed droplet and build artifacts cache", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+
+				for _, artifact := range []string{outputDroplet, outputBuildArtifactsCache} {
+					contents, err := ioutil.ReadFile(artifact)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(contents[:4]).To(Equal([]byte{0x28, 0xb5, 0x2f, 0xfd}))
+				}
+
+				result, err := exec.Command("tar", "-I", "zstd", "-tf", outputDroplet).Output()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(result)).To(ContainSubstring("./app/app.sh"))
+			})
+
+			It("records the format in the result.json", func() {
+				var stagingResult builder.StagingResult
+				Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+				Expect(stagingResult.DropletCompression).To(Equal("zstd"))
+			})
+
+			It("writes a build artifacts cache the next staging can restore", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+
+				restoreDir, err := ioutil.TempDir("", "restored-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(restoreDir)
+
+				Expect(builder.ExtractArchive(outputBuildArtifactsCache, restoreDir)).To(Succeed())
+				Expect(filepath.Join(restoreDir, "final", "compiled")).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Context("with an OCI image layout output", func() {
+		var layout *oci.Layout
+
+		BeforeEach(func() {
+			buildpackOrder = "always-detects"
+			outputImageLayout = filepath.Join(tmpDir, "image")
+			layout = &oci.Layout{Root: outputImageLayout}
+
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+			cp(filepath.Join(appFixtures, "with-procfile", "Procfile"), buildDir)
+		})
+
+		readImage := func() (oci.Manifest, oci.Image) {
+			index, err := layout.Index()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index.Manifests).To(HaveLen(1))
+
+			var manifest oci.Manifest
+			Expect(layout.ReadJSONBlob(index.Manifests[0].Digest, &manifest)).To(Succeed())
+
+			var image oci.Image
+			Expect(layout.ReadJSONBlob(manifest.Config.Digest, &image)).To(Succeed())
+
+			return manifest, image
+		}
+
+		It("is successful", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+		})
+
+		It("uses the start command from staging_info.yml as the image command", func() {
+			_, image := readImage()
+			Expect(image.Config.Cmd).To(Equal([]string{"the start command"}))
+			Expect(image.Config.Entrypoint[0]).To(Equal("/bin/bash"))
+			Expect(image.Config.WorkingDir).To(Equal("/home/vcap/app"))
+			Expect(image.Config.Env).To(ContainElement("HOME=/home/vcap/app"))
+			Expect(image.Config.Env).To(ContainElement("DEPS_DIR=/home/vcap/deps"))
+		})
+
+		It("records the detected buildpack and process types as labels", func() {
+			_, image := readImage()
+			Expect(image.Config.Labels).To(HaveKeyWithValue("org.cloudfoundry.detected-buildpack", "Always Matching"))
+			Expect(image.Config.Labels["org.cloudfoundry.process-types"]).To(MatchJSON(`{"web":"the start command","spider":"bogus command"}`))
+			Expect(image.Config.Labels).NotTo(HaveKey("org.cloudfoundry.stack"))
+		})
+
+		Context("when the stack is known", func() {
+			BeforeEach(func() {
+				stack = "cflinuxfs3"
+			})
+
+			It("labels the image with the stack it must be layered onto", func() {
+				_, image := readImage()
+				Expect(image.Config.Labels).To(HaveKeyWithValue("org.cloudfoundry.stack", "cflinuxfs3"))
+			})
+		})
+
+		It("contains a single app layer rooted at /home/vcap", func() {
+			manifest, _ := readImage()
+			Expect(manifest.Layers).To(HaveLen(1))
+
+			result, err := exec.Command("tar", "-tzf", layout.BlobPath(manifest.Layers[0].Digest)).Output()
+			Expect(err).NotTo(HaveOccurred())
+			files := removeTrailingSpace(strings.Split(string(result), "\n"))
+			Expect(files).To(ContainElement("home/vcap/app/app.sh"))
+			Expect(files).To(ContainElement("home/vcap/staging_info.yml"))
+			Expect(files).To(ContainElement("home/vcap/profile.d/"))
+			Expect(files).To(ContainElement("home/vcap/deps/"))
+		})
+	})
+
+	Context("with layered droplet output", func() {
+		BeforeEach(func() {
+			buildpackOrder = "always-detects-creates-build-artifacts,has-finalize"
+			skipDetect = true
+			outputLayersDir = filepath.Join(tmpDir, "layers")
+
+			cpBuildpack("always-detects-creates-build-artifacts")
+			cpBuildpack("has-finalize")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		layerFiles := func(layer builder.DropletLayer) []string {
+			result, err := exec.Command("tar", "-tzf", builder.LayerPath(outputLayersDir, layer)).Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			return removeTrailingSpace(strings.Split(string(result), "\n"))
+		}
+
+		It("writes a manifest listing the deps and app layers", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			manifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Layers).To(HaveLen(2))
+			Expect(manifest.Layers[0].Name).To(Equal("deps"))
+			Expect(manifest.Layers[1].Name).To(Equal("app"))
+
+			for _, layer := range manifest.Layers {
+				contents, err := ioutil.ReadFile(builder.LayerPath(outputLayersDir, layer))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fmt.Sprintf("sha256:%x", sha256.Sum256(contents))).To(Equal(layer.Digest))
+				Expect(int64(len(contents))).To(Equal(layer.Size))
+			}
+		})
+
+		It("separates the dependencies from the app", func() {
+			manifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			depsFiles := layerFiles(manifest.Layers[0])
+			Expect(depsFiles).To(ContainElement("deps/0/supplied"))
+			Expect(depsFiles).NotTo(ContainElement("app/app.sh"))
+
+			appFiles := layerFiles(manifest.Layers[1])
+			Expect(appFiles).To(ContainElement("app/app.sh"))
+			Expect(appFiles).To(ContainElement("profile.d/finalized.sh"))
+			Expect(appFiles).To(ContainElement("staging_info.yml"))
+			Expect(appFiles).NotTo(ContainElement("deps/0/supplied"))
+		})
+
+		It("records the layers in the result.json", func() {
+			manifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			var stagingResult builder.StagingResult
+			Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+			Expect(stagingResult.DropletLayers).To(Equal(manifest.Layers))
+		})
+
+		It("produces the same deps layer when only the app changes", func() {
+			firstManifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "app.sh"), []byte("echo changed"), 0755)).To(Succeed())
+			runner.CleanUp()
+			Expect(runner.Run()).To(Succeed())
+
+			secondManifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secondManifest.Layers[0].Digest).To(Equal(firstManifest.Layers[0].Digest))
+			Expect(secondManifest.Layers[1].Digest).NotTo(Equal(firstManifest.Layers[1].Digest))
+		})
+
+		It("produces the same app layer when the app does not change", func() {
+			firstManifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(filepath.Join(buildDir, "app.sh"), later, later)).To(Succeed())
+			runner.CleanUp()
+			Expect(runner.Run()).To(Succeed())
+
+			secondManifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secondManifest.Layers[1].Digest).To(Equal(firstManifest.Layers[1].Digest))
+		})
+
+		It("compresses the layers with gzip by default", func() {
+			manifest, err := builder.ReadLayersManifest(outputLayersDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, layer := range manifest.Layers {
+				Expect(layer.MediaType).To(Equal("application/gzip"))
+				Expect(builder.LayerPath(outputLayersDir, layer)).To(HaveSuffix(".tgz"))
+				Expect(builder.DetectFormat(builder.LayerPath(outputLayersDir, layer))).To(Equal("gzip"))
+			}
+		})
+
+		Context("with zstd compression", func() {
+			BeforeEach(func() {
+				compression = builder.Compression{Codec: builder.CodecZstd}
+			})
+
+			It("compresses the layers with zstd", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+
+				manifest, err := builder.ReadLayersManifest(outputLayersDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, layer := range manifest.Layers {
+					Expect(layer.MediaType).To(Equal("application/zstd"))
+					Expect(builder.LayerPath(outputLayersDir, layer)).To(HaveSuffix(".tar.zst"))
+					Expect(builder.LayerPath(outputLayersDir, layer)).To(BeAnExistingFile())
+					Expect(builder.DetectFormat(builder.LayerPath(outputLayersDir, layer))).To(Equal("zstd"))
+
+					contents, err := ioutil.ReadFile(builder.LayerPath(outputLayersDir, layer))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fmt.Sprintf("sha256:%x", sha256.Sum256(contents))).To(Equal(layer.Digest))
+				}
+			})
+		})
+	})
+
+	Context("with an app that has a .cfignore", func() {
+		var files []string
+
+		BeforeEach(func() {
+			buildpackOrder = "always-detects"
+			cpBuildpack("always-detects")
+
+			cp(filepath.Join(appFixtures, "with-cfignore")+"/.", buildDir)
+			Expect(os.MkdirAll(filepath.Join(buildDir, ".git"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644)).To(Succeed())
+			Expect(os.Symlink("app.sh", filepath.Join(buildDir, "start"))).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			result, err := exec.Command("tar", "-tvzf", outputDroplet).Output()
+			Expect(err).NotTo(HaveOccurred())
+			files = removeTrailingSpace(strings.Split(string(result), "\n"))
+		})
+
+		It("copies the compiled app in full", func() {
+			Expect(files).To(ContainElement(HaveSuffix("./app/debug.log")))
+			Expect(files).To(ContainElement(HaveSuffix("./app/keep.log")))
+			Expect(files).To(ContainElement(HaveSuffix("./app/lib/tmp-cache/lib.rb")))
+		})
+
+		Context("when a buildpack creates files the .cfignore matches", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, ".cfignore"), []byte("compiled\n"), 0644)).To(Succeed())
+			})
+
+			It("keeps them in the droplet", func() {
+				Expect(files).To(ContainElement(HaveSuffix("./app/compiled")))
+			})
+		})
+
+		It("preserves modes and symlinks", func() {
+			Expect(files).To(ContainElement(And(HavePrefix("-rwxr-xr-x"), HaveSuffix("./app/app.sh"))))
+			Expect(files).To(ContainElement(And(HavePrefix("l"), HaveSuffix("./app/start -> app.sh"))))
+		})
+	})
+
+	Context("with a buildpack that writes a launch.yml", func() {
+		var stagingResult builder.StagingResult
+
+		BeforeEach(func() {
+			buildpackOrder = "supplies-launch-yml,has-finalize"
+			skipDetect = true
+
+			cpBuildpack("supplies-launch-yml")
+			cpBuildpack("has-finalize")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+		})
+
+		It("adds the declared sidecars to the result.json", func() {
+			Expect(stagingResult.Sidecars).To(Equal([]builder.Sidecar{{
+				Name:         "config-server",
+				ProcessTypes: []string{"web", "worker"},
+				Command:      "bin/config-server --port 8082",
+				Memory:       64,
+			}}))
+		})
+
+		It("merges the declared process types below the release output", func() {
+			Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{
+				"web":    "the start command",
+				"worker": "bin/worker",
+			}))
+		})
+
+		Context("when the app has a Procfile", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("worker: procfile worker"), 0644)).To(Succeed())
+			})
+
+			It("lets the Procfile override the declared process types", func() {
+				Expect(stagingResult.ProcessTypes).To(HaveKeyWithValue("worker", "procfile worker"))
+			})
+		})
+	})
+
+	Context("with installed buildpack paths", func() {
+		var installedPath string
+
+		BeforeEach(func() {
+			buildpackOrder = "always-detects"
+			skipDetect = true
+
+			installedPath = filepath.Join(tmpDir, "installed", "always-detects-1.0")
+			Expect(os.MkdirAll(filepath.Dir(installedPath), 0755)).To(Succeed())
+			cp(filepath.Join(buildpackFixtures, "always-detects"), installedPath)
+			buildpackPaths = map[string]string{"always-detects": installedPath}
+
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		It("runs the buildpack from its installed path", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			var stagingResult builder.StagingResult
+			Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+			Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{"web": "the start command"}))
+		})
+
+		It("names the buildpack in the staging report", func() {
+			report, err := builder.ReadStagingReport(filepath.Join(filepath.Dir(outputMetadata), builder.StagingReportFile))
+			Expect(err).NotTo(HaveOccurred())
+
+			buildpacks := map[string]string{}
+			for _, phase := range report.Phases {
+				buildpacks[phase.Name] = phase.Buildpack
+			}
+			Expect(buildpacks).To(HaveKeyWithValue("compile", "always-detects"))
+			Expect(buildpacks).To(HaveKeyWithValue("release", "always-detects"))
+		})
+
+		Context("when the installed path has no bin directory", func() {
+			BeforeEach(func() {
+				Expect(os.RemoveAll(filepath.Join(installedPath, "bin"))).To(Succeed())
+			})
+
+			It("fails", func() {
+				Expect(userFacingError).To(MatchError(ContainSubstring("malformed buildpack does not contain a /bin dir: always-detects")))
+			})
+		})
+	})
+
+	Context("with a buildpack that releases config vars and addons", func() {
+		var stagingResult builder.StagingResult
+
+		BeforeEach(func() {
+			buildpackOrder = "releases-config-vars"
+
+			cpBuildpack("releases-config-vars")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+		})
+
+		It("adds the config vars and addons to the result.json", func() {
+			Expect(stagingResult.ConfigVars).To(Equal(map[string]string{
+				"PATH":         "$HOME/bin:$PATH",
+				"JAVA_OPTS":    `-Xss512k -Dgreeting="hello world"`,
+				"invalid-name": "ignored",
+			}))
+			Expect(stagingResult.Addons).To(Equal([]string{"heroku-postgresql:dev"}))
+		})
+
+		It("exports the config vars from a profile.d script in the droplet", func() {
+			script, err := exec.Command("tar", "-xzOf", outputDroplet, "./profile.d/00-config-vars.sh").Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			env, err := exec.Command("bash", "-c", string(script)+`echo "$PATH"; echo "$JAVA_OPTS"`).Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			lines := strings.Split(strings.TrimSpace(string(env)), "\n")
+			Expect(lines).To(Equal([]string{
+				os.Getenv("HOME") + "/bin:" + os.Getenv("PATH"),
+				`-Xss512k -Dgreeting="hello world"`,
+			}))
+		})
+
+		It("ignores config vars that are not valid variable names", func() {
+			Expect(logOut).To(gbytes.Say(`Ignoring config var with invalid name "invalid-name"`))
+		})
+	})
+
+	Context("with the raw lifecycle", func() {
+		var stagingResult builder.StagingResult
+
+		BeforeEach(func() {
+			raw = true
+			startCommand = "./app.sh"
+
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(resultJSON(), &stagingResult)).To(Succeed())
+		})
+
+		It("uses the start command as the web process", func() {
+			Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{"web": "./app.sh"}))
+		})
+
+		It("reports the synthetic raw buildpack", func() {
+			Expect(stagingResult.LifecycleMetadata).To(Equal(builder.LifecycleMetadata{
+				BuildpackKey:      builder.RawBuildpackKey,
+				DetectedBuildpack: builder.RawBuildpackName,
+				Buildpacks: []builder.BuildpackMetadata{
+					{Key: builder.RawBuildpackKey, Name: builder.RawBuildpackName},
+				},
+			}))
+		})
+
+		It("packages the app as-is", func() {
+			app, err := exec.Command("tar", "-xzOf", outputDroplet, "./app/app.sh").Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			expected, err := ioutil.ReadFile(filepath.Join(appFixtures, "bash-app", "app.sh"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app).To(Equal(expected))
+		})
+
+		It("writes the start command to staging_info.yml", func() {
+			stagingInfo, err := exec.Command("tar", "-xzOf", outputDroplet, "./staging_info.yml").Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stagingInfo).To(MatchJSON(`{"detected_buildpack":"Raw","start_command":"./app.sh"}`))
+		})
+
+		Context("when the app has a Procfile", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: ./app.sh --procfile\nworker: ./app.sh --worker"), 0644)).To(Succeed())
+			})
+
+			It("lets the Procfile override the start command", func() {
+				Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{
+					"web":    "./app.sh --procfile",
+					"worker": "./app.sh --worker",
+				}))
+			})
+		})
+
+		Context("when buildpacks are configured", func() {
+			BeforeEach(func() {
+				buildpackOrder = "always-fails-detect"
+				cpBuildpack("always-fails-detect")
+			})
+
+			It("does not run them", func() {
+				Expect(stagingResult.LifecycleMetadata.BuildpackKey).To(Equal(builder.RawBuildpackKey))
+			})
+		})
+	})
+
+	Context("artifact digests", func() {
+		var stagingResult builder.StagingResult
+
+		BeforeEach(func() {
+			buildpackOrder = "always-detects-creates-build-artifacts"
+
+			cpBuildpack("always-detects-creates-build-artifacts")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(outputMetadata)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(contents, &stagingResult)).To(Succeed())
+		})
+
+		It("records the digests of the droplet", func() {
+			Expect(stagingResult.DropletDigests).To(Equal(fileDigests(outputDroplet)))
+		})
+
+		It("records the digests of the build artifacts cache", func() {
+			Expect(stagingResult.BuildArtifactsCacheDigests).To(Equal(fileDigests(outputBuildArtifactsCache)))
+		})
+
+		Context("with zstd compression", func() {
+			BeforeEach(func() {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					Skip("zstd is not installed")
+				}
+
+				compression = builder.Compression{Codec: builder.CodecZstd}
+			})
+
+			It("records the digests of the compressed droplet", func() {
+				Expect(stagingResult.DropletDigests).To(Equal(fileDigests(outputDroplet)))
+			})
+		})
+	})
+
+	Context("SBOM", func() {
+		var sbom builder.SBOM
+
+		BeforeEach(func() {
+			buildpackOrder = "supplies-dependencies,always-detects"
+			skipDetect = true
+
+			cpBuildpack("supplies-dependencies")
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "with-lockfiles")+"/.", buildDir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(outputSBOM)
+			Expect(err).NotTo(HaveOccurred())
+
+			sbom = builder.SBOM{}
+			Expect(json.Unmarshal(contents, &sbom)).To(Succeed())
+		})
+
+		components := func() []string {
+			names := []string{}
+			for _, component := range sbom.Components {
+				names = append(names, fmt.Sprintf("%s %s@%s", component.Type, component.Name, component.Version))
+			}
+
+			return names
+		}
+
+		It("is a CycloneDX document", func() {
+			Expect(sbom.BOMFormat).To(Equal("CycloneDX"))
+			Expect(sbom.SpecVersion).To(Equal("1.4"))
+		})
+
+		It("lists the buildpacks and the dependencies they installed", func() {
+			Expect(components()).To(ContainElement("framework supplies-dependencies@1.2.3"))
+			Expect(components()).To(ContainElement("application node@18.17.1"))
+			Expect(components()).To(ContainElement("framework always-detects@"))
+		})
+
+		It("does not list dependencies that were not installed", func() {
+			Expect(components()).NotTo(ContainElement(ContainSubstring("python")))
+		})
+
+		It("does not list the versions of a dependency that were not installed", func() {
+			Expect(components()).NotTo(ContainElement(ContainSubstring("node@20.5.0")))
+		})
+
+		Context("when the installed version of a dependency is not known", func() {
+			BeforeEach(func() {
+				supply := filepath.Join(builder.BuildpackPath(buildpacksDir, "supplies-dependencies"), "bin", "supply")
+				Expect(ioutil.WriteFile(supply, []byte("#!/bin/bash\nmkdir -p $3/$4/node/bin\n"), 0755)).To(Succeed())
+			})
+
+			It("lists the dependency without a version", func() {
+				Expect(components()).To(ContainElement("application node@"))
+				Expect(components()).NotTo(ContainElement(ContainSubstring("node@18.17.1")))
+				Expect(components()).NotTo(ContainElement(ContainSubstring("node@20.5.0")))
+			})
+		})
+
+		Context("when the manifest has a single version of a dependency for the stack", func() {
+			BeforeEach(func() {
+				stack = "cflinuxfs4"
+
+				buildpackPath := builder.BuildpackPath(buildpacksDir, "supplies-dependencies")
+				Expect(ioutil.WriteFile(filepath.Join(buildpackPath, "manifest.yml"), []byte(`---
+dependencies:
+- name: node
+  version: 18.17.1
+  cf_stacks: [cflinuxfs3]
+- name: node
+  version: 20.5.0
+  cf_stacks: [cflinuxfs4]
+`), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildpackPath, "bin", "supply"), []byte("#!/bin/bash\nmkdir -p $3/$4/node/bin\n"), 0755)).To(Succeed())
+			})
+
+			It("lists that version", func() {
+				Expect(components()).To(ContainElement("application node@20.5.0"))
+				Expect(components()).NotTo(ContainElement(ContainSubstring("node@18.17.1")))
+			})
+		})
+
+		It("lists the packages pinned by the app's lockfiles", func() {
+			Expect(components()).To(ContainElement("library express@4.18.2"))
+			Expect(components()).To(ContainElement("library @types/node@20.4.5"))
+			Expect(components()).To(ContainElement("library rack@2.2.7"))
+			Expect(components()).To(ContainElement("library sinatra@3.0.6"))
+			Expect(components()).To(ContainElement("library flask@2.3.2"))
+			Expect(components()).To(ContainElement("library requests@2.31.0"))
+			Expect(components()).NotTo(ContainElement(ContainSubstring("gunicorn")))
+		})
+
+		It("lists each version of a package pinned at several versions once", func() {
+			Expect(components()).To(ContainElement("library debug@4.3.4"))
+			Expect(components()).To(ContainElement("library debug@2.6.9"))
+
+			debugVersions := []string{}
+			for _, component := range sbom.Components {
+				if component.Name == "debug" {
+					debugVersions = append(debugVersions, component.Version)
+				}
+			}
+			Expect(debugVersions).To(Equal([]string{"2.6.9", "4.3.4"}))
+		})
+
+		It("records package URLs and where each component came from", func() {
+			Expect(sbom.Components).To(ContainElement(builder.SBOMComponent{
+				BOMRef:  "pkg:gem/sinatra@3.0.6",
+				Type:    "library",
+				Name:    "sinatra",
+				Version: "3.0.6",
+				PURL:    "pkg:gem/sinatra@3.0.6",
+				Properties: []builder.SBOMProperty{
+					{Name: "cloudfoundry:source", Value: "Gemfile.lock"},
+				},
+			}))
+		})
+
+		It("stores the same SBOM in the droplet", func() {
+			inDroplet, err := exec.Command("tar", "-xzOf", outputDroplet, "./sbom.cdx.json").Output()
+			Expect(err).NotTo(HaveOccurred())
+
+			uploaded, err := ioutil.ReadFile(outputSBOM)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(inDroplet).To(MatchJSON(uploaded))
+		})
+	})
+
+	Context("stack compatibility", func() {
+		BeforeEach(func() {
+			stack = "cflinuxfs4"
+
+			cpBuildpack("stack-specific")
+			cpBuildpack("cflinuxfs3-dependencies")
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		Context("when detecting", func() {
+			BeforeEach(func() {
+				buildpackOrder = "stack-specific,cflinuxfs3-dependencies,always-detects"
+			})
+
+			It("skips buildpacks that do not support the stack", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+				Expect(resultJSONbuildpacks()).To(MatchJSON(`[{"key": "always-detects", "name": "Always Matching"}]`))
+			})
+
+			It("logs why they were skipped", func() {
+				Expect(logOut).To(gbytes.Say("Skipping buildpack: buildpack stack-specific does not support stack cflinuxfs4, it supports: cflinuxfs3"))
+				Expect(logOut).To(gbytes.Say("Skipping buildpack: buildpack cflinuxfs3-dependencies does not support stack cflinuxfs4"))
+			})
+
+			Context("and the stack is supported", func() {
+				BeforeEach(func() {
+					stack = "cflinuxfs3"
+				})
+
+				It("detects the first buildpack", func() {
+					Expect(userFacingError).NotTo(HaveOccurred())
+					Expect(resultJSONbuildpacks()).To(MatchJSON(`[{"key": "stack-specific", "name": "Stack Specific"}]`))
+				})
+			})
+
+			Context("and no stack is configured", func() {
+				BeforeEach(func() {
+					stack = ""
+				})
+
+				It("does not check stacks", func() {
+					Expect(userFacingError).NotTo(HaveOccurred())
+					Expect(resultJSONbuildpacks()).To(MatchJSON(`[{"key": "stack-specific", "name": "Stack Specific"}]`))
+				})
+			})
+
+			Context("and only the staging environment names the stack", func() {
+				BeforeEach(func() {
+					stack = ""
+					stagingEnvironment.Stack = "cflinuxfs4"
+				})
+
+				It("checks against it", func() {
+					Expect(resultJSONbuildpacks()).To(MatchJSON(`[{"key": "always-detects", "name": "Always Matching"}]`))
+				})
+			})
+		})
+
+		Context("when skipping detect", func() {
+			BeforeEach(func() {
+				buildpackOrder = "cflinuxfs3-dependencies,always-detects"
+				skipDetect = true
+			})
+
+			It("fails before running any buildpack", func() {
+				Expect(userFacingError).To(MatchError(ContainSubstring("buildpack cflinuxfs3-dependencies does not support stack cflinuxfs4, it supports: cflinuxfs3")))
+				Expect(getDescriptiveErrorExitCode(userFacingError)).To(Equal(builder.StackFailCode))
+
+				hash := fmt.Sprintf("%x", md5.Sum([]byte("cflinuxfs3-dependencies")))
+				Expect(filepath.Join(tmpDir, "cache", hash, "supplied")).NotTo(BeAnExistingFile())
+			})
+		})
+	})
+
+	Context("with staging hooks", func() {
+		hooksFixture := func(name string) string {
+			dir, err := filepath.Abs(filepath.Join("fixtures", "hooks", name))
+			Expect(err).NotTo(HaveOccurred())
+
+			return dir
+		}
+
+		BeforeEach(func() {
+			buildpackOrder = "always-detects"
+			preStagingHooksDir = hooksFixture("pre")
+			postStagingHooksDir = hooksFixture("post")
+
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		It("runs the executable hooks in order around the buildpacks, with the buildpack arguments", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			hooksLog, err := exec.Command("tar", "-xzOf", outputDroplet, "./app/hooks.log").Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(hooksLog)).To(Equal("pre 01-first 5\npre 02-second 5\npost inject-agent 5\n"))
+		})
+
+		It("lets post-staging hooks write to profile.d", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			agent, err := exec.Command("tar", "-xzOf", outputDroplet, "./profile.d/agent.sh").Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(agent)).To(Equal("export AGENT_ENABLED=true\n"))
+		})
+
+		It("gives each hook a cache dir in the build artifacts cache", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			files, err := exec.Command("tar", "-tzf", outputBuildArtifactsCache).Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Split(string(files), "\n")).To(ContainElement("./hooks/inject-agent/agent-cache"))
+		})
+
+		It("skips files that are not executable", func() {
+			Expect(logOut).To(gbytes.Say("skipping hook README: not an executable file"))
+		})
+
+		Context("when a pre-staging hook writes to the deps dir of a compile-only buildpack", func() {
+			BeforeEach(func() {
+				preStagingHooksDir = filepath.Join(tmpDir, "deps-hooks")
+				Expect(os.MkdirAll(preStagingHooksDir, 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(preStagingHooksDir, "add-agent"), []byte("#!/bin/bash\nmkdir -p $3/$4\necho agent > $3/$4/agent\n"), 0755)).To(Succeed())
+			})
+
+			It("keeps what the hook wrote", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+
+				agent, err := exec.Command("tar", "-xzOf", outputDroplet, "./deps/0/agent").Output()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(agent)).To(Equal("agent\n"))
+			})
+		})
+
+		It("removes the unused deps dir of a compile-only buildpack", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+
+			files, err := exec.Command("tar", "-tzf", outputDroplet).Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Split(string(files), "\n")).NotTo(ContainElement("./deps/0/"))
+		})
+
+		Context("when a hook fails", func() {
+			BeforeEach(func() {
+				preStagingHooksDir = hooksFixture("failing")
+			})
+
+			It("fails staging with a hook failure", func() {
+				Expect(userFacingError).To(MatchError(ContainSubstring("pre-staging-hook scan-secrets failed")))
+				Expect(getDescriptiveErrorExitCode(userFacingError)).To(Equal(builder.HookFailCode))
+			})
+
+			It("attaches the hook output", func() {
+				var descriptiveErr builder.DescriptiveError
+				Expect(errors.As(userFacingError, &descriptiveErr)).To(BeTrue())
+				Expect(descriptiveErr.Output).To(ContainSubstring("found an AWS secret key"))
+			})
+		})
+
+		Context("when the hooks directory does not exist", func() {
+			BeforeEach(func() {
+				preStagingHooksDir = filepath.Join(tmpDir, "no-hooks")
+			})
+
+			It("stages without hooks", func() {
+				Expect(userFacingError).NotTo(HaveOccurred())
+				Expect(logOut).To(gbytes.Say("hooks directory .* does not exist"))
+			})
+		})
+	})
+
+	Context("staging report", func() {
+		var report builder.StagingReport
+
+		BeforeEach(func() {
+			buildpackOrder = "has-finalize,always-detects"
+			skipDetect = true
+
+			cpBuildpack("has-finalize")
+			cpBuildpack("always-detects")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			report, err = builder.ReadStagingReport(filepath.Join(filepath.Dir(outputMetadata), builder.StagingReportFile))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		phaseNames := func() []string {
+			names := []string{}
+			for _, phase := range report.Phases {
+				names = append(names, strings.TrimSpace(phase.Name+" "+phase.Buildpack))
+			}
+
+			return names
+		}
+
+		It("records every phase in order", func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			Expect(phaseNames()).To(Equal([]string{
+				"clean-cache",
+				"supply has-finalize",
+				"compile always-detects",
+				"release always-detects",
+				"tar",
+				"cache",
+			}))
+		})
+
+		It("records the resource usage of the buildpack scripts", func() {
+			Expect(report.Phases[1].DurationSeconds).To(BeNumerically(">", 0))
+			Expect(report.Phases[1].MaxRSSKilobytes).To(BeNumerically(">", 0))
+			Expect(report.TotalSeconds).To(BeNumerically(">=", report.Phases[1].DurationSeconds))
+		})
+
+		It("summarizes the report in the logs", func() {
+			Expect(logOut).To(gbytes.Say(`Staging took \d+\.\d+s`))
+			Expect(logOut).To(gbytes.Say(`supply \(has-finalize\): \d+\.\d+s`))
+		})
+
+		Context("when staging fails", func() {
+			BeforeEach(func() {
+				buildpackOrder = "fails-to-supply,always-detects"
+				cpBuildpack("fails-to-supply")
+			})
+
+			It("still writes the report, including the error", func() {
+				Expect(userFacingError).To(HaveOccurred())
+				Expect(phaseNames()).To(Equal([]string{"clean-cache", "supply fails-to-supply"}))
+				Expect(report.Error).To(ContainSubstring("Failed to run all supply scripts"))
+			})
+		})
+	})
+
+	Context("with a staging environment", func() {
+		var compileEnv []string
+
+		BeforeEach(func() {
+			buildpackOrder = "records-env"
+			cpBuildpack("records-env")
+			cp(filepath.Join(appFixtures, "bash-app", "app.sh"), buildDir)
+
+			stagingEnvironment = builder.StagingEnvironment{
+				VcapApplication: []byte(`{"application_name":"dora"}`),
+				VcapServices:    []byte(`{"p-mysql":[]}`),
+				Stack:           "cflinuxfs3",
+				MemoryLimit:     256,
+				Environment: map[string]string{
+					"JBP_CONFIG_OPEN_JDK_JRE": "{ jre: { version: 11.+ } }",
+					"CF_STACK":                "user-provided",
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			Expect(userFacingError).NotTo(HaveOccurred())
+			contents, err := ioutil.ReadFile(filepath.Join(buildDir, "compile.env"))
+			Expect(err).NotTo(HaveOccurred())
+			compileEnv = strings.Split(string(contents), "\n")
+		})
+
+		It("passes the platform variables to the buildpack", func() {
+			Expect(compileEnv).To(ContainElement(`VCAP_APPLICATION={"application_name":"dora"}`))
+			Expect(compileEnv).To(ContainElement(`VCAP_SERVICES={"p-mysql":[]}`))
+			Expect(compileEnv).To(ContainElement("MEMORY_LIMIT=256m"))
+		})
+
+		It("passes the staging environment variables to the buildpack", func() {
+			Expect(compileEnv).To(ContainElement("JBP_CONFIG_OPEN_JDK_JRE={ jre: { version: 11.+ } }"))
+		})
+
+		It("does not let user variables override platform variables", func() {
+			Expect(compileEnv).To(ContainElement("CF_STACK=cflinuxfs3"))
+			Expect(compileEnv).NotTo(ContainElement("CF_STACK=user-provided"))
+		})
+
+		Context("when the executor environment contains secrets", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("CF_PASSWORD", "hunter2")).To(Succeed())
+				Expect(os.Setenv("BUILDPACK_CACHE_UPLOAD_URI", "https://cc/upload?signature=abc")).To(Succeed())
+				Expect(os.Setenv("EIRINI_IMAGE_REGISTRY_PASSWORD", "hunter3")).To(Succeed())
+				Expect(os.Setenv("LC_ALL", "C.UTF-8")).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv("CF_PASSWORD")).To(Succeed())
+				Expect(os.Unsetenv("BUILDPACK_CACHE_UPLOAD_URI")).To(Succeed())
+				Expect(os.Unsetenv("EIRINI_IMAGE_REGISTRY_PASSWORD")).To(Succeed())
+				Expect(os.Unsetenv("LC_ALL")).To(Succeed())
+			})
+
+			It("removes variables that are not allowed", func() {
+				Expect(compileEnv).NotTo(ContainElement(HavePrefix("CF_PASSWORD=")))
+				Expect(compileEnv).NotTo(ContainElement(HavePrefix("BUILDPACK_CACHE_UPLOAD_URI=")))
+				Expect(compileEnv).NotTo(ContainElement(HavePrefix("EIRINI_IMAGE_REGISTRY_PASSWORD=")))
+			})
+
+			It("logs the removed variables by name only", func() {
+				Expect(logOut).To(gbytes.Say("Removed environment variables not passed to buildpacks: .*BUILDPACK_CACHE_UPLOAD_URI, CF_PASSWORD, .*EIRINI_IMAGE_REGISTRY_PASSWORD"))
+				Expect(logOut).NotTo(gbytes.Say("hunter2"))
+			})
+
+			It("keeps the allowed platform variables", func() {
+				Expect(compileEnv).To(ContainElement("PATH=" + os.Getenv("PATH")))
+				Expect(compileEnv).To(ContainElement("LC_ALL=C.UTF-8"))
+			})
+
+			Context("and the allow-list is overridden", func() {
+				BeforeEach(func() {
+					envAllowList = []string{"PATH", "CF_PASSWORD"}
+				})
+
+				It("only keeps the listed variables", func() {
+					Expect(compileEnv).To(ContainElement("CF_PASSWORD=hunter2"))
+					Expect(compileEnv).NotTo(ContainElement("LC_ALL=C.UTF-8"))
+				})
+
+				It("still passes the staging environment", func() {
+					Expect(compileEnv).To(ContainElement("MEMORY_LIMIT=256m"))
+				})
+			})
+		})
+
+		Context("when the app environment is also in the executor environment", func() {
+			BeforeEach(func() {
+				stagingEnvironment.Environment["NODE_ENV"] = "production"
+
+				Expect(os.Setenv("JBP_CONFIG_FOO", "{ foo: bar }")).To(Succeed())
+				Expect(os.Setenv("NODE_ENV", "development")).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv("JBP_CONFIG_FOO")).To(Succeed())
+				Expect(os.Unsetenv("NODE_ENV")).To(Succeed())
+			})
+
+			It("only passes the app environment of the staging environment to bin/compile", func() {
+				Expect(compileEnv).To(ContainElement("NODE_ENV=production"))
+				Expect(compileEnv).NotTo(ContainElement("NODE_ENV=development"))
+				Expect(compileEnv).NotTo(ContainElement(HavePrefix("JBP_CONFIG_FOO=")))
+			})
+
+			It("logs the names of the removed app variables", func() {
+				Expect(logOut).To(gbytes.Say("Removed environment variables not passed to buildpacks: .*JBP_CONFIG_FOO, .*NODE_ENV"))
+			})
+		})
+	})
+
 	Context("with a nested buildpack", func() {
 		BeforeEach(func() {
 			nestedBuildpack := "nested-buildpack"
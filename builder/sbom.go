@@ -0,0 +1,246 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// SBOMFile is the CycloneDX SBOM stored at the root of the droplet.
+	SBOMFile = "sbom.cdx.json"
+
+	cycloneDXFormat      = "CycloneDX"
+	cycloneDXSpecVersion = "1.4"
+
+	ComponentTypeApplication = "application"
+	ComponentTypeFramework   = "framework"
+	ComponentTypeLibrary     = "library"
+
+	// SBOMPropertyBuildpack names the buildpack that installed a component.
+	SBOMPropertyBuildpack = "cloudfoundry:buildpack"
+	// SBOMPropertySource names the file a component was found in.
+	SBOMPropertySource = "cloudfoundry:source"
+)
+
+// SBOM is a CycloneDX software bill of materials listing the buildpacks
+// used to stage a droplet, the dependencies they installed and the packages
+// pinned by the app's lockfiles.
+type SBOM struct {
+	BOMFormat   string          `json:"bomFormat"`
+	SpecVersion string          `json:"specVersion"`
+	Version     int             `json:"version"`
+	Metadata    SBOMMetadata    `json:"metadata"`
+	Components  []SBOMComponent `json:"components"`
+}
+
+type SBOMMetadata struct {
+	Tools []SBOMTool `json:"tools"`
+}
+
+type SBOMTool struct {
+	Vendor string `json:"vendor"`
+	Name   string `json:"name"`
+}
+
+type SBOMComponent struct {
+	BOMRef     string         `json:"bom-ref"`
+	Type       string         `json:"type"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	PURL       string         `json:"purl,omitempty"`
+	Properties []SBOMProperty `json:"properties,omitempty"`
+}
+
+type SBOMProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// writeSBOM writes the SBOM into the droplet and, when configured, to
+// OutputSBOMLocation so that it can be uploaded next to the droplet.
+func (runner *Runner) writeSBOM(buildpacks []BuildpackMetadata) error {
+	sbom := SBOM{
+		BOMFormat:   cycloneDXFormat,
+		SpecVersion: cycloneDXSpecVersion,
+		Version:     1,
+		Metadata: SBOMMetadata{
+			Tools: []SBOMTool{{Vendor: "Cloud Foundry", Name: "eirini-staging"}},
+		},
+		Components: runner.buildpackComponents(buildpacks),
+	}
+	sbom.Components = append(sbom.Components, appComponents(runner.config.BuildDir)...)
+
+	contents, err := json.MarshalIndent(sbom, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode SBOM: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(runner.contentsDir, SBOMFile), contents, 0644); err != nil {
+		return fmt.Errorf("failed to write SBOM to the droplet: %w", err)
+	}
+
+	if runner.config.OutputSBOMLocation == "" {
+		return nil
+	}
+
+	if err = ioutil.WriteFile(runner.config.OutputSBOMLocation, contents, 0644); err != nil {
+		return fmt.Errorf("failed to write SBOM: %w", err)
+	}
+
+	return nil
+}
+
+// buildpackComponents lists the buildpacks and the dependencies from their
+// manifest.yml that were installed. Buildpacks install a dependency into a
+// directory named after it in their deps directory, which is how installed
+// dependencies are told apart from the ones that were only available.
+func (runner *Runner) buildpackComponents(buildpacks []BuildpackMetadata) []SBOMComponent {
+	components := []SBOMComponent{}
+
+	for i, buildpack := range buildpacks {
+		name := buildpack.Name
+		if name == "" {
+			name = buildpack.Key
+		}
+
+		components = append(components, SBOMComponent{
+			BOMRef:  "buildpack:" + buildpack.Key,
+			Type:    ComponentTypeFramework,
+			Name:    name,
+			Version: buildpack.Version,
+			Properties: []SBOMProperty{
+				{Name: SBOMPropertyBuildpack, Value: buildpack.Key},
+			},
+		})
+
+		buildpackPath, err := runner.buildpackPath(buildpack.Key)
+		if err != nil {
+			continue
+		}
+
+		manifest, err := readBuildpackManifest(buildpackPath)
+		if err != nil {
+			logError(fmt.Sprintf("WARNING: skipping dependencies of buildpack %s in the SBOM: %s", buildpack.Key, err.Error()))
+
+			continue
+		}
+
+		depDir := filepath.Join(runner.depsDir, runner.config.DepsIndex(i))
+
+		for _, dependency := range runner.installedDependencies(manifest, depDir) {
+			component := SBOMComponent{
+				BOMRef:  fmt.Sprintf("buildpack:%s:%s", buildpack.Key, dependency.name),
+				Type:    ComponentTypeApplication,
+				Name:    dependency.name,
+				Version: dependency.version,
+				PURL:    "pkg:generic/" + dependency.name,
+				Properties: []SBOMProperty{
+					{Name: SBOMPropertyBuildpack, Value: buildpack.Key},
+				},
+			}
+
+			if dependency.version != "" {
+				component.BOMRef += "@" + dependency.version
+				component.PURL += "@" + dependency.version
+			}
+
+			components = append(components, component)
+		}
+	}
+
+	return components
+}
+
+type installedDependency struct {
+	name    string
+	version string
+}
+
+// installedDependencies lists the dependencies of the manifest installed into
+// depDir in manifest order. The version is the one installed into a
+// directory named after it, e.g. deps/0/node/18.17.1, or else the only
+// version of the dependency the manifest has for the stack. When neither
+// tells which version was installed, the dependency is listed without one.
+func (runner *Runner) installedDependencies(manifest buildpackManifest, depDir string) []installedDependency {
+	versions := map[string][]string{}
+	names := []string{}
+
+	for _, dependency := range manifest.Dependencies {
+		if stack := runner.stack(); stack != "" && len(dependency.CFStacks) > 0 && !containsString(dependency.CFStacks, stack) {
+			continue
+		}
+
+		if _, ok := versions[dependency.Name]; !ok {
+			names = append(names, dependency.Name)
+		}
+
+		if !containsString(versions[dependency.Name], dependency.Version) {
+			versions[dependency.Name] = append(versions[dependency.Name], dependency.Version)
+		}
+	}
+
+	installed := []installedDependency{}
+
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(depDir, name)); err != nil {
+			continue
+		}
+
+		dependency := installedDependency{name: name}
+
+		if len(versions[name]) == 1 {
+			dependency.version = versions[name][0]
+		}
+
+		for _, version := range versions[name] {
+			if _, err := os.Stat(filepath.Join(depDir, name, version)); err == nil {
+				dependency.version = version
+
+				break
+			}
+		}
+
+		installed = append(installed, dependency)
+	}
+
+	return installed
+}
+
+// appComponents lists the packages pinned by the lockfiles at the root of
+// the app. Lockfiles that cannot be parsed are skipped with a warning.
+func appComponents(appDir string) []SBOMComponent {
+	components := []SBOMComponent{}
+
+	for _, lockfile := range lockfileParsers {
+		contents, err := ioutil.ReadFile(filepath.Join(appDir, lockfile.name))
+		if err != nil {
+			continue
+		}
+
+		packages, err := lockfile.parse(contents)
+		if err != nil {
+			logError(fmt.Sprintf("WARNING: skipping %s in the SBOM: %s", lockfile.name, err.Error()))
+
+			continue
+		}
+
+		for _, pkg := range packages {
+			purl := fmt.Sprintf("pkg:%s/%s@%s", lockfile.purlType, pkg.name, pkg.version)
+			components = append(components, SBOMComponent{
+				BOMRef:  purl,
+				Type:    ComponentTypeLibrary,
+				Name:    pkg.name,
+				Version: pkg.version,
+				PURL:    purl,
+				Properties: []SBOMProperty{
+					{Name: SBOMPropertySource, Value: lockfile.name},
+				},
+			})
+		}
+	}
+
+	return components
+}
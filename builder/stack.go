@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackIncompatibleError is returned for a buildpack whose manifest.yml
+// does not support the stack staging runs on.
+type StackIncompatibleError struct {
+	Buildpack string
+	Stack     string
+	Supported []string
+}
+
+func (e StackIncompatibleError) Error() string {
+	return fmt.Sprintf("buildpack %s does not support stack %s, it supports: %s", e.Buildpack, e.Stack, strings.Join(e.Supported, ", "))
+}
+
+// stack is the stack staging runs on, if known.
+func (runner *Runner) stack() string {
+	if runner.config.Stack != "" {
+		return runner.config.Stack
+	}
+
+	return runner.config.StagingEnvironment.Stack
+}
+
+// checkStack fails for buildpacks whose manifest.yml lists stacks that do
+// not include the configured one. Buildpacks without stack information in
+// their manifest are assumed to be compatible.
+func (runner *Runner) checkStack(buildpack, buildpackPath string) error {
+	stack := runner.stack()
+	if stack == "" {
+		return nil
+	}
+
+	manifest, err := readBuildpackManifest(buildpackPath)
+	if err != nil {
+		logError(fmt.Sprintf("WARNING: unable to check stack compatibility of buildpack %s: %s", buildpack, err.Error()))
+
+		return nil
+	}
+
+	supported := manifest.stacks()
+	if len(supported) == 0 {
+		return nil
+	}
+
+	for _, supportedStack := range supported {
+		if supportedStack == stack {
+			return nil
+		}
+	}
+
+	return StackIncompatibleError{Buildpack: buildpack, Stack: stack, Supported: supported}
+}
+
+// checkStacks checks every buildpack in the configured order, so that
+// staging fails before any of them runs.
+func (runner *Runner) checkStacks() error {
+	for _, buildpack := range runner.config.BuildpackOrder {
+		buildpackPath, err := runner.buildpackPath(buildpack)
+		if err != nil {
+			// reported when the buildpack is run
+			continue
+		}
+
+		if err = runner.checkStack(buildpack, buildpackPath); err != nil {
+			return NewStackIncompatibleError(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StagingEnvironment is the environment Diego gives buildpacks during
+// staging. It is passed to the executor as JSON.
+type StagingEnvironment struct {
+	VcapApplication json.RawMessage `json:"vcap_application,omitempty"`
+	VcapServices    json.RawMessage `json:"vcap_services,omitempty"`
+	Stack           string          `json:"stack,omitempty"`
+	// MemoryLimit is the app's memory limit in megabytes.
+	MemoryLimit int `json:"memory_limit,omitempty"`
+	// Environment holds the staging environment variable group and the
+	// app's own environment variables.
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+func ParseStagingEnvironment(data []byte) (StagingEnvironment, error) {
+	var env StagingEnvironment
+	if err := json.Unmarshal(data, &env); err != nil {
+		return StagingEnvironment{}, fmt.Errorf("failed to unmarshal staging environment: %w", err)
+	}
+
+	return env, nil
+}
+
+func ReadStagingEnvironment(path string) (StagingEnvironment, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return StagingEnvironment{}, fmt.Errorf("failed to read staging environment: %w", err)
+	}
+
+	return ParseStagingEnvironment(data)
+}
+
+// Vars returns the environment as KEY=value pairs. The platform variables
+// come last so that user-provided variables cannot override them.
+func (e StagingEnvironment) Vars() []string {
+	names := make([]string, 0, len(e.Environment))
+	for name := range e.Environment {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	vars := []string{}
+	for _, name := range names {
+		vars = append(vars, fmt.Sprintf("%s=%s", name, e.Environment[name]))
+	}
+
+	if len(e.VcapApplication) > 0 {
+		vars = append(vars, "VCAP_APPLICATION="+string(e.VcapApplication))
+	}
+
+	if len(e.VcapServices) > 0 {
+		vars = append(vars, "VCAP_SERVICES="+string(e.VcapServices))
+	}
+
+	if e.Stack != "" {
+		vars = append(vars, "CF_STACK="+e.Stack)
+	}
+
+	if e.MemoryLimit > 0 {
+		vars = append(vars, fmt.Sprintf("MEMORY_LIMIT=%dm", e.MemoryLimit))
+	}
+
+	return vars
+}
+
+// mergeEnv combines environments, with later values for a key replacing
+// earlier ones while keeping the position of the first occurrence.
+func mergeEnv(envs ...[]string) []string {
+	merged := []string{}
+	positions := map[string]int{}
+
+	for _, env := range envs {
+		for _, kv := range env {
+			key := strings.SplitN(kv, "=", 2)[0]
+
+			if position, ok := positions[key]; ok {
+				merged[position] = kv
+
+				continue
+			}
+
+			positions[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,56 @@
+package builder_test
+
+import (
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingEnvironment", func() {
+	Describe("ParseStagingEnvironment", func() {
+		It("parses the staging environment JSON", func() {
+			env, err := builder.ParseStagingEnvironment([]byte(`{
+				"vcap_application": {"application_name": "dora"},
+				"vcap_services": {},
+				"stack": "cflinuxfs3",
+				"memory_limit": 512,
+				"environment": {"FOO": "bar"}
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env.Stack).To(Equal("cflinuxfs3"))
+			Expect(env.MemoryLimit).To(Equal(512))
+			Expect(env.Environment).To(Equal(map[string]string{"FOO": "bar"}))
+			Expect(env.VcapApplication).To(MatchJSON(`{"application_name": "dora"}`))
+		})
+
+		It("fails on invalid JSON", func() {
+			_, err := builder.ParseStagingEnvironment([]byte(`{`))
+			Expect(err).To(MatchError(ContainSubstring("failed to unmarshal staging environment")))
+		})
+	})
+
+	Describe("Vars", func() {
+		It("returns the user variables followed by the platform variables", func() {
+			env := builder.StagingEnvironment{
+				VcapApplication: []byte(`{"name":"dora"}`),
+				VcapServices:    []byte(`{}`),
+				Stack:           "cflinuxfs3",
+				MemoryLimit:     1024,
+				Environment:     map[string]string{"B": "2", "A": "1"},
+			}
+
+			Expect(env.Vars()).To(Equal([]string{
+				"A=1",
+				"B=2",
+				`VCAP_APPLICATION={"name":"dora"}`,
+				"VCAP_SERVICES={}",
+				"CF_STACK=cflinuxfs3",
+				"MEMORY_LIMIT=1024m",
+			}))
+		})
+
+		It("leaves out unset values", func() {
+			Expect(builder.StagingEnvironment{}.Vars()).To(BeEmpty())
+		})
+	})
+})
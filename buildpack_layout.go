@@ -0,0 +1,171 @@
+package eirinistaging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+)
+
+// buildpackLayout lists the scripts a buildpack needs for what staging will
+// run of it: all of the required scripts and at least one of the phase
+// scripts.
+type buildpackLayout struct {
+	required []string
+	phases   []string
+}
+
+// layoutFor is the layout of a buildpack staging will run. When the app is
+// detected, any buildpack may turn out to be the final one. When detection is
+// skipped, only the last buildpack is final and the others only supply
+// dependencies.
+func layoutFor(skipDetect, final bool) buildpackLayout {
+	switch {
+	case !skipDetect:
+		return buildpackLayout{required: []string{"detect", "release"}, phases: []string{"compile", "finalize"}}
+	case final:
+		return buildpackLayout{required: []string{"release"}, phases: []string{"compile", "finalize"}}
+	default:
+		return buildpackLayout{phases: []string{"supply"}}
+	}
+}
+
+// MalformedBuildpackError is returned when an installed buildpack does not
+// have the layout of a buildpack.
+type MalformedBuildpackError struct {
+	Buildpack string
+	Reason    string
+}
+
+func (e MalformedBuildpackError) Error() string {
+	return fmt.Sprintf("malformed buildpack %s: %s", e.Buildpack, e.Reason)
+}
+
+// normalizeBuildpackDir moves the contents of a single top level folder,
+// which many buildpack archives have, up into dir.
+func normalizeBuildpackDir(dir string) error {
+	if dirExists(filepath.Join(dir, "bin")) {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read buildpack directory: %w", err)
+	}
+
+	if len(files) != 1 || !files[0].IsDir() {
+		return nil
+	}
+
+	// move the folder out of the way first, it may contain an entry with
+	// its own name
+	nestedDir := dir + ".nested"
+	if err = os.Rename(filepath.Join(dir, files[0].Name()), nestedDir); err != nil {
+		return fmt.Errorf("failed to move nested buildpack directory: %w", err)
+	}
+
+	nestedFiles, err := ioutil.ReadDir(nestedDir)
+	if err != nil {
+		return fmt.Errorf("failed to read nested buildpack directory: %w", err)
+	}
+
+	for _, file := range nestedFiles {
+		if err = os.Rename(filepath.Join(nestedDir, file.Name()), filepath.Join(dir, file.Name())); err != nil {
+			return fmt.Errorf("failed to move %s out of the nested buildpack directory: %w", file.Name(), err)
+		}
+	}
+
+	return os.Remove(nestedDir)
+}
+
+// validateBuildpackLayout checks that the buildpack in dir has the scripts of
+// the layout and that they are executable. Scripts that are regular files are
+// made executable, as archives created on some platforms lose the file
+// modes.
+func validateBuildpackLayout(name, dir string, layout buildpackLayout) error {
+	for _, script := range layout.required {
+		found, err := checkBuildpackScript(name, dir, script)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return MalformedBuildpackError{Buildpack: name, Reason: fmt.Sprintf("bin/%s is missing", script)}
+		}
+	}
+
+	foundPhaseScript := false
+
+	for _, script := range layout.phases {
+		found, err := checkBuildpackScript(name, dir, script)
+		if err != nil {
+			return err
+		}
+
+		foundPhaseScript = foundPhaseScript || found
+	}
+
+	if !foundPhaseScript {
+		return MalformedBuildpackError{
+			Buildpack: name,
+			Reason:    fmt.Sprintf("none of bin/%s is present", strings.Join(layout.phases, ", bin/")),
+		}
+	}
+
+	return nil
+}
+
+// checkBuildpackScript reports whether bin/<script> exists and fails if it
+// is not an executable file and cannot safely be made one.
+func checkBuildpackScript(name, dir, script string) (bool, error) {
+	scriptPath := filepath.Join(dir, "bin", script)
+
+	info, err := os.Lstat(scriptPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to stat bin/%s of buildpack %s: %w", script, name, err)
+	}
+
+	// symlinks are followed when run, but their targets are left alone
+	if info.Mode()&os.ModeSymlink != 0 {
+		info, err = os.Stat(scriptPath)
+		if err != nil {
+			return false, MalformedBuildpackError{Buildpack: name, Reason: fmt.Sprintf("bin/%s is a broken symlink", script)}
+		}
+
+		if !info.Mode().IsRegular() || info.Mode()&builder.ExecutableBits == 0 {
+			return false, MalformedBuildpackError{Buildpack: name, Reason: fmt.Sprintf("bin/%s does not link to an executable file", script)}
+		}
+
+		return true, nil
+	}
+
+	if !info.Mode().IsRegular() {
+		return false, MalformedBuildpackError{Buildpack: name, Reason: fmt.Sprintf("bin/%s is not a regular file", script)}
+	}
+
+	if info.Mode()&builder.ExecutableBits == 0 {
+		// grant execute to whoever may read the script
+		mode := info.Mode() | (info.Mode()&0444)>>2
+		if err = os.Chmod(scriptPath, mode); err != nil {
+			return false, fmt.Errorf("failed to make bin/%s of buildpack %s executable: %w", script, name, err)
+		}
+
+		log.Printf("Made bin/%s of buildpack %s executable", script, name)
+	}
+
+	return true, nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
@@ -1,6 +1,8 @@
 package eirinistaging
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,31 +19,38 @@ import (
 
 type BuildpackManager struct {
 	unzipper       Unzipper
+	untarrer       Untarrer
 	buildpackDir   string
 	buildpacksJSON string
 	internalClient *http.Client
 	defaultClient  *http.Client
 }
 
-const configFileName = "config.json"
-
 func OpenBuildpackURL(buildpackURL string, client *http.Client) ([]byte, error) {
+	bytes, _, err := openBuildpackURL(buildpackURL, client)
+
+	return bytes, err
+}
+
+// openBuildpackURL downloads the buildpack and returns the URL it was
+// downloaded from after following redirects.
+func openBuildpackURL(buildpackURL string, client *http.Client) ([]byte, string, error) {
 	resp, err := client.Get(buildpackURL)
 	if err != nil {
-		return nil, exterrors.Wrap(err, "failed to request buildpack")
+		return nil, "", exterrors.Wrap(err, "failed to request buildpack")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("downloading buildpack failed with status code %d", resp.StatusCode)
+		return nil, "", fmt.Errorf("downloading buildpack failed with status code %d", resp.StatusCode)
 	}
 
 	bytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	return bytes, nil
+	return bytes, resp.Request.URL.String(), nil
 }
 
 func NewBuildpackManager(internalClient *http.Client, defaultClient *http.Client, buildpackDir, buildpacksJSON string) Installer {
@@ -53,6 +62,7 @@ func NewBuildpackManager(internalClient *http.Client, defaultClient *http.Client
 		buildpackDir:   buildpackDir,
 		buildpacksJSON: buildpacksJSON,
 		unzipper:       Unzipper{UnzippedSizeLimit: tenGB},
+		untarrer:       Untarrer{UntarredSizeLimit: tenGB},
 	}
 }
 
@@ -66,53 +76,113 @@ func (b *BuildpackManager) Install() error {
 		return fmt.Errorf("Error unmarshaling environment variable %s: %w", b.buildpacksJSON, err)
 	}
 
+	manifest := builder.InstallManifest{Buildpacks: []builder.InstalledBuildpack{}}
+
+	skipDetect := len(buildpacks) > 0
 	for _, buildpack := range buildpacks {
-		if err := b.install(buildpack); err != nil {
+		skipDetect = skipDetect && buildpack.SkipDetect
+	}
+
+	for i, buildpack := range buildpacks {
+		installed, err := b.install(buildpack, layoutFor(skipDetect, i == len(buildpacks)-1))
+		if err != nil {
 			return fmt.Errorf("installing buildpack %s: %s failed: %w", buildpack.Name, buildpack.URL, err)
 		}
+
+		manifest.Buildpacks = append(manifest.Buildpacks, installed)
 	}
 
-	return b.writeBuildpackJSON(buildpacks)
+	return manifest.Write(filepath.Join(b.buildpackDir, builder.InstallManifestFile))
 }
 
-func (b *BuildpackManager) install(buildpack builder.Buildpack) error {
+func (b *BuildpackManager) install(buildpack builder.Buildpack, layout buildpackLayout) (builder.InstalledBuildpack, error) {
+	installed := builder.InstalledBuildpack{Buildpack: buildpack}
 	destination := builder.BuildpackPath(b.buildpackDir, buildpack.Name)
-	err := b.installFromArchive(buildpack, destination)
-	if err == nil {
-		return nil
+
+	buildpackURL, err := url.Parse(buildpack.URL)
+	if err != nil {
+		return installed, fmt.Errorf("invalid buildpack url (%s): %w", buildpack.URL, err)
 	}
 
-	if !errors.As(err, &NotZipFileError{}) {
-		return err
+	if buildpackURL.Scheme == "file" {
+		err = b.installFromFile(buildpackURL.Path, destination, &installed)
+	} else {
+		err = b.installFromArchive(buildpack, destination, &installed)
+	}
+
+	if errors.As(err, &NotZipFileError{}) {
+		err = b.installFromGit(*buildpackURL, destination, &installed)
 	}
 
-	buildpackURL, err := url.Parse(buildpack.URL)
 	if err != nil {
-		return fmt.Errorf("invalid buildpack url (%s): %w", buildpack.URL, err)
+		return installed, err
+	}
+
+	if err = normalizeBuildpackDir(destination); err != nil {
+		return installed, err
+	}
+
+	if err = validateBuildpackLayout(buildpack.Name, destination, layout); err != nil {
+		return installed, err
 	}
 
-	return GitClone(*buildpackURL, destination)
+	installed.Path = destination
+
+	return installed, nil
 }
 
-func (b *BuildpackManager) installFromArchive(buildpack builder.Buildpack, buildpackPath string) error {
-	tmpDir, err := ioutil.TempDir("", "buildpacks")
+// installFromFile installs a buildpack from a local directory or archive.
+func (b *BuildpackManager) installFromFile(path, destination string, installed *builder.InstalledBuildpack) error {
+	installed.ResolvedURL = installed.URL
+
+	info, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("temp dir creation failed: %w", err)
+		return fmt.Errorf("failed to stat buildpack: %w", err)
+	}
+
+	if !info.IsDir() {
+		bytes, err := ioutil.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return fmt.Errorf("failed to read buildpack: %w", err)
+		}
+
+		return b.extract(bytes, destination, installed)
+	}
+
+	installed.SourceType = builder.SourceFile
+
+	if _, err = builder.CopyDir(path, destination, nil); err != nil {
+		return fmt.Errorf("failed to copy buildpack directory: %w", err)
 	}
 
-	bytes, err := OpenBuildpackURL(buildpack.URL, b.internalClient)
+	return nil
+}
+
+func (b *BuildpackManager) installFromArchive(buildpack builder.Buildpack, buildpackPath string, installed *builder.InstalledBuildpack) error {
+	bytes, resolvedURL, err := openBuildpackURL(buildpack.URL, b.internalClient)
 	if err != nil {
 		var err2 error
-		bytes, err2 = OpenBuildpackURL(buildpack.URL, b.defaultClient)
+		bytes, resolvedURL, err2 = openBuildpackURL(buildpack.URL, b.defaultClient)
 		if err2 != nil {
 			return exterrors.Wrap(err, fmt.Sprintf("default client also failed: %s", err2.Error()))
 		}
 	}
 
+	installed.ResolvedURL = resolvedURL
+
+	return b.extract(bytes, buildpackPath, installed)
+}
+
+// extract extracts a zip or gzipped tarball into buildpackPath. Anything
+// else results in a NotZipFileError.
+func (b *BuildpackManager) extract(bytes []byte, buildpackPath string, installed *builder.InstalledBuildpack) error {
+	tmpDir, err := ioutil.TempDir("", "buildpacks")
+	if err != nil {
+		return fmt.Errorf("temp dir creation failed: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
 	fileName := filepath.Join(tmpDir, fmt.Sprintf("buildback-%d-.zip", time.Now().Nanosecond()))
-	defer func() {
-		err = os.Remove(fileName)
-	}()
 
 	err = ioutil.WriteFile(fileName, bytes, 0644) //nolint:gosec
 	if err != nil {
@@ -124,24 +194,48 @@ func (b *BuildpackManager) installFromArchive(buildpack builder.Buildpack, build
 		return fmt.Errorf("failed to create buildpack directory: %w", err)
 	}
 
+	digest := sha256.Sum256(bytes)
+	installed.Digest = "sha256:" + hex.EncodeToString(digest[:])
+
 	err = b.unzipper.Extract(fileName, buildpackPath)
-	if err != nil {
+	if err == nil {
+		installed.SourceType = builder.SourceZip
+
+		return nil
+	}
+
+	if !isGzip(bytes) {
 		return NotZipFileError{err: err}
 	}
 
-	return err
+	if err = b.untarrer.Extract(fileName, buildpackPath); err != nil {
+		return err
+	}
+
+	installed.SourceType = builder.SourceTgz
+
+	return nil
 }
 
-func (b *BuildpackManager) writeBuildpackJSON(buildpacks []builder.Buildpack) error {
-	bytes, err := json.Marshal(buildpacks)
-	if err != nil {
-		return fmt.Errorf("failed to marshal buildpacks JSON: %w", err)
+func (b *BuildpackManager) installFromGit(buildpackURL url.URL, destination string, installed *builder.InstalledBuildpack) error {
+	installed.SourceType = builder.SourceGit
+	installed.Digest = ""
+	installed.ResolvedURL = installed.URL
+
+	if err := GitClone(buildpackURL, destination); err != nil {
+		return err
 	}
 
-	err = ioutil.WriteFile(filepath.Join(b.buildpackDir, configFileName), bytes, 0644) //nolint:gosec
+	commit, err := GitCommit(destination)
 	if err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+		return err
 	}
 
+	installed.GitCommit = commit
+
 	return nil
 }
+
+func isGzip(bytes []byte) bool {
+	return len(bytes) >= 2 && bytes[0] == 0x1f && bytes[1] == 0x8b
+}
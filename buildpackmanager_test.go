@@ -1,8 +1,15 @@
 package eirinistaging_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,6 +18,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	eirinistaging "code.cloudfoundry.org/eirini-staging"
 	"code.cloudfoundry.org/eirini-staging/builder"
@@ -37,7 +45,7 @@ var _ = Describe("Buildpackmanager", func() {
 		buildpackDir, err = ioutil.TempDir("", "buildpacks")
 		Expect(err).ToNot(HaveOccurred())
 
-		responseContent, err = makeZippedPackage()
+		responseContent, err = makeZippedBuildpack(buildpackScripts())
 		Expect(err).ToNot(HaveOccurred())
 
 		server = ghttp.NewServer()
@@ -88,19 +96,33 @@ var _ = Describe("Buildpackmanager", func() {
 			Expect(filepath.Join(buildpackDir, yourMd5Dir)).To(BeADirectory())
 		})
 
-		It("should write a config.json file in the correct location", func() {
-			Expect(filepath.Join(buildpackDir, "config.json")).To(BeAnExistingFile())
+		It("should write an install manifest in the correct location", func() {
+			Expect(filepath.Join(buildpackDir, builder.InstallManifestFile)).To(BeAnExistingFile())
 		})
 
-		It("marshals the provided buildpacks to the config.json", func() {
-			var actualBytes []byte
-			actualBytes, err = ioutil.ReadFile(filepath.Join(buildpackDir, "config.json"))
+		It("records the provided buildpacks in the install manifest", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
 			Expect(err).ToNot(HaveOccurred())
 
-			var actualBuildpacks []builder.Buildpack
-			err = json.Unmarshal(actualBytes, &actualBuildpacks)
+			installedBuildpacks := []builder.Buildpack{}
+			for _, installed := range manifest.Buildpacks {
+				installedBuildpacks = append(installedBuildpacks, installed.Buildpack)
+			}
+			Expect(installedBuildpacks).To(Equal(buildpacks))
+		})
+
+		It("records where and from what the buildpacks were installed", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
 			Expect(err).ToNot(HaveOccurred())
-			Expect(buildpacks).To(ConsistOf(actualBuildpacks))
+
+			digest := sha256.Sum256(responseContent)
+			for i, installed := range manifest.Buildpacks {
+				Expect(installed.Path).To(Equal(builder.BuildpackPath(buildpackDir, buildpacks[i].Name)))
+				Expect(installed.SourceType).To(Equal(builder.SourceZip))
+				Expect(installed.Digest).To(Equal("sha256:" + hex.EncodeToString(digest[:])))
+				Expect(installed.ResolvedURL).To(Equal(buildpacks[i].URL))
+				Expect(installed.GitCommit).To(BeEmpty())
+			}
 		})
 	})
 
@@ -125,19 +147,284 @@ var _ = Describe("Buildpackmanager", func() {
 			Expect(filepath.Join(buildpackDir, myMd5Dir)).To(BeADirectory())
 		})
 
-		It("should write a config.json file in the correct location", func() {
-			Expect(filepath.Join(buildpackDir, "config.json")).To(BeAnExistingFile())
+		It("should write an install manifest in the correct location", func() {
+			Expect(filepath.Join(buildpackDir, builder.InstallManifestFile)).To(BeAnExistingFile())
+		})
+
+		It("records the provided buildpacks in the install manifest", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
+			Expect(err).ToNot(HaveOccurred())
+
+			installedBuildpacks := []builder.Buildpack{}
+			for _, installed := range manifest.Buildpacks {
+				installedBuildpacks = append(installedBuildpacks, installed.Buildpack)
+			}
+			Expect(installedBuildpacks).To(Equal(buildpacks))
+		})
+
+		It("records where and from what the buildpacks were installed", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
+			Expect(err).ToNot(HaveOccurred())
+
+			digest := sha256.Sum256(responseContent)
+			for i, installed := range manifest.Buildpacks {
+				Expect(installed.Path).To(Equal(builder.BuildpackPath(buildpackDir, buildpacks[i].Name)))
+				Expect(installed.SourceType).To(Equal(builder.SourceZip))
+				Expect(installed.Digest).To(Equal("sha256:" + hex.EncodeToString(digest[:])))
+				Expect(installed.ResolvedURL).To(Equal(buildpacks[i].URL))
+				Expect(installed.GitCommit).To(BeEmpty())
+			}
+		})
+	})
+
+	Context("When the buildpack is a gzipped tarball with a nested directory", func() {
+		BeforeEach(func() {
+			responseContent, err = makeTgzBuildpack("my-buildpack", buildpackScripts())
+			Expect(err).ToNot(HaveOccurred())
+
+			server = ghttp.NewServer()
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/redirect"),
+					ghttp.RespondWith(http.StatusFound, nil, http.Header{"Location": []string{"/my-buildpack.tgz"}}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/my-buildpack.tgz"),
+					ghttp.RespondWith(http.StatusOK, responseContent),
+				),
+			)
+
+			buildpacks = []builder.Buildpack{
+				{
+					Name: "my_buildpack",
+					Key:  "my-key",
+					URL:  fmt.Sprintf("%s/redirect", server.URL()),
+				},
+			}
+		})
+
+		It("should not fail", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("moves the buildpack out of the nested directory", func() {
+			installedPath := builder.BuildpackPath(buildpackDir, "my_buildpack")
+			Expect(filepath.Join(installedPath, "bin", "detect")).To(BeAnExistingFile())
+			Expect(filepath.Join(installedPath, "my-buildpack")).NotTo(BeAnExistingFile())
+		})
+
+		It("records the buildpack directory, source type and resolved URL", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Buildpacks).To(HaveLen(1))
+
+			installed := manifest.Buildpacks[0]
+			Expect(installed.Path).To(Equal(builder.BuildpackPath(buildpackDir, "my_buildpack")))
+			Expect(installed.SourceType).To(Equal(builder.SourceTgz))
+			Expect(installed.ResolvedURL).To(Equal(fmt.Sprintf("%s/my-buildpack.tgz", server.URL())))
+		})
+	})
+
+	Context("When the buildpack scripts are not executable", func() {
+		BeforeEach(func() {
+			scripts := buildpackScripts()
+			scripts["bin/detect"] = 0644
+			scripts["bin/release"] = 0600
+
+			responseContent, err = makeZippedBuildpack(scripts)
+			Expect(err).ToNot(HaveOccurred())
+
+			server = ghttp.NewServer()
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/my-buildpack"),
+					ghttp.RespondWith(http.StatusOK, responseContent),
+				),
+			)
+
+			buildpacks = []builder.Buildpack{
+				{
+					Name: "my_buildpack",
+					Key:  "my-key",
+					URL:  fmt.Sprintf("%s/my-buildpack", server.URL()),
+				},
+			}
+		})
+
+		It("makes them executable for whoever may read them", func() {
+			Expect(err).ToNot(HaveOccurred())
+
+			detect, statErr := os.Stat(filepath.Join(builder.BuildpackPath(buildpackDir, "my_buildpack"), "bin", "detect"))
+			Expect(statErr).ToNot(HaveOccurred())
+			Expect(detect.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+			release, statErr := os.Stat(filepath.Join(builder.BuildpackPath(buildpackDir, "my_buildpack"), "bin", "release"))
+			Expect(statErr).ToNot(HaveOccurred())
+			Expect(release.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		})
+	})
+
+	Context("When the buildpack is malformed", func() {
+		var scripts map[string]os.FileMode
+
+		BeforeEach(func() {
+			scripts = buildpackScripts()
+
+			server = ghttp.NewServer()
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/my-buildpack"),
+					func(w http.ResponseWriter, r *http.Request) {
+						content, zipErr := makeZippedBuildpack(scripts)
+						Expect(zipErr).ToNot(HaveOccurred())
+						_, writeErr := w.Write(content)
+						Expect(writeErr).ToNot(HaveOccurred())
+					},
+				),
+			)
+
+			buildpacks = []builder.Buildpack{
+				{
+					Name: "my_buildpack",
+					Key:  "my-key",
+					URL:  fmt.Sprintf("%s/my-buildpack", server.URL()),
+				},
+			}
+		})
+
+		When("bin/release is missing", func() {
+			BeforeEach(func() {
+				delete(scripts, "bin/release")
+			})
+
+			It("fails naming the buildpack", func() {
+				Expect(err).To(MatchError(ContainSubstring("malformed buildpack my_buildpack: bin/release is missing")))
+				Expect(errors.As(err, &eirinistaging.MalformedBuildpackError{})).To(BeTrue())
+			})
+		})
+
+		When("it has none of compile, supply and finalize", func() {
+			BeforeEach(func() {
+				delete(scripts, "bin/compile")
+			})
+
+			It("fails naming the buildpack", func() {
+				Expect(err).To(MatchError(ContainSubstring("malformed buildpack my_buildpack: none of bin/compile, bin/finalize is present")))
+			})
 		})
 
-		It("marshals the provided buildpacks to the config.json", func() {
-			var actualBytes []byte
-			actualBytes, err = ioutil.ReadFile(filepath.Join(buildpackDir, "config.json"))
+		When("it only supplies and finalizes", func() {
+			BeforeEach(func() {
+				delete(scripts, "bin/compile")
+				scripts["bin/supply"] = 0755
+				scripts["bin/finalize"] = 0755
+			})
+
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("bin/detect is missing", func() {
+			BeforeEach(func() {
+				delete(scripts, "bin/detect")
+			})
+
+			It("does not write the install manifest", func() {
+				Expect(err).To(MatchError(ContainSubstring("malformed buildpack my_buildpack: bin/detect is missing")))
+				Expect(filepath.Join(buildpackDir, builder.InstallManifestFile)).NotTo(BeAnExistingFile())
+			})
+		})
+		When("bin/detect is missing and detection is skipped", func() {
+			BeforeEach(func() {
+				delete(scripts, "bin/detect")
+				buildpacks[0].SkipDetect = true
+			})
+
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("detection is skipped for several buildpacks", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/final-buildpack"),
+						func(w http.ResponseWriter, r *http.Request) {
+							content, zipErr := makeZippedBuildpack(map[string]os.FileMode{"bin/finalize": 0755, "bin/release": 0755})
+							Expect(zipErr).ToNot(HaveOccurred())
+							_, writeErr := w.Write(content)
+							Expect(writeErr).ToNot(HaveOccurred())
+						},
+					),
+				)
+
+				buildpacks[0].SkipDetect = true
+				buildpacks = append(buildpacks, builder.Buildpack{
+					Name:       "final_buildpack",
+					Key:        "final-key",
+					URL:        fmt.Sprintf("%s/final-buildpack", server.URL()),
+					SkipDetect: true,
+				})
+			})
+
+			Context("and a supply buildpack only has bin/supply", func() {
+				BeforeEach(func() {
+					scripts = map[string]os.FileMode{"bin/supply": 0755}
+				})
+
+				It("succeeds", func() {
+					Expect(err).ToNot(HaveOccurred())
+				})
+			})
+
+			Context("and a supply buildpack has no bin/supply", func() {
+				It("fails naming the buildpack", func() {
+					Expect(err).To(MatchError(ContainSubstring("malformed buildpack my_buildpack: none of bin/supply is present")))
+				})
+			})
+		})
+	})
+
+	Context("When the buildpack url is a local directory", func() {
+		var localDir string
+
+		BeforeEach(func() {
+			localDir, err = ioutil.TempDir("", "local-buildpack")
 			Expect(err).ToNot(HaveOccurred())
+			Expect(os.MkdirAll(filepath.Join(localDir, "bin"), 0755)).To(Succeed())
+			for script := range buildpackScripts() {
+				writeFile(filepath.Join(localDir, script), "#!/bin/bash")
+			}
+
+			buildpacks = []builder.Buildpack{
+				{
+					Name: "local_buildpack",
+					Key:  "local-key",
+					URL:  "file://" + localDir,
+				},
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(localDir)).To(Succeed())
+		})
 
-			var actualBuildpacks []builder.Buildpack
-			err = json.Unmarshal(actualBytes, &actualBuildpacks)
+		It("copies the buildpack", func() {
 			Expect(err).ToNot(HaveOccurred())
-			Expect(buildpacks).To(ConsistOf(actualBuildpacks))
+			Expect(filepath.Join(builder.BuildpackPath(buildpackDir, "local_buildpack"), "bin", "detect")).To(BeAnExistingFile())
+		})
+
+		It("records it as a file buildpack", func() {
+			manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Buildpacks).To(ConsistOf(builder.InstalledBuildpack{
+				Buildpack:   buildpacks[0],
+				Path:        builder.BuildpackPath(buildpackDir, "local_buildpack"),
+				SourceType:  builder.SourceFile,
+				ResolvedURL: "file://" + localDir,
+			}))
 		})
 	})
 
@@ -230,6 +517,10 @@ var _ = Describe("Buildpackmanager", func() {
 			execute(gitBuildpackDir, gitPath, "config", "user.email", "you@example.com")
 			execute(gitBuildpackDir, gitPath, "config", "user.name", "your name")
 			writeFile(filepath.Join(gitBuildpackDir, "content"), "some content")
+			Expect(os.MkdirAll(filepath.Join(gitBuildpackDir, "bin"), 0755)).To(Succeed())
+			for script := range buildpackScripts() {
+				writeFile(filepath.Join(gitBuildpackDir, script), "#!/bin/bash")
+			}
 
 			Expect(os.RemoveAll(filepath.Join(submoduleDir, ".git"))).To(Succeed())
 			execute(submoduleDir, gitPath, "init")
@@ -285,6 +576,92 @@ var _ = Describe("Buildpackmanager", func() {
 			It("should succeed cloning the buildpack", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
+
+			It("records the cloned commit", func() {
+				commit, gitErr := exec.Command(gitPath, "-C", filepath.Join(tmpDir, "fake-buildpack"), "rev-parse", "HEAD").Output()
+				Expect(gitErr).NotTo(HaveOccurred())
+
+				manifest, err := builder.ReadInstallManifest(filepath.Join(buildpackDir, builder.InstallManifestFile))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(manifest.Buildpacks).To(HaveLen(1))
+				Expect(manifest.Buildpacks[0].SourceType).To(Equal(builder.SourceGit))
+				Expect(manifest.Buildpacks[0].GitCommit).To(Equal(strings.TrimSpace(string(commit))))
+				Expect(manifest.Buildpacks[0].Digest).To(BeEmpty())
+			})
 		})
 	})
 })
+
+// buildpackScripts are the scripts of a valid buildpack and their modes.
+func buildpackScripts() map[string]os.FileMode {
+	return map[string]os.FileMode{
+		"bin/detect":  0755,
+		"bin/compile": 0755,
+		"bin/release": 0755,
+	}
+}
+
+func makeZippedBuildpack(scripts map[string]os.FileMode) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+
+	for name, mode := range scripts {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(mode)
+
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zip entry: %w", err)
+		}
+
+		if _, err = f.Write([]byte("#!/bin/bash\n")); err != nil {
+			return nil, fmt.Errorf("failed to write zip entry: %w", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close zip writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func makeTgzBuildpack(topLevelDir string, scripts map[string]os.FileMode) ([]byte, error) {
+	buf := bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	content := []byte("#!/bin/bash\n")
+	entries := []*tar.Header{
+		{Name: topLevelDir + "/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: topLevelDir + "/bin/", Typeflag: tar.TypeDir, Mode: 0755},
+	}
+
+	for name, mode := range scripts {
+		entries = append(entries, &tar.Header{Name: topLevelDir + "/" + name, Typeflag: tar.TypeReg, Mode: int64(mode), Size: int64(len(content))})
+	}
+
+	for _, header := range entries {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if _, err := tarWriter.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write tar entry: %w", err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
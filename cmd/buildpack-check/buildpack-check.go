@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"code.cloudfoundry.org/eirini-staging/cmd"
+	"code.cloudfoundry.org/eirini-staging/conformance"
+)
+
+func main() {
+	var (
+		buildpack  string
+		supplies   cmd.StringList
+		apps       cmd.StringList
+		stack      string
+		skipDetect bool
+		verbose    bool
+	)
+
+	flag.StringVar(&buildpack, "buildpack", "", "directory of the buildpack to check")
+	flag.Var(&supplies, "supply", "directory of a buildpack to run bin/supply of before the checked one (repeatable)")
+	flag.Var(&apps, "app", "directory of a fixture app to stage (repeatable)")
+	flag.StringVar(&stack, "stack", os.Getenv("CF_STACK"), "stack to stage for")
+	flag.BoolVar(&skipDetect, "skip-detect", false, "stage without running bin/detect")
+	flag.BoolVar(&verbose, "v", false, "print staging and buildpack output")
+	flag.Parse()
+
+	if buildpack == "" || len(apps) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: buildpack-check -buildpack <dir> -app <dir> [-app <dir>...] [-supply <dir>...]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var output io.Writer = ioutil.Discard
+	if verbose {
+		output = os.Stdout
+	}
+
+	log.SetOutput(output)
+
+	checker := conformance.Checker{
+		Buildpack:        buildpack,
+		SupplyBuildpacks: supplies,
+		Apps:             apps,
+		Stack:            stack,
+		SkipDetect:       skipDetect,
+		Output:           output,
+	}
+
+	violations, err := checker.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "buildpack check failed: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+
+	if conformance.HasFailures(violations) {
+		os.Exit(1)
+	}
+
+	fmt.Println("buildpack conforms to the staging contract")
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	eirinistaging "code.cloudfoundry.org/eirini-staging"
 )
@@ -18,3 +19,16 @@ func CreateResponder(certPath string) (eirinistaging.Responder, error) {
 
 	return eirinistaging.NewResponder(stagingGUID, completionCallback, eiriniAddress, cacert, cert, key)
 }
+
+// StringList is a repeatable command line flag collecting its values.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -11,6 +9,7 @@ import (
 	eirinistaging "code.cloudfoundry.org/eirini-staging"
 	"code.cloudfoundry.org/eirini-staging/builder"
 	"code.cloudfoundry.org/eirini-staging/cmd"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
 	"code.cloudfoundry.org/eirini-staging/util"
 	exterrors "github.com/pkg/errors"
 )
@@ -25,14 +24,10 @@ func main() {
 		os.Exit(exitCode)
 	}()
 
-	buildpackCfg := os.Getenv(eirinistaging.EnvBuildpacks)
-	buildpacksDir := util.GetEnvOrDefault(eirinistaging.EnvBuildpacksDir, eirinistaging.RecipeBuildPacksDir)
-	outputDropletLocation := util.GetEnvOrDefault(eirinistaging.EnvOutputDropletLocation, eirinistaging.RecipeOutputDropletLocation)
-	outputBuildArtifactsCache := util.MustGetEnv(eirinistaging.EnvOutputBuildArtifactsCache)
-	outputMetadataLocation := util.GetEnvOrDefault(eirinistaging.EnvOutputMetadataLocation, eirinistaging.RecipeOutputMetadataLocation)
-	cacheDir := util.MustGetEnv(eirinistaging.EnvBuildArtifactsCacheDir)
 	downloadDir := util.GetEnvOrDefault(eirinistaging.EnvWorkspaceDir, eirinistaging.RecipeWorkspaceDir)
 	certPath := util.GetEnvOrDefault(eirinistaging.EnvCertsPath, eirinistaging.CCCertsMountPath)
+	outputBuildArtifactsCache := util.MustGetEnv(eirinistaging.EnvOutputBuildArtifactsCache)
+	cacheDir := util.MustGetEnv(eirinistaging.EnvBuildArtifactsCacheDir)
 
 	responder, err := cmd.CreateResponder(certPath)
 	if err != nil {
@@ -42,32 +37,28 @@ func main() {
 		return
 	}
 
-	buildDir, err := extract(downloadDir)
+	buildConfig, err := pipeline.ConfigFromEnv()
 	if err != nil {
 		responder.RespondWithFailure(exterrors.Wrap(err, ExitReason))
 		exitCode = 1
 
 		return
 	}
-	defer os.RemoveAll(buildDir)
 
-	buildConfig := builder.Config{
-		BuildDir:                  buildDir,
-		BuildpacksDir:             buildpacksDir,
-		OutputDropletLocation:     outputDropletLocation,
-		OutputBuildArtifactsCache: outputBuildArtifactsCache,
-		OutputMetadataLocation:    outputMetadataLocation,
-		BuildArtifactsCache:       cacheDir,
+	buildConfig.BuildpacksDir = util.GetEnvOrDefault(eirinistaging.EnvBuildpacksDir, eirinistaging.RecipeBuildPacksDir)
+	buildConfig.OutputDropletLocation = util.GetEnvOrDefault(eirinistaging.EnvOutputDropletLocation, eirinistaging.RecipeOutputDropletLocation)
+	buildConfig.OutputMetadataLocation = util.GetEnvOrDefault(eirinistaging.EnvOutputMetadataLocation, eirinistaging.RecipeOutputMetadataLocation)
+	buildConfig.OutputSBOMLocation = util.GetEnvOrDefault(eirinistaging.EnvOutputSBOMLocation, eirinistaging.RecipeOutputSBOMLocation)
+	buildConfig.OutputBuildArtifactsCache = outputBuildArtifactsCache
+	buildConfig.BuildArtifactsCache = cacheDir
+
+	execution := pipeline.Execution{
+		Config:        buildConfig,
+		AppBits:       filepath.Join(downloadDir, eirinistaging.AppBits),
+		ImportDroplet: os.Getenv(eirinistaging.EnvImportDroplet) == "true",
 	}
-	if err = buildConfig.InitBuildpacks(buildpackCfg); err != nil {
-		responder.RespondWithFailure(exterrors.Wrap(err, ExitReason))
-		exitCode = 1
 
-		return
-	}
-
-	err = execute(&buildConfig)
-	if err != nil {
+	if err = execution.Run(); err != nil {
 		exitCode = builder.SystemFailCode
 		var withExitCode builder.DescriptiveError
 
@@ -79,26 +70,3 @@ func main() {
 		return
 	}
 }
-
-func execute(conf *builder.Config) error {
-	runner := builder.NewRunner(conf)
-	defer runner.CleanUp()
-
-	return runner.Run()
-}
-
-func extract(downloadDir string) (string, error) {
-	var tenGB int64 = 10 * 1024 * 1024 * 1024
-	extractor := &eirinistaging.Unzipper{UnzippedSizeLimit: tenGB}
-	buildDir, err := ioutil.TempDir("", "app-bits")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp dir: %w", err)
-	}
-
-	err = extractor.Extract(filepath.Join(downloadDir, eirinistaging.AppBits), buildDir)
-	if err != nil {
-		return "", fmt.Errorf("extraction failed: %w", err)
-	}
-
-	return buildDir, nil
-}
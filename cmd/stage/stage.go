@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/cmd"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+)
+
+const (
+	// StagingResponseFile holds the response the uploader would send to
+	// Eirini once the droplet is uploaded.
+	StagingResponseFile = "staging_response.json"
+
+	dropletName = "droplet"
+	cacheName   = "cache"
+	resultFile  = "result.json"
+	sbomFile    = "sbom.cdx.json"
+)
+
+type options struct {
+	app          string
+	buildpacks   []string
+	outputDir    string
+	cache        string
+	stack        string
+	compression  string
+	stagingEnv   string
+	startCommand string
+	skipDetect   bool
+	raw          bool
+}
+
+func main() {
+	var (
+		opts       options
+		buildpacks cmd.StringList
+	)
+
+	flag.StringVar(&opts.app, "app", "", "app directory or zip file to stage")
+	flag.Var(&buildpacks, "buildpack", "buildpack directory, archive, URL or git URL (repeatable, the last one is the final buildpack)")
+	flag.StringVar(&opts.outputDir, "output", "staging-output", "directory to write the droplet, cache and result.json to")
+	flag.StringVar(&opts.cache, "cache", "", "build artifacts cache tarball of a previous staging to restore")
+	flag.StringVar(&opts.stack, "stack", os.Getenv(eirinistaging.EnvCfStack), "stack to stage for")
+	flag.StringVar(&opts.compression, "compression", "", "droplet compression, e.g. gzip:1 or zstd")
+	flag.StringVar(&opts.stagingEnv, "staging-env", "", "JSON file with the staging environment")
+	flag.StringVar(&opts.startCommand, "start-command", "", "start command of the raw lifecycle")
+	flag.BoolVar(&opts.skipDetect, "skip-detect", false, "stage without running bin/detect")
+	flag.BoolVar(&opts.raw, "raw", false, "stage the app without buildpacks")
+	flag.Parse()
+
+	opts.buildpacks = buildpacks
+
+	if opts.app == "" || (len(opts.buildpacks) == 0 && !opts.raw) {
+		fmt.Fprintln(os.Stderr, "usage: stage -app <dir|zip> -buildpack <dir|archive|url> [-buildpack ...] [-output <dir>]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	start := time.Now()
+
+	result, err := stage(opts)
+	if err != nil {
+		exitCode := builder.SystemFailCode
+
+		var withExitCode builder.DescriptiveError
+		if errors.As(err, &withExitCode) {
+			exitCode = withExitCode.ExitCode
+		}
+
+		fmt.Fprintf(os.Stderr, "staging failed (%s): %s\n", eirinistaging.StagingErrorID(err), err.Error())
+		os.Exit(exitCode)
+	}
+
+	printSummary(opts.outputDir, result, time.Since(start))
+}
+
+// stage runs what the downloader, executor and uploader do in a staging
+// pod, with local files in place of the bits service and Cloud Controller.
+func stage(opts options) (builder.StagingResult, error) {
+	workDir, err := ioutil.TempDir("", "stage")
+	if err != nil {
+		return builder.StagingResult{}, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err = os.MkdirAll(opts.outputDir, 0755); err != nil {
+		return builder.StagingResult{}, fmt.Errorf("failed to create output dir: %w", err)
+	}
+
+	buildpacksDir := filepath.Join(workDir, "buildpacks")
+	cacheDir := filepath.Join(workDir, "cache")
+
+	if err = installBuildpacks(opts, buildpacksDir); err != nil {
+		return builder.StagingResult{}, err
+	}
+
+	if err = restoreCache(opts.cache, cacheDir); err != nil {
+		return builder.StagingResult{}, err
+	}
+
+	compression, err := builder.ParseCompression(opts.compression)
+	if err != nil {
+		return builder.StagingResult{}, err
+	}
+
+	stagingEnv := builder.StagingEnvironment{}
+	if opts.stagingEnv != "" {
+		if stagingEnv, err = builder.ReadStagingEnvironment(opts.stagingEnv); err != nil {
+			return builder.StagingResult{}, err
+		}
+	}
+
+	execution := pipeline.Execution{
+		Config: builder.Config{
+			BuildDir:                  filepath.Join(workDir, "app"),
+			BuildpacksDir:             buildpacksDir,
+			OutputDropletLocation:     filepath.Join(opts.outputDir, dropletName+compression.Extension()),
+			OutputBuildArtifactsCache: filepath.Join(opts.outputDir, cacheName+compression.Extension()),
+			OutputMetadataLocation:    filepath.Join(opts.outputDir, resultFile),
+			OutputSBOMLocation:        filepath.Join(opts.outputDir, sbomFile),
+			BuildArtifactsCache:       cacheDir,
+			Compression:               compression,
+			StagingEnvironment:        stagingEnv,
+			Stack:                     opts.stack,
+			Raw:                       opts.raw,
+			StartCommand:              opts.startCommand,
+		},
+		AppBits: opts.app,
+	}
+
+	if err = execution.Run(); err != nil {
+		return builder.StagingResult{}, err
+	}
+
+	return writeStagingResponse(execution.Config.OutputMetadataLocation)
+}
+
+// installBuildpacks installs the buildpacks as the downloader does. Local
+// paths are installed through file URLs.
+func installBuildpacks(opts options, buildpacksDir string) error {
+	buildpacks := []builder.Buildpack{}
+	names := map[string]int{}
+
+	for _, location := range opts.buildpacks {
+		buildpack, err := localBuildpack(location)
+		if err != nil {
+			return err
+		}
+
+		// buildpacks are installed into directories named after them
+		names[buildpack.Name]++
+		if count := names[buildpack.Name]; count > 1 {
+			buildpack.Name = fmt.Sprintf("%s-%d", buildpack.Name, count)
+			buildpack.Key = buildpack.Name
+		}
+
+		// Cloud Controller skips detection when staging with several buildpacks
+		buildpack.SkipDetect = opts.skipDetect || len(opts.buildpacks) > 1
+		buildpacks = append(buildpacks, buildpack)
+	}
+
+	buildpacksJSON, err := json.Marshal(buildpacks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal buildpacks: %w", err)
+	}
+
+	if err = os.MkdirAll(buildpacksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create buildpacks dir: %w", err)
+	}
+
+	download := pipeline.Download{
+		Client:         http.DefaultClient,
+		DefaultClient:  http.DefaultClient,
+		BuildpacksJSON: string(buildpacksJSON),
+		BuildpacksDir:  buildpacksDir,
+	}
+
+	return download.Run()
+}
+
+// localBuildpack names a buildpack after the last element of its location
+// and turns local paths into file URLs.
+func localBuildpack(location string) (builder.Buildpack, error) {
+	buildpackURL := location
+
+	if !strings.Contains(location, "://") {
+		path, err := filepath.Abs(location)
+		if err != nil {
+			return builder.Buildpack{}, fmt.Errorf("failed to resolve buildpack path %s: %w", location, err)
+		}
+
+		buildpackURL = "file://" + path
+	}
+
+	name := filepath.Base(strings.TrimRight(buildpackURL, "/"))
+	for _, ext := range []string{".zip", ".tgz", ".tar.gz", ".git"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	return builder.Buildpack{Name: name, Key: name, URL: buildpackURL}, nil
+}
+
+// restoreCache extracts the cache of a previous staging into the cache dir,
+// as the downloader does with the cache from the bits service.
+func restoreCache(cache, cacheDir string) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	if cache == "" {
+		return nil
+	}
+
+	return pipeline.RestoreCache(cache, cacheDir)
+}
+
+// writeStagingResponse writes the response the uploader would send and
+// returns the staging result as Cloud Controller would see it.
+func writeStagingResponse(metadataLocation string) (builder.StagingResult, error) {
+	resp, err := pipeline.SuccessResponse(eirinistaging.Responder{}, metadataLocation)
+	if err != nil {
+		return builder.StagingResult{}, err
+	}
+
+	respJSON, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return builder.StagingResult{}, fmt.Errorf("failed to marshal staging response: %w", err)
+	}
+
+	responsePath := filepath.Join(filepath.Dir(metadataLocation), StagingResponseFile)
+	if err = ioutil.WriteFile(responsePath, respJSON, 0644); err != nil {
+		return builder.StagingResult{}, fmt.Errorf("failed to write staging response: %w", err)
+	}
+
+	var result builder.StagingResult
+	if err = json.Unmarshal([]byte(resp.Result), &result); err != nil {
+		return builder.StagingResult{}, fmt.Errorf("failed to unmarshal staging result: %w", err)
+	}
+
+	return result, nil
+}
+
+func printSummary(outputDir string, result builder.StagingResult, duration time.Duration) {
+	fmt.Printf("\nStaged app in %s\n", duration.Round(time.Millisecond))
+	fmt.Printf("  detected buildpack: %s\n", result.DetectedBuildpack)
+
+	for _, buildpack := range result.Buildpacks {
+		fmt.Printf("  buildpack:          %s", buildpack.Key)
+
+		if buildpack.Version != "" {
+			fmt.Printf(" %s", buildpack.Version)
+		}
+
+		fmt.Println()
+	}
+
+	processTypes := make([]string, 0, len(result.ProcessTypes))
+	for processType := range result.ProcessTypes {
+		processTypes = append(processTypes, processType)
+	}
+
+	sort.Strings(processTypes)
+
+	for _, processType := range processTypes {
+		fmt.Printf("  process %-11s %s\n", processType+":", result.ProcessTypes[processType])
+	}
+
+	dropletFile := dropletName + builder.Compression{Codec: result.DropletCompression}.Extension()
+	fmt.Printf("  droplet:            %s", filepath.Join(outputDir, dropletFile))
+
+	if result.DropletDigests != nil {
+		fmt.Printf(" (sha256 %s)", result.DropletDigests.SHA256)
+	}
+
+	fmt.Println()
+	fmt.Printf("  result:             %s\n", filepath.Join(outputDir, resultFile))
+}
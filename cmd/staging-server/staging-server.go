@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	"code.cloudfoundry.org/eirini-staging/server"
+	"code.cloudfoundry.org/eirini-staging/util"
+	"code.cloudfoundry.org/tlsconfig"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// maxWorkers caps the workers until stagings are isolated from each other:
+// they all run as the server user, so the buildpacks of concurrent stagings
+// could read and change each other's workspaces.
+const maxWorkers = 1
+
+func main() {
+	address := util.GetEnvOrDefault(eirinistaging.EnvStagingServerAddress, eirinistaging.DefaultStagingServerAddress)
+	workers := mustGetPositiveInt(eirinistaging.EnvStagingServerWorkers, eirinistaging.DefaultStagingServerWorkers)
+	if workers > maxWorkers {
+		log.Printf("ignoring %s=%d: stagings are not isolated from each other, staging with %d worker", eirinistaging.EnvStagingServerWorkers, workers, maxWorkers)
+		workers = maxWorkers
+	}
+	queueSize := mustGetPositiveInt(eirinistaging.EnvStagingServerQueueSize, eirinistaging.DefaultStagingServerQueueSize)
+	certPath := util.GetEnvOrDefault(eirinistaging.EnvCertsPath, eirinistaging.CCCertsMountPath)
+	workspaceDir := util.GetEnvOrDefault(eirinistaging.EnvWorkspaceDir, eirinistaging.RecipeWorkspaceDir)
+	eiriniAddress := util.MustGetEnv(eirinistaging.EnvEiriniAddress)
+
+	conf, err := pipeline.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid staging configuration: %s", err.Error())
+	}
+
+	cacert := filepath.Join(certPath, eirinistaging.CACertName)
+
+	client, err := util.CreateTLSHTTPClient([]util.CertPaths{{
+		Crt: filepath.Join(certPath, eirinistaging.CCAPICertName),
+		Key: filepath.Join(certPath, eirinistaging.CCAPIKeyName),
+		Ca:  cacert,
+	}})
+	if err != nil {
+		log.Fatalf("error creating http client: %s", err.Error())
+	}
+
+	stager := server.Stager{
+		WorkspaceDir:     workspaceDir,
+		Client:           client,
+		DefaultClient:    http.DefaultClient,
+		Config:           conf,
+		RegistryUsername: os.Getenv(eirinistaging.EnvImageRegistryUsername),
+		RegistryPassword: os.Getenv(eirinistaging.EnvImageRegistryPassword),
+		NewResponder: func(stagingGUID, completionCallback string) (eirinistaging.Responder, error) {
+			return eirinistaging.NewResponder(stagingGUID, completionCallback, eiriniAddress, cacert,
+				filepath.Join(certPath, eirinistaging.EiriniClientCert),
+				filepath.Join(certPath, eirinistaging.EiriniClientKey))
+		},
+	}
+
+	tlsConf, err := tlsconfig.Build(
+		tlsconfig.WithInternalServiceDefaults(),
+		tlsconfig.WithIdentityFromFile(
+			filepath.Join(certPath, eirinistaging.StagingServerCertName),
+			filepath.Join(certPath, eirinistaging.StagingServerKeyName),
+		),
+	).Server(tlsconfig.WithClientAuthenticationFromFile(cacert))
+	if err != nil {
+		log.Fatalf("failed to configure mTLS: %s", err.Error())
+	}
+
+	pool := server.NewPool(workers, queueSize, stager.Stage)
+	httpServer := &http.Server{
+		Addr:      address,
+		Handler:   server.NewHandler(pool),
+		TLSConfig: tlsConf,
+	}
+
+	go shutdownOnSignal(httpServer, pool)
+
+	log.Printf("Staging server listening on %s with %d workers", address, workers)
+
+	if err = httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("staging server failed: %s", err.Error())
+	}
+}
+
+// shutdownOnSignal stops accepting requests and lets the workers finish the
+// queued stagings, so that none is left without a completion.
+func shutdownOnSignal(httpServer *http.Server, pool *server.Pool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+
+	log.Println("Shutting down staging server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down staging server: %s", err.Error())
+	}
+
+	pool.Stop()
+	os.Exit(0)
+}
+
+func mustGetPositiveInt(env, defaultValue string) int {
+	value, err := strconv.Atoi(util.GetEnvOrDefault(env, defaultValue))
+	if err != nil || value < 1 {
+		log.Fatalf("%s must be a positive number", env)
+	}
+
+	return value
+}
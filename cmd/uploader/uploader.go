@@ -8,48 +8,48 @@ import (
 
 	eirinistaging "code.cloudfoundry.org/eirini-staging"
 	"code.cloudfoundry.org/eirini-staging/cmd"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
 	"code.cloudfoundry.org/eirini-staging/util"
 )
 
 func main() {
-	buildpacksConfig := os.Getenv(eirinistaging.EnvBuildpacks)
-	dropletUploadURL := os.Getenv(eirinistaging.EnvDropletUploadURL)
 	certPath := util.GetEnvOrDefault(eirinistaging.EnvCertsPath, eirinistaging.CCCertsMountPath)
-	dropletLocation := util.GetEnvOrDefault(eirinistaging.EnvOutputDropletLocation, eirinistaging.RecipeOutputDropletLocation)
-	metadataLocation := util.GetEnvOrDefault(eirinistaging.EnvOutputMetadataLocation, eirinistaging.RecipeOutputMetadataLocation)
-	buildpackCacheLocation := util.MustGetEnv(eirinistaging.EnvOutputBuildArtifactsCache)
-	buildpackCacheUploadURL := util.MustGetEnv(eirinistaging.EnvBuildpackCacheUploadURI)
+
+	upload := pipeline.Upload{
+		MetadataLocation: util.GetEnvOrDefault(eirinistaging.EnvOutputMetadataLocation, eirinistaging.RecipeOutputMetadataLocation),
+		DropletURI:       os.Getenv(eirinistaging.EnvDropletUploadURL),
+		DropletLocation:  util.GetEnvOrDefault(eirinistaging.EnvOutputDropletLocation, eirinistaging.RecipeOutputDropletLocation),
+		CacheLocation:    util.MustGetEnv(eirinistaging.EnvOutputBuildArtifactsCache),
+		CacheURI:         util.MustGetEnv(eirinistaging.EnvBuildpackCacheUploadURI),
+		SBOMURI:          os.Getenv(eirinistaging.EnvSBOMUploadURL),
+		SBOMLocation:     util.GetEnvOrDefault(eirinistaging.EnvOutputSBOMLocation, eirinistaging.RecipeOutputSBOMLocation),
+		LayersURI:        os.Getenv(eirinistaging.EnvDropletLayersUploadURL),
+		LayersDir:        os.Getenv(eirinistaging.EnvOutputLayersDir),
+		ImageDestination: os.Getenv(eirinistaging.EnvImageDestination),
+		ImageLayout:      os.Getenv(eirinistaging.EnvOutputImageLayout),
+		RegistryClient:   http.DefaultClient,
+		RegistryUsername: os.Getenv(eirinistaging.EnvImageRegistryUsername),
+		RegistryPassword: os.Getenv(eirinistaging.EnvImageRegistryPassword),
+		ImportDroplet:    os.Getenv(eirinistaging.EnvImportDroplet) == "true",
+	}
 
 	responder, err := cmd.CreateResponder(certPath)
 	if err != nil {
 		log.Fatal("failed to initialize responder", err)
 	}
 
-	client, err := createUploaderHTTPClient(certPath)
+	upload.Client, err = createUploaderHTTPClient(certPath)
 	if err != nil {
 		responder.RespondWithFailure(err)
 		os.Exit(1)
 	}
 
-	uploadClient := eirinistaging.DropletUploader{
-		Client: client,
-	}
-
-	err = uploadClient.Upload(dropletUploadURL, dropletLocation)
-	if err != nil {
+	if err = upload.Run(); err != nil {
 		responder.RespondWithFailure(err)
-		log.Fatalf("failed to upload droplet: %s", err.Error())
-	}
-
-	if buildpackCacheUploadURL != "" {
-		err = uploadClient.Upload(buildpackCacheUploadURL, buildpackCacheLocation)
-		if err != nil {
-			responder.RespondWithFailure(err)
-			log.Fatalf("failed to upload buildpack cache. %s", err.Error())
-		}
+		log.Fatal(err.Error())
 	}
 
-	resp, err := responder.PrepareSuccessResponse(metadataLocation, buildpacksConfig)
+	resp, err := pipeline.SuccessResponse(responder, upload.MetadataLocation)
 	if err != nil {
 		responder.RespondWithFailure(err)
 		log.Fatalf("failed to prepare response: %s", err.Error())
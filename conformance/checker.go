@@ -0,0 +1,265 @@
+// Package conformance checks buildpacks against the contract eirini-staging
+// relies on by staging fixture apps with them.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+)
+
+// Checks a Violation can be reported by.
+const (
+	CheckLayout         = "layout"
+	CheckMultiBuildpack = "multi-buildpack"
+	CheckDetect         = "detect"
+	CheckStaging        = "staging"
+	CheckRelease        = "release"
+	CheckDepsConfig     = "deps-config"
+	CheckBuildDir       = "build-dir"
+)
+
+// Violation is a breach of the buildpack contract.
+type Violation struct {
+	// App is the fixture app staged when the violation was found. It is
+	// empty for violations found without staging.
+	App     string
+	Check   string
+	Message string
+	// Warning marks a departure from the contract that staging copes with,
+	// so it does not make the buildpack fail the check.
+	Warning bool
+}
+
+func (v Violation) String() string {
+	message := v.Message
+	if v.Warning {
+		message = "warning: " + message
+	}
+
+	if v.App == "" {
+		return fmt.Sprintf("[%s] %s", v.Check, message)
+	}
+
+	return fmt.Sprintf("[%s] %s: %s", v.Check, v.App, message)
+}
+
+// HasFailures tells whether any of the violations is not just a warning.
+func HasFailures(violations []Violation) bool {
+	for _, violation := range violations {
+		if !violation.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checker stages each of Apps with the buildpacks in a sandbox and reports
+// where they break the contract.
+type Checker struct {
+	// Buildpack is the directory of the buildpack under test. It is the
+	// final buildpack after SupplyBuildpacks.
+	Buildpack        string
+	SupplyBuildpacks []string
+	Apps             []string
+	Stack            string
+	// SkipDetect stages a single buildpack without detecting the app first.
+	// Multiple buildpacks are always staged without detect.
+	SkipDetect bool
+	// Output receives the output of the buildpack scripts.
+	Output io.Writer
+}
+
+func (c Checker) Check() ([]Violation, error) {
+	violations := c.checkLayout()
+	if HasFailures(violations) {
+		// staging would fail on the missing scripts again
+		return violations, nil
+	}
+
+	for _, app := range c.Apps {
+		appViolations, err := c.checkApp(app)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check app %s: %w", app, err)
+		}
+
+		violations = append(violations, appViolations...)
+	}
+
+	return violations, nil
+}
+
+func (c Checker) checkApp(app string) ([]Violation, error) {
+	sandbox, err := ioutil.TempDir("", "buildpack-check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+	defer os.RemoveAll(sandbox)
+
+	buildDir := filepath.Join(sandbox, "app")
+	if _, err = builder.CopyDir(app, buildDir, nil); err != nil {
+		return nil, fmt.Errorf("failed to copy app: %w", err)
+	}
+
+	appName := filepath.Base(filepath.Clean(app))
+	violations := []Violation{}
+
+	if !c.multiBuildpack() {
+		violations = append(violations, c.checkDetect(appName, buildDir)...)
+	}
+
+	conf := c.stagingConfig(sandbox, buildDir)
+	runner := builder.NewRunner(&conf)
+	runner.BuildpackOut = c.output()
+	runner.BuildpackErr = c.output()
+
+	defer runner.CleanUp()
+
+	if err = runner.Run(); err != nil {
+		return append(violations, Violation{App: appName, Check: CheckStaging, Message: stagingFailure(err)}), nil
+	}
+
+	dropletDir := filepath.Join(sandbox, "droplet")
+	if err = extractDroplet(conf.OutputDropletLocation, dropletDir); err != nil {
+		return nil, err
+	}
+
+	for _, violation := range c.checkRelease(buildDir) {
+		violation.App = appName
+		violations = append(violations, violation)
+	}
+
+	for _, violation := range c.checkDepsConfig(conf, dropletDir) {
+		violation.App = appName
+		violations = append(violations, violation)
+	}
+
+	for _, violation := range checkBuildDir(app, dropletDir) {
+		violation.App = appName
+		violations = append(violations, violation)
+	}
+
+	return violations, nil
+}
+
+func (c Checker) stagingConfig(sandbox, buildDir string) builder.Config {
+	conf := builder.Config{
+		BuildDir:                  buildDir,
+		BuildpacksDir:             filepath.Join(sandbox, "buildpacks"),
+		OutputDropletLocation:     filepath.Join(sandbox, "out", "droplet.tgz"),
+		OutputBuildArtifactsCache: filepath.Join(sandbox, "out", "cache.tgz"),
+		OutputMetadataLocation:    filepath.Join(sandbox, "out", "result.json"),
+		OutputSBOMLocation:        filepath.Join(sandbox, "out", "sbom.cdx.json"),
+		BuildArtifactsCache:       filepath.Join(sandbox, "cache"),
+		Stack:                     c.Stack,
+		SkipDetect:                c.SkipDetect || c.multiBuildpack(),
+		BuildpackPaths:            map[string]string{},
+	}
+
+	// buildpacks are staged by their paths, as directories of the same name
+	// may hold different buildpacks
+	for _, buildpack := range c.buildpacks() {
+		path := filepath.Clean(buildpack)
+		conf.BuildpackOrder = append(conf.BuildpackOrder, path)
+		conf.BuildpackPaths[path] = path
+	}
+
+	return conf
+}
+
+// checkDetect runs bin/detect directly, as staging does not tell a crashing
+// detect script from one that does not detect the app.
+func (c Checker) checkDetect(app, buildDir string) []Violation {
+	cmd := exec.Command(filepath.Join(c.Buildpack, "bin", "detect"), buildDir) // #nosec G204
+	cmd.Stdout = c.output()
+	cmd.Stderr = c.output()
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return []Violation{{App: app, Check: CheckDetect, Message: fmt.Sprintf("bin/detect could not be run: %s", err.Error())}}
+	}
+
+	if exitErr.ExitCode() == 1 {
+		return nil
+	}
+
+	return []Violation{{
+		App:     app,
+		Check:   CheckDetect,
+		Message: fmt.Sprintf("bin/detect exited with %d, it must exit with 0 when it detects the app and 1 otherwise", exitErr.ExitCode()),
+	}}
+}
+
+func (c Checker) buildpacks() []string {
+	return append(append([]string{}, c.SupplyBuildpacks...), c.Buildpack)
+}
+
+func (c Checker) multiBuildpack() bool {
+	return len(c.SupplyBuildpacks) > 0
+}
+
+func (c Checker) output() io.Writer {
+	if c.Output == nil {
+		return ioutil.Discard
+	}
+
+	return c.Output
+}
+
+func buildpackName(dir string) string {
+	return filepath.Base(filepath.Clean(dir))
+}
+
+// stagingFailure explains a staging failure in terms of the buildpack
+// script that broke the contract.
+func stagingFailure(err error) string {
+	var descriptiveErr builder.DescriptiveError
+	if !errors.As(err, &descriptiveErr) {
+		return fmt.Sprintf("staging failed: %s", err.Error())
+	}
+
+	var explanation string
+
+	switch descriptiveErr.ExitCode {
+	case builder.DetectFailCode:
+		explanation = "bin/detect did not detect the app, it must exit with 0 for apps the buildpack supports"
+	case builder.SupplyFailCode:
+		explanation = "bin/supply failed, it must exit with 0 after supplying the dependencies"
+	case builder.CompileFailCode:
+		explanation = "bin/compile failed, it must exit with 0 after compiling the app"
+	case builder.FinalizeFailCode:
+		explanation = "bin/finalize failed, it must exit with 0 after finalizing the app"
+	case builder.ReleaseFailCode:
+		explanation = "bin/release failed or did not print valid YAML"
+	case builder.StackFailCode:
+		explanation = "the buildpack does not support the stack"
+	default:
+		explanation = "staging failed"
+	}
+
+	return fmt.Sprintf("%s (%s)", explanation, err.Error())
+}
+
+func extractDroplet(droplet, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create droplet dir: %w", err)
+	}
+
+	if output, err := exec.Command("tar", "-xzf", droplet, "-C", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to extract droplet: %w: %s", err, output)
+	}
+
+	return nil
+}
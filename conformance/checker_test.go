@@ -0,0 +1,213 @@
+package conformance_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/conformance"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		buildpackFixtures = filepath.Join("..", "builder", "fixtures", "buildpacks", "unix")
+		appFixtures       = filepath.Join("..", "builder", "fixtures", "apps")
+
+		checker    conformance.Checker
+		violations []conformance.Violation
+		err        error
+	)
+
+	messages := func() []string {
+		result := []string{}
+		for _, violation := range violations {
+			result = append(result, violation.String())
+		}
+
+		return result
+	}
+
+	BeforeEach(func() {
+		checker = conformance.Checker{
+			Buildpack: filepath.Join(buildpackFixtures, "always-detects"),
+			Apps:      []string{filepath.Join(appFixtures, "bash-app")},
+			Output:    GinkgoWriter,
+		}
+	})
+
+	JustBeforeEach(func() {
+		violations, err = checker.Check()
+	})
+
+	It("reports no violations for a conforming buildpack", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(violations).To(BeEmpty())
+	})
+
+	Context("when the buildpack does not detect the app", func() {
+		BeforeEach(func() {
+			checker.Buildpack = filepath.Join(buildpackFixtures, "always-fails-detect")
+		})
+
+		It("reports the staging failure", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages()).To(ConsistOf(
+				ContainSubstring("[staging] bash-app: bin/detect did not detect the app"),
+			))
+		})
+	})
+
+	Context("when bin/release prints invalid YAML", func() {
+		BeforeEach(func() {
+			checker.Buildpack = filepath.Join(buildpackFixtures, "release-generates-bad-yaml")
+		})
+
+		It("reports a release violation", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages()).To(ConsistOf(
+				ContainSubstring("[staging] bash-app: bin/release failed or did not print valid YAML"),
+			))
+		})
+	})
+
+	Context("when the buildpack breaks the script contract", func() {
+		var buildpack string
+
+		writeScript := func(name, contents string) {
+			Expect(ioutil.WriteFile(filepath.Join(buildpack, "bin", name), []byte("#!/bin/bash\n"+contents+"\n"), 0755)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			buildpack, err = ioutil.TempDir("", "malformed-buildpack")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Mkdir(filepath.Join(buildpack, "bin"), 0755)).To(Succeed())
+
+			checker.Buildpack = buildpack
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildpack)).To(Succeed())
+		})
+
+		Context("when scripts are missing", func() {
+			BeforeEach(func() {
+				writeScript("detect", "exit 0")
+				Expect(ioutil.WriteFile(filepath.Join(buildpack, "bin", "compile"), []byte("#!/bin/bash\n"), 0644)).To(Succeed())
+			})
+
+			It("reports layout violations without staging", func() {
+				Expect(err).NotTo(HaveOccurred())
+				name := filepath.Base(buildpack)
+				Expect(messages()).To(ConsistOf(
+					"[layout] "+name+" has no bin/release",
+					"[layout] "+name+": bin/compile is not an executable file",
+				))
+			})
+		})
+
+		Context("when bin/detect crashes", func() {
+			BeforeEach(func() {
+				writeScript("detect", "exit 3")
+				writeScript("compile", "exit 0")
+				writeScript("release", "echo '--- {}'")
+			})
+
+			It("reports the unexpected exit code", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages()).To(ContainElement(ContainSubstring("[detect] bash-app: bin/detect exited with 3")))
+			})
+		})
+
+		Context("when bin/release prints keys outside the contract", func() {
+			BeforeEach(func() {
+				writeScript("detect", "exit 0")
+				writeScript("compile", "exit 0")
+				writeScript("release", "printf -- '---\\ndefault_process_types:\\n  web: run\\nprocesses:\\n  web: run\\n'")
+			})
+
+			It("reports a release violation", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages()).To(ConsistOf(
+					ContainSubstring("[release] bash-app: bin/release printed YAML not matching the release contract"),
+				))
+			})
+		})
+
+		Context("when bin/compile swaps its arguments", func() {
+			BeforeEach(func() {
+				writeScript("detect", "exit 0")
+				writeScript("compile", "rm -rf \"$1\"/*")
+				writeScript("release", "echo '--- {}'")
+			})
+
+			It("reports a build dir violation", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages()).To(ConsistOf(
+					ContainSubstring("[build-dir] bash-app: the droplet contains no app files"),
+				))
+			})
+		})
+	})
+
+	Context("when staging with multiple buildpacks", func() {
+		BeforeEach(func() {
+			checker.SupplyBuildpacks = []string{filepath.Join(buildpackFixtures, "supplies-dependencies")}
+			checker.Buildpack = filepath.Join(buildpackFixtures, "has-finalize")
+		})
+
+		It("checks the config.yml of every supplying buildpack", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages()).To(ConsistOf(
+				"[deps-config] bash-app: config.yml of has-finalize must set name and version",
+			))
+		})
+
+		Context("when the final buildpack has no bin/finalize", func() {
+			BeforeEach(func() {
+				checker.Buildpack = filepath.Join(buildpackFixtures, "always-detects")
+			})
+
+			It("warns that it is staged with bin/compile", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages()).To(ConsistOf(
+					ContainSubstring("[multi-buildpack] warning: always-detects has no bin/finalize"),
+				))
+				Expect(conformance.HasFailures(violations)).To(BeFalse())
+			})
+		})
+
+		Context("when the buildpacks are in directories of the same name", func() {
+			var tmpDir string
+
+			BeforeEach(func() {
+				var tmpErr error
+				tmpDir, tmpErr = ioutil.TempDir("", "checker")
+				Expect(tmpErr).NotTo(HaveOccurred())
+
+				supply := filepath.Join(tmpDir, "supply", "buildpack")
+				final := filepath.Join(tmpDir, "final", "buildpack")
+				Expect(os.MkdirAll(filepath.Dir(supply), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Dir(final), 0755)).To(Succeed())
+				Expect(exec.Command("cp", "-a", filepath.Join(buildpackFixtures, "supplies-dependencies"), supply).Run()).To(Succeed())
+				Expect(exec.Command("cp", "-a", filepath.Join(buildpackFixtures, "has-finalize"), final).Run()).To(Succeed())
+
+				checker.SupplyBuildpacks = []string{supply}
+				checker.Buildpack = final
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(tmpDir)).To(Succeed())
+			})
+
+			It("stages each of them", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages()).To(ConsistOf(
+					"[deps-config] bash-app: config.yml of buildpack must set name and version",
+				))
+			})
+		})
+	})
+})
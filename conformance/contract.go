@@ -0,0 +1,169 @@
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// releaseContract is the only shape bin/release may print.
+type releaseContract struct {
+	DefaultProcessTypes map[string]string `yaml:"default_process_types"`
+	ConfigVars          map[string]string `yaml:"config_vars"`
+	Addons              []string          `yaml:"addons"`
+}
+
+// depsConfig is the config.yml bin/supply writes into its deps directory.
+type depsConfig struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// checkLayout checks that the buildpacks have the scripts they are run
+// with and that those are executable.
+func (c Checker) checkLayout() []Violation {
+	violations := []Violation{}
+
+	for _, buildpack := range c.SupplyBuildpacks {
+		violations = append(violations, checkScripts(buildpack, []string{"supply"}, nil)...)
+	}
+
+	violations = append(violations, checkScripts(c.Buildpack, []string{"detect", "release"}, []string{"compile", "finalize"})...)
+
+	// staging falls back to bin/compile, like Cloud Foundry does
+	if c.multiBuildpack() && !scriptExists(c.Buildpack, "finalize") {
+		violations = append(violations, Violation{
+			Check:   CheckMultiBuildpack,
+			Message: fmt.Sprintf("%s has no bin/finalize, as the final buildpack it is staged with bin/compile and does not get the dependencies supplied before it", buildpackName(c.Buildpack)),
+			Warning: true,
+		})
+	}
+
+	return violations
+}
+
+// checkScripts checks that all required and at least one of the oneOf
+// scripts exist, and that all scripts are executable.
+func checkScripts(buildpack string, required, oneOf []string) []Violation {
+	name := buildpackName(buildpack)
+	violations := []Violation{}
+
+	for _, script := range required {
+		if !scriptExists(buildpack, script) {
+			violations = append(violations, Violation{Check: CheckLayout, Message: fmt.Sprintf("%s has no bin/%s", name, script)})
+		}
+	}
+
+	foundOneOf := len(oneOf) == 0
+
+	for _, script := range oneOf {
+		foundOneOf = foundOneOf || scriptExists(buildpack, script)
+	}
+
+	if !foundOneOf {
+		violations = append(violations, Violation{Check: CheckLayout, Message: fmt.Sprintf("%s has neither bin/%s nor bin/%s", name, oneOf[0], oneOf[1])})
+	}
+
+	for _, script := range []string{"detect", "supply", "compile", "finalize", "release"} {
+		info, err := os.Stat(filepath.Join(buildpack, "bin", script))
+		if err != nil {
+			continue
+		}
+
+		if !info.Mode().IsRegular() || info.Mode()&builder.ExecutableBits == 0 {
+			violations = append(violations, Violation{Check: CheckLayout, Message: fmt.Sprintf("%s: bin/%s is not an executable file", name, script)})
+		}
+	}
+
+	return violations
+}
+
+func scriptExists(buildpack, script string) bool {
+	_, err := os.Stat(filepath.Join(buildpack, "bin", script))
+
+	return err == nil
+}
+
+// checkRelease runs bin/release on the staged app and checks that its
+// output has the shape of the release contract.
+func (c Checker) checkRelease(buildDir string) []Violation {
+	output, err := exec.Command(filepath.Join(c.Buildpack, "bin", "release"), buildDir).Output() // #nosec G204
+	if err != nil {
+		return []Violation{{Check: CheckRelease, Message: fmt.Sprintf("bin/release failed: %s", err.Error())}}
+	}
+
+	var release releaseContract
+	if err = yaml.UnmarshalStrict(output, &release); err != nil {
+		return []Violation{{Check: CheckRelease, Message: fmt.Sprintf("bin/release printed YAML not matching the release contract: %s", err.Error())}}
+	}
+
+	violations := []Violation{}
+
+	for name := range release.ConfigVars {
+		if !builder.IsEnvVarName(name) {
+			violations = append(violations, Violation{Check: CheckRelease, Message: fmt.Sprintf("config var %q is not a valid environment variable name", name)})
+		}
+	}
+
+	return violations
+}
+
+// checkDepsConfig checks the config.yml of every buildpack whose bin/supply
+// ran, which is how staging learns the name and version of the buildpack.
+func (c Checker) checkDepsConfig(conf builder.Config, dropletDir string) []Violation {
+	violations := []Violation{}
+
+	for i, buildpack := range c.buildpacks() {
+		final := i == len(c.SupplyBuildpacks)
+		if final && !(scriptExists(buildpack, "supply") && scriptExists(buildpack, "finalize")) {
+			continue
+		}
+
+		configPath := filepath.Join(dropletDir, "deps", conf.DepsIndex(i), "config.yml")
+		name := buildpackName(buildpack)
+
+		contents, err := ioutil.ReadFile(filepath.Clean(configPath))
+		if err != nil {
+			violations = append(violations, Violation{
+				Check:   CheckDepsConfig,
+				Message: fmt.Sprintf("bin/supply of %s did not write config.yml into <deps dir>/%s, its third and fourth arguments", name, conf.DepsIndex(i)),
+			})
+
+			continue
+		}
+
+		var config depsConfig
+		if err = yaml.Unmarshal(contents, &config); err != nil {
+			violations = append(violations, Violation{Check: CheckDepsConfig, Message: fmt.Sprintf("config.yml of %s is not valid YAML: %s", name, err.Error())})
+
+			continue
+		}
+
+		if config.Name == "" || config.Version == "" {
+			violations = append(violations, Violation{Check: CheckDepsConfig, Message: fmt.Sprintf("config.yml of %s must set name and version", name)})
+		}
+	}
+
+	return violations
+}
+
+// checkBuildDir checks that the app made it into the droplet, which it does
+// not when a script confuses its build directory argument.
+func checkBuildDir(app, dropletDir string) []Violation {
+	appFiles, err := ioutil.ReadDir(app)
+	if err != nil || len(appFiles) == 0 {
+		return nil
+	}
+
+	dropletFiles, err := ioutil.ReadDir(filepath.Join(dropletDir, "app"))
+	if err == nil && len(dropletFiles) > 0 {
+		return nil
+	}
+
+	return []Violation{{Check: CheckBuildDir, Message: "the droplet contains no app files, the build directory is the first argument of every script"}}
+}
@@ -23,6 +23,31 @@ const (
 	EnvBuildpackCacheDownloadURI       = "BUILDPACK_CACHE_DOWNLOAD_URI"
 	EnvBuildpackCacheChecksum          = "BUILDPACK_CACHE_CHECKSUM"
 	EnvBuildpackCacheChecksumAlgorithm = "BUILDPACK_CACHE_CHECKSUM_ALGORITHM"
+	EnvDropletCompression              = "EIRINI_DROPLET_COMPRESSION"
+	EnvOutputImageLayout               = "EIRINI_OUTPUT_IMAGE_LAYOUT"
+	EnvImageDestination                = "EIRINI_IMAGE_DESTINATION"
+	EnvImageRegistryUsername           = "EIRINI_IMAGE_REGISTRY_USERNAME"
+	EnvImageRegistryPassword           = "EIRINI_IMAGE_REGISTRY_PASSWORD"
+	EnvOutputLayersDir                 = "EIRINI_OUTPUT_LAYERS_DIR"
+	EnvDropletLayersUploadURL          = "DROPLET_LAYERS_UPLOAD_URL"
+	EnvStagingEnvironment              = "EIRINI_STAGING_ENVIRONMENT"
+	EnvStagingEnvironmentFile          = "EIRINI_STAGING_ENVIRONMENT_FILE"
+	EnvBuildpackEnvAllowList           = "EIRINI_BUILDPACK_ENV_ALLOW_LIST"
+	EnvBuildpackMaxProcesses           = "EIRINI_BUILDPACK_MAX_PROCESSES"
+	EnvBuildpackMaxOpenFiles           = "EIRINI_BUILDPACK_MAX_OPEN_FILES"
+	EnvBuildpackMaxFileSize            = "EIRINI_BUILDPACK_MAX_FILE_SIZE"
+	EnvBuildpackMaxCPUTime             = "EIRINI_BUILDPACK_MAX_CPU_TIME"
+	EnvOutputSBOMLocation              = "EIRINI_OUTPUT_SBOM_LOCATION"
+	EnvSBOMUploadURL                   = "SBOM_UPLOAD_URL"
+	EnvCfStack                         = "CF_STACK"
+	EnvPreStagingHooksDir              = "EIRINI_PRE_STAGING_HOOKS_DIR"
+	EnvPostStagingHooksDir             = "EIRINI_POST_STAGING_HOOKS_DIR"
+	EnvImportDroplet                   = "EIRINI_IMPORT_DROPLET"
+	EnvRawLifecycle                    = "EIRINI_RAW_LIFECYCLE"
+	EnvStartCommand                    = "EIRINI_START_COMMAND"
+	EnvStagingServerAddress            = "EIRINI_STAGING_SERVER_ADDRESS"
+	EnvStagingServerWorkers            = "EIRINI_STAGING_SERVER_WORKERS"
+	EnvStagingServerQueueSize          = "EIRINI_STAGING_SERVER_QUEUE_SIZE"
 
 	RegisteredRoutes = "routes"
 
@@ -35,6 +60,7 @@ const (
 	RecipeOutputLocation         = "/out"
 	RecipeOutputDropletLocation  = "/out/droplet.tgz"
 	RecipeOutputMetadataLocation = "/out/result.json"
+	RecipeOutputSBOMLocation     = "/out/sbom.cdx.json"
 
 	CCUploaderInternalURL = "cc-uploader.service.cf.internal"
 
@@ -46,6 +72,13 @@ const (
 
 	EiriniClientCert = "eirini-client-crt"
 	EiriniClientKey  = "eirini-client-crt-key"
+
+	StagingServerCertName = "staging-server-crt"
+	StagingServerKeyName  = "staging-server-crt-key"
+
+	DefaultStagingServerAddress   = ":8443"
+	DefaultStagingServerWorkers   = "1"
+	DefaultStagingServerQueueSize = "16"
 )
 
 type Extractor interface {
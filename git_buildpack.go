@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 func GitClone(repo url.URL, destination string) error {
@@ -54,3 +55,18 @@ func performGitClone(gitPath string, args []string, branch string) error {
 
 	return cmd.Run()
 }
+
+// GitCommit returns the commit checked out in the repository at dir.
+func GitCommit(dir string) (string, error) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("could not find `git` in path: %w", err)
+	}
+
+	output, err := exec.Command(gitPath, "-C", dir, "rev-parse", "HEAD").Output() // #nosec G204
+	if err != nil {
+		return "", fmt.Errorf("failed to read the commit of %s: %w", dir, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
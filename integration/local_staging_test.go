@@ -0,0 +1,105 @@
+package recipe_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("Local staging", func() {
+	var (
+		buildpackFixtures = filepath.Join("..", "builder", "fixtures", "buildpacks", "unix")
+		appFixtures       = filepath.Join("..", "builder", "fixtures", "apps")
+
+		outputDir string
+		args      []string
+		session   *gexec.Session
+	)
+
+	BeforeEach(func() {
+		var err error
+		outputDir, err = ioutil.TempDir("", "local-staging")
+		Expect(err).NotTo(HaveOccurred())
+
+		args = []string{
+			"-app", filepath.Join(appFixtures, "bash-app"),
+			"-output", outputDir,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		session, err = gexec.Start(exec.Command(binaries.StagePath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 30).Should(gexec.Exit())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	Context("when staging with supply and final buildpacks", func() {
+		BeforeEach(func() {
+			args = append(args,
+				"-buildpack", filepath.Join(buildpackFixtures, "supplies-dependencies"),
+				"-buildpack", filepath.Join(buildpackFixtures, "has-finalize"),
+			)
+		})
+
+		It("writes the droplet and the staging result to the output dir", func() {
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(filepath.Join(outputDir, "droplet.tgz")).To(BeAnExistingFile())
+			Expect(filepath.Join(outputDir, "cache.tgz")).To(BeAnExistingFile())
+			Expect(filepath.Join(outputDir, "result.json")).To(BeAnExistingFile())
+		})
+
+		It("writes the response the uploader would send", func() {
+			contents, err := ioutil.ReadFile(filepath.Join(outputDir, "staging_response.json"))
+			Expect(err).NotTo(HaveOccurred())
+
+			var response models.TaskCallbackResponse
+			Expect(json.Unmarshal(contents, &response)).To(Succeed())
+			Expect(response.Failed).To(BeFalse())
+
+			var result builder.StagingResult
+			Expect(json.Unmarshal([]byte(response.Result), &result)).To(Succeed())
+			Expect(result.Buildpacks).To(Equal([]builder.BuildpackMetadata{
+				{Key: "supplies-dependencies", Name: "supplies-dependencies", Version: "1.2.3"},
+				{Key: "has-finalize", Name: "Finalize"},
+			}))
+		})
+
+		It("prints a summary", func() {
+			Expect(session.Out).To(gbytes.Say("Staged app in"))
+			Expect(session.Out).To(gbytes.Say("detected buildpack: Finalize"))
+			Expect(session.Out).To(gbytes.Say("process web: +the start command"))
+		})
+	})
+
+	Context("when a buildpack fails", func() {
+		BeforeEach(func() {
+			args = append(args, "-buildpack", filepath.Join(buildpackFixtures, "fails-to-compile"))
+		})
+
+		It("exits with the exit code of the failed phase", func() {
+			Expect(session.ExitCode()).To(Equal(builder.CompileFailCode))
+			Expect(session.Err).To(gbytes.Say("staging failed \\(BuildpackCompileFailed\\)"))
+		})
+	})
+
+	Context("when no buildpack is given", func() {
+		It("prints the usage", func() {
+			Expect(session.ExitCode()).To(Equal(2))
+			Expect(session.Err).To(gbytes.Say("usage: stage"))
+		})
+	})
+})
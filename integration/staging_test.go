@@ -240,8 +240,8 @@ var _ = Describe("Staging Test", func() {
 				Expect(downloaderSession.ExitCode()).To(BeZero())
 			})
 
-			It("installs the buildpack json", func() {
-				expectedFile := filepath.Join(buildpacksDir, "config.json")
+			It("writes the install manifest", func() {
+				expectedFile := filepath.Join(buildpacksDir, builder.InstallManifestFile)
 				Expect(expectedFile).To(BeARegularFile())
 			})
 
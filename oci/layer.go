@@ -0,0 +1,200 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Layer describes a directory tree that is added to an image as a single
+// gzipped tar layer.
+type Layer struct {
+	// SourceDir is the directory whose contents make up the layer.
+	SourceDir string
+	// Prefix is the absolute path inside the image that SourceDir maps to,
+	// e.g. "/home/vcap".
+	Prefix string
+	// Include optionally restricts the layer to these top-level entries of
+	// SourceDir. All entries are included when it is empty.
+	Include []string
+	// UID and GID own the files in the layer and the Prefix directory.
+	UID int
+	GID int
+	// CreatedBy is recorded in the image history.
+	CreatedBy string
+	// ModTime, when set, replaces the modification time of every entry so
+	// that identical trees always produce identical layers.
+	ModTime time.Time
+}
+
+// Write writes the layer as a gzipped tar to dest and returns the digest of
+// the uncompressed tar, which the image config lists as the layer's diff ID.
+func (l Layer) Write(dest io.Writer) (string, error) {
+	gzipWriter := gzip.NewWriter(dest)
+
+	diffID, err := l.WriteTar(gzipWriter)
+	if err != nil {
+		return "", err
+	}
+
+	if err = gzipWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to close layer gzip stream: %w", err)
+	}
+
+	return diffID, nil
+}
+
+// WriteTar writes the layer as an uncompressed tar to dest, for callers
+// compressing it themselves, and returns the digest of the tar.
+func (l Layer) WriteTar(dest io.Writer) (string, error) {
+	diffIDHash := sha256.New()
+	tarWriter := tar.NewWriter(io.MultiWriter(dest, diffIDHash))
+
+	if err := l.writePrefixDirs(tarWriter); err != nil {
+		return "", err
+	}
+
+	if err := l.writeTree(tarWriter); err != nil {
+		return "", err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to close layer tar: %w", err)
+	}
+
+	return fmt.Sprintf("sha256:%x", diffIDHash.Sum(nil)), nil
+}
+
+func (l Layer) writePrefixDirs(tarWriter *tar.Writer) error {
+	prefix := strings.Trim(path.Clean(l.Prefix), "/")
+	if prefix == "" || prefix == "." {
+		return nil
+	}
+
+	parts := strings.Split(prefix, "/")
+	for i := range parts {
+		header := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.Join(parts[:i+1], "/") + "/",
+			Mode:     0755,
+			ModTime:  l.ModTime,
+		}
+
+		if i == len(parts)-1 {
+			header.Uid = l.UID
+			header.Gid = l.GID
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write layer directory %s: %w", header.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (l Layer) writeTree(tarWriter *tar.Writer) error {
+	return filepath.Walk(l.SourceDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(l.SourceDir, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		if !l.includes(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		return l.writeEntry(tarWriter, filePath, relPath, info)
+	})
+}
+
+func (l Layer) includes(relPath string) bool {
+	if len(l.Include) == 0 {
+		return true
+	}
+
+	topLevel := strings.SplitN(filepath.ToSlash(relPath), "/", 2)[0]
+	for _, include := range l.Include {
+		if include == topLevel {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l Layer) writeEntry(tarWriter *tar.Writer, filePath, relPath string, info os.FileInfo) error {
+	var linkTarget string
+
+	switch mode := info.Mode(); {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", filePath, err)
+		}
+
+		linkTarget = target
+	default:
+		// sockets, devices and pipes have no place in an image layer
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
+	}
+
+	header.Name = path.Join(strings.Trim(l.Prefix, "/"), filepath.ToSlash(relPath))
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	header.Uid = l.UID
+	header.Gid = l.GID
+	header.Uname = ""
+	header.Gname = ""
+
+	if !l.ModTime.IsZero() {
+		header.ModTime = l.ModTime
+	}
+
+	if err = tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", filePath, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(tarWriter, file); err != nil {
+		return fmt.Errorf("failed to write %s to layer: %w", filePath, err)
+	}
+
+	return nil
+}
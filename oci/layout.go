@@ -0,0 +1,211 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	layoutFileName = "oci-layout"
+	indexFileName  = "index.json"
+	blobsDir       = "blobs"
+)
+
+// Layout is an OCI image layout directory on the local filesystem.
+type Layout struct {
+	Root string
+}
+
+func NewLayout(root string) (*Layout, error) {
+	if err := os.MkdirAll(filepath.Join(root, blobsDir, "sha256"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create image layout blobs dir: %w", err)
+	}
+
+	contents, err := json.Marshal(layoutFile{ImageLayoutVersion: LayoutVersion})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal oci-layout: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(root, layoutFileName), contents, 0644); err != nil { //nolint:gosec
+		return nil, fmt.Errorf("failed to write oci-layout: %w", err)
+	}
+
+	return &Layout{Root: root}, nil
+}
+
+// WriteImage writes the layers, the image config and the manifest to a new
+// layout at root and tags the manifest in the layout's index.json.
+func WriteImage(root, tag string, config Config, layers ...Layer) (Descriptor, error) {
+	layout, err := NewLayout(root)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	now := time.Now().UTC()
+	image := Image{
+		Created:      &now,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config:       config,
+		RootFS:       RootFS{Type: "layers"},
+	}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+	}
+
+	for _, layer := range layers {
+		var diffID string
+
+		descriptor, err := layout.WriteBlob(MediaTypeImageLayerGzip, func(w io.Writer) error {
+			var layerErr error
+			diffID, layerErr = layer.Write(w)
+
+			return layerErr
+		})
+		if err != nil {
+			return Descriptor{}, fmt.Errorf("failed to write layer for %s: %w", layer.SourceDir, err)
+		}
+
+		manifest.Layers = append(manifest.Layers, descriptor)
+		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, diffID)
+		image.History = append(image.History, History{Created: &now, CreatedBy: layer.CreatedBy})
+	}
+
+	manifest.Config, err = layout.WriteJSONBlob(MediaTypeImageConfig, image)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to write image config: %w", err)
+	}
+
+	manifestDescriptor, err := layout.WriteJSONBlob(MediaTypeImageManifest, manifest)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to write image manifest: %w", err)
+	}
+
+	if tag != "" {
+		manifestDescriptor.Annotations = map[string]string{AnnotationRefName: tag}
+	}
+
+	if err = layout.WriteIndex(manifestDescriptor); err != nil {
+		return Descriptor{}, err
+	}
+
+	return manifestDescriptor, nil
+}
+
+// WriteBlob stores whatever write produces as a content-addressed blob.
+func (l *Layout) WriteBlob(mediaType string, write func(io.Writer) error) (Descriptor, error) {
+	tmpFile, err := ioutil.TempFile(filepath.Join(l.Root, blobsDir), "blob")
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to create blob temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	digester := sha256.New()
+	counter := &countingWriter{}
+
+	if err = write(io.MultiWriter(tmpFile, digester, counter)); err != nil {
+		return Descriptor{}, err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return Descriptor{}, fmt.Errorf("failed to close blob: %w", err)
+	}
+
+	descriptor := Descriptor{
+		MediaType: mediaType,
+		Digest:    fmt.Sprintf("sha256:%x", digester.Sum(nil)),
+		Size:      counter.count,
+	}
+
+	if err = os.Rename(tmpFile.Name(), l.BlobPath(descriptor.Digest)); err != nil {
+		return Descriptor{}, fmt.Errorf("failed to move blob into place: %w", err)
+	}
+
+	return descriptor, nil
+}
+
+func (l *Layout) WriteJSONBlob(mediaType string, value interface{}) (Descriptor, error) {
+	contents, err := json.Marshal(value)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("failed to marshal %s: %w", mediaType, err)
+	}
+
+	return l.WriteBlob(mediaType, func(w io.Writer) error {
+		_, writeErr := w.Write(contents)
+
+		return writeErr
+	})
+}
+
+func (l *Layout) WriteIndex(manifests ...Descriptor) error {
+	contents, err := json.Marshal(Index{SchemaVersion: 2, Manifests: manifests})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index.json: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(l.Root, indexFileName), contents, 0644); err != nil { //nolint:gosec
+		return fmt.Errorf("failed to write index.json: %w", err)
+	}
+
+	return nil
+}
+
+func (l *Layout) Index() (Index, error) {
+	var index Index
+
+	contents, err := ioutil.ReadFile(filepath.Join(l.Root, indexFileName))
+	if err != nil {
+		return index, fmt.Errorf("failed to read index.json: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &index); err != nil {
+		return index, fmt.Errorf("failed to unmarshal index.json: %w", err)
+	}
+
+	return index, nil
+}
+
+func (l *Layout) ReadJSONBlob(digest string, value interface{}) error {
+	contents, err := ioutil.ReadFile(l.BlobPath(digest))
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+
+	if err = json.Unmarshal(contents, value); err != nil {
+		return fmt.Errorf("failed to unmarshal blob %s: %w", digest, err)
+	}
+
+	return nil
+}
+
+func (l *Layout) BlobPath(digest string) string {
+	algorithm := "sha256"
+	encoded := digest
+
+	if parts := strings.SplitN(digest, ":", 2); len(parts) == 2 {
+		algorithm, encoded = parts[0], parts[1]
+	}
+
+	return filepath.Join(l.Root, blobsDir, algorithm, encoded)
+}
+
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	w.count += int64(len(data))
+
+	return len(data), nil
+}
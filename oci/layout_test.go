@@ -0,0 +1,164 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/eirini-staging/oci"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Layout", func() {
+	var (
+		tmpDir     string
+		sourceDir  string
+		layoutDir  string
+		layer      oci.Layer
+		config     oci.Config
+		descriptor oci.Descriptor
+		writeErr   error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "oci-layout")
+		Expect(err).NotTo(HaveOccurred())
+
+		sourceDir = filepath.Join(tmpDir, "droplet")
+		layoutDir = filepath.Join(tmpDir, "layout")
+
+		Expect(os.MkdirAll(filepath.Join(sourceDir, "app"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sourceDir, "deps", "0"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(sourceDir, "app", "run.sh"), []byte("echo hi"), 0750)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(sourceDir, "deps", "0", "lib"), []byte("lib"), 0644)).To(Succeed())
+		Expect(os.Symlink("run.sh", filepath.Join(sourceDir, "app", "start"))).To(Succeed())
+
+		layer = oci.Layer{SourceDir: sourceDir, Prefix: "/home/vcap", UID: 2000, GID: 2000, CreatedBy: "test"}
+		config = oci.Config{Cmd: []string{"./run.sh"}}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		descriptor, writeErr = oci.WriteImage(layoutDir, "v1", config, layer)
+	})
+
+	readManifest := func() oci.Manifest {
+		var manifest oci.Manifest
+		Expect((&oci.Layout{Root: layoutDir}).ReadJSONBlob(descriptor.Digest, &manifest)).To(Succeed())
+
+		return manifest
+	}
+
+	readLayer := func(digest string) map[string]*tar.Header {
+		file, err := os.Open((&oci.Layout{Root: layoutDir}).BlobPath(digest))
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		gzipReader, err := gzip.NewReader(file)
+		Expect(err).NotTo(HaveOccurred())
+
+		headers := map[string]*tar.Header{}
+		tarReader := tar.NewReader(gzipReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			headers[header.Name] = header
+		}
+
+		return headers
+	}
+
+	It("succeeds", func() {
+		Expect(writeErr).NotTo(HaveOccurred())
+	})
+
+	It("writes the oci-layout file", func() {
+		contents, err := ioutil.ReadFile(filepath.Join(layoutDir, "oci-layout"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contents).To(MatchJSON(`{"imageLayoutVersion": "1.0.0"}`))
+	})
+
+	It("tags the manifest in index.json", func() {
+		index, err := (&oci.Layout{Root: layoutDir}).Index()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index.SchemaVersion).To(Equal(2))
+		Expect(index.Manifests).To(ConsistOf(descriptor))
+		Expect(descriptor.MediaType).To(Equal(oci.MediaTypeImageManifest))
+		Expect(descriptor.Annotations).To(HaveKeyWithValue(oci.AnnotationRefName, "v1"))
+	})
+
+	It("stores blobs under their sha256 digest", func() {
+		manifest := readManifest()
+		for _, blob := range append([]oci.Descriptor{manifest.Config}, manifest.Layers...) {
+			contents, err := ioutil.ReadFile((&oci.Layout{Root: layoutDir}).BlobPath(blob.Digest))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprintf("sha256:%x", sha256.Sum256(contents))).To(Equal(blob.Digest))
+			Expect(int64(len(contents))).To(Equal(blob.Size))
+		}
+	})
+
+	It("writes the image config with the layer diff ids", func() {
+		manifest := readManifest()
+		Expect(manifest.Config.MediaType).To(Equal(oci.MediaTypeImageConfig))
+
+		var image oci.Image
+		Expect((&oci.Layout{Root: layoutDir}).ReadJSONBlob(manifest.Config.Digest, &image)).To(Succeed())
+		Expect(image.OS).To(Equal("linux"))
+		Expect(image.Config.Cmd).To(Equal([]string{"./run.sh"}))
+		Expect(image.RootFS.Type).To(Equal("layers"))
+		Expect(image.RootFS.DiffIDs).To(HaveLen(1))
+		Expect(image.History).To(HaveLen(1))
+		Expect(image.History[0].CreatedBy).To(Equal("test"))
+	})
+
+	It("writes the source dir under the prefix, owned by the layer user", func() {
+		manifest := readManifest()
+		Expect(manifest.Layers).To(HaveLen(1))
+		Expect(manifest.Layers[0].MediaType).To(Equal(oci.MediaTypeImageLayerGzip))
+
+		headers := readLayer(manifest.Layers[0].Digest)
+		Expect(headers).To(HaveKey("home/"))
+		Expect(headers).To(HaveKey("home/vcap/"))
+		Expect(headers).To(HaveKey("home/vcap/deps/0/lib"))
+
+		runScript := headers["home/vcap/app/run.sh"]
+		Expect(runScript).NotTo(BeNil())
+		Expect(runScript.Mode & 0777).To(Equal(int64(0750)))
+		Expect(runScript.Uid).To(Equal(2000))
+		Expect(runScript.Gid).To(Equal(2000))
+		Expect(headers["home/vcap/"].Uid).To(Equal(2000))
+		Expect(headers["home/"].Uid).To(Equal(0))
+	})
+
+	It("preserves symlinks", func() {
+		headers := readLayer(readManifest().Layers[0].Digest)
+		Expect(headers["home/vcap/app/start"].Typeflag).To(Equal(byte(tar.TypeSymlink)))
+		Expect(headers["home/vcap/app/start"].Linkname).To(Equal("run.sh"))
+	})
+
+	When("the layer only includes some entries", func() {
+		BeforeEach(func() {
+			layer.Include = []string{"deps"}
+		})
+
+		It("leaves the other entries out", func() {
+			headers := readLayer(readManifest().Layers[0].Digest)
+			Expect(headers).To(HaveKey("home/vcap/deps/0/lib"))
+			Expect(headers).NotTo(HaveKey("home/vcap/app/"))
+			Expect(headers).NotTo(HaveKey("home/vcap/app/run.sh"))
+		})
+	})
+})
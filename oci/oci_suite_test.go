@@ -0,0 +1,13 @@
+package oci_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOCI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OCI Suite")
+}
@@ -0,0 +1,378 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultTag = "latest"
+
+// Reference points at a tagged repository in a registry, e.g.
+// "registry.example.com:5000/org/app:v1".
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+func ParseReference(ref string) (Reference, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Reference{}, fmt.Errorf("invalid image reference %q: expected registry/repository[:tag]", ref)
+	}
+
+	reference := Reference{Registry: parts[0], Repository: parts[1], Tag: defaultTag}
+
+	if idx := strings.LastIndex(reference.Repository, ":"); idx != -1 {
+		reference.Tag = reference.Repository[idx+1:]
+		reference.Repository = reference.Repository[:idx]
+	}
+
+	if reference.Repository == "" || reference.Tag == "" {
+		return Reference{}, fmt.Errorf("invalid image reference %q: expected registry/repository[:tag]", ref)
+	}
+
+	return reference, nil
+}
+
+func (r Reference) String() string {
+	return fmt.Sprintf("%s/%s:%s", r.Registry, r.Repository, r.Tag)
+}
+
+// Pusher uploads an image from a local layout to a registry using the OCI
+// distribution API. It authenticates with the credentials as the registry
+// challenges it to: with basic auth, or with a bearer token from the
+// registry's token service.
+type Pusher struct {
+	Client   *http.Client
+	Username string
+	Password string
+	// Scheme defaults to https.
+	Scheme string
+
+	authorization string
+}
+
+// Push uploads the manifest tagged in the layout's index.json (or its only
+// manifest) together with its config and layers, and returns the digest of
+// the manifest. Blobs the registry already has are not uploaded again.
+func (p *Pusher) Push(layoutDir string, ref Reference) (string, error) {
+	layout := &Layout{Root: layoutDir}
+
+	index, err := layout.Index()
+	if err != nil {
+		return "", err
+	}
+
+	manifestDescriptor, err := selectManifest(index, ref.Tag)
+	if err != nil {
+		return "", err
+	}
+
+	var manifest Manifest
+	if err = layout.ReadJSONBlob(manifestDescriptor.Digest, &manifest); err != nil {
+		return "", err
+	}
+
+	if err = p.authorize(ref); err != nil {
+		return "", fmt.Errorf("failed to authenticate with %s: %w", ref.Registry, err)
+	}
+
+	for _, blob := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if err = p.pushBlob(layout, ref, blob); err != nil {
+			return "", fmt.Errorf("failed to push blob %s: %w", blob.Digest, err)
+		}
+	}
+
+	manifestBytes, err := ioutil.ReadFile(layout.BlobPath(manifestDescriptor.Digest))
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	request, err := p.newRequest(http.MethodPut, p.url(ref, "manifests", ref.Tag), bytes.NewReader(manifestBytes))
+	if err != nil {
+		return "", err
+	}
+
+	request.Header.Set("Content-Type", manifestDescriptor.MediaType)
+	request.ContentLength = int64(len(manifestBytes))
+
+	if _, err = p.do(request, http.StatusCreated); err != nil {
+		return "", err
+	}
+
+	return manifestDescriptor.Digest, nil
+}
+
+// selectManifest picks the manifest tagged with tag in the index, or else
+// the index's only manifest.
+func selectManifest(index Index, tag string) (Descriptor, error) {
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[AnnotationRefName] == tag {
+			return descriptor, nil
+		}
+	}
+
+	switch len(index.Manifests) {
+	case 0:
+		return Descriptor{}, errors.New("image layout does not contain any manifests")
+	case 1:
+		return index.Manifests[0], nil
+	default:
+		return Descriptor{}, fmt.Errorf("image layout contains %d manifests, none tagged %q", len(index.Manifests), tag)
+	}
+}
+
+// authorize asks the registry how to authenticate and prepares the
+// authorization of the requests of the push accordingly.
+func (p *Pusher) authorize(ref Reference) error {
+	p.authorization = ""
+	if p.Username != "" {
+		p.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(p.Username+":"+p.Password))
+	}
+
+	request, err := p.newRequest(http.MethodGet, fmt.Sprintf("%s://%s/v2/", p.scheme(), ref.Registry), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if p.Username == "" {
+			return errors.New("registry requires credentials")
+		}
+
+		return nil
+	case "bearer":
+		token, err := p.fetchToken(params, ref)
+		if err != nil {
+			return err
+		}
+
+		p.authorization = "Bearer " + token
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+}
+
+// fetchToken gets a token for pushing to the repository from the token
+// service the registry challenged with, see
+// https://docs.docker.com/registry/spec/auth/token/.
+func (p *Pusher) fetchToken(params map[string]string, ref Reference) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+
+	query.Set("scope", fmt.Sprintf("repository:%s:pull,push", ref.Repository))
+	realm.RawQuery = query.Encode()
+
+	request, err := p.newRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.Client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: status code %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", errors.New("token service did not return a token")
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"` into
+// its scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+
+		eq := strings.Index(rest, "=")
+		if eq == -1 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end == -1 {
+				end = len(rest)
+			}
+
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+
+		params[key] = value
+	}
+
+	return parts[0], params
+}
+
+func (p *Pusher) pushBlob(layout *Layout, ref Reference, blob Descriptor) error {
+	request, err := p.newRequest(http.MethodHead, p.url(ref, "blobs", blob.Digest), nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = p.do(request, http.StatusOK); err == nil {
+		return nil
+	}
+
+	request, err = p.newRequest(http.MethodPost, p.url(ref, "blobs", "uploads")+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.do(request, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	uploadURL, err := p.uploadURL(request.URL, resp.Header.Get("Location"), blob.Digest)
+	if err != nil {
+		return err
+	}
+
+	blobFile, err := os.Open(filepath.Clean(layout.BlobPath(blob.Digest)))
+	if err != nil {
+		return fmt.Errorf("failed to open blob: %w", err)
+	}
+	defer blobFile.Close()
+
+	request, err = p.newRequest(http.MethodPut, uploadURL, ioutil.NopCloser(blobFile))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.ContentLength = blob.Size
+
+	_, err = p.do(request, http.StatusCreated)
+
+	return err
+}
+
+func (p *Pusher) uploadURL(base *url.URL, location, digest string) (string, error) {
+	if location == "" {
+		return "", errors.New("registry did not return an upload location")
+	}
+
+	locationURL, err := base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %w", location, err)
+	}
+
+	query := locationURL.Query()
+	query.Set("digest", digest)
+	locationURL.RawQuery = query.Encode()
+
+	return locationURL.String(), nil
+}
+
+func (p *Pusher) url(ref Reference, kind, name string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", p.scheme(), ref.Registry, ref.Repository, kind, name)
+}
+
+func (p *Pusher) scheme() string {
+	if p.Scheme == "" {
+		return "https"
+	}
+
+	return p.Scheme
+}
+
+func (p *Pusher) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	if p.authorization != "" {
+		request.Header.Set("Authorization", p.authorization)
+	}
+
+	return request, nil
+}
+
+func (p *Pusher) do(request *http.Request, expectedStatus int) (*http.Response, error) {
+	resp, err := p.Client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return nil, fmt.Errorf("%s %s failed: status code %d", request.Method, request.URL.Path, resp.StatusCode)
+	}
+
+	return resp, nil
+}
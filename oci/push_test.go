@@ -0,0 +1,281 @@
+package oci_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/eirini-staging/oci"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+// fakeRegistry is an in-memory stand-in for the parts of the OCI
+// distribution API used by Pusher.
+type fakeRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	// token, when set, is required as the bearer token of every request.
+	token string
+}
+
+func newFakeRegistry(server *ghttp.Server) *fakeRegistry {
+	registry := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+
+	// authorized challenges requests without the token to fetch one from
+	// the token service at /token.
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if registry.token != "" && r.Header.Get("Authorization") != "Bearer "+registry.token {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, server.URL()))
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			handler(w, r)
+		}
+	}
+
+	server.RouteToHandler("GET", "/v2/", authorized(func(w http.ResponseWriter, r *http.Request) {}))
+
+	blobPath := regexp.MustCompile(`^/v2/org/app/blobs/(sha256:[a-f0-9]+)$`)
+	server.RouteToHandler("HEAD", blobPath, authorized(func(w http.ResponseWriter, r *http.Request) {
+		registry.mutex.Lock()
+		defer registry.mutex.Unlock()
+
+		if _, ok := registry.blobs[blobPath.FindStringSubmatch(r.URL.Path)[1]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	server.RouteToHandler("POST", "/v2/org/app/blobs/uploads/", authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/upload/session?state=abc")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	server.RouteToHandler("PUT", "/upload/session", authorized(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.URL.Query().Get("state")).To(Equal("abc"))
+
+		digest := r.URL.Query().Get("digest")
+		if digest != fmt.Sprintf("sha256:%x", sha256.Sum256(body)) {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		registry.mutex.Lock()
+		defer registry.mutex.Unlock()
+		registry.blobs[digest] = body
+		registry.uploads++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	server.RouteToHandler("PUT", regexp.MustCompile(`^/v2/org/app/manifests/`), authorized(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Header.Get("Content-Type")).To(Equal(oci.MediaTypeImageManifest))
+
+		registry.mutex.Lock()
+		defer registry.mutex.Unlock()
+		registry.manifests[strings.TrimPrefix(r.URL.Path, "/v2/org/app/manifests/")] = body
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	return registry
+}
+
+var _ = Describe("Push", func() {
+	var (
+		tmpDir    string
+		layoutDir string
+		server    *ghttp.Server
+		registry  *fakeRegistry
+		pusher    *oci.Pusher
+		ref       oci.Reference
+		digest    string
+		pushErr   error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "oci-push")
+		Expect(err).NotTo(HaveOccurred())
+
+		sourceDir := filepath.Join(tmpDir, "droplet")
+		layoutDir = filepath.Join(tmpDir, "layout")
+		Expect(os.MkdirAll(filepath.Join(sourceDir, "app"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(sourceDir, "app", "run.sh"), []byte("echo hi"), 0755)).To(Succeed())
+
+		_, err = oci.WriteImage(layoutDir, "latest", oci.Config{}, oci.Layer{SourceDir: sourceDir, Prefix: "/home/vcap"})
+		Expect(err).NotTo(HaveOccurred())
+
+		server = ghttp.NewTLSServer()
+		registry = newFakeRegistry(server)
+
+		serverURL, err := url.Parse(server.URL())
+		Expect(err).NotTo(HaveOccurred())
+		ref = oci.Reference{Registry: serverURL.Host, Repository: "org/app", Tag: "v2"}
+
+		pusher = &oci.Pusher{Client: server.HTTPTestServer.Client()}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		digest, pushErr = pusher.Push(layoutDir, ref)
+	})
+
+	It("uploads the config and the layer", func() {
+		Expect(pushErr).NotTo(HaveOccurred())
+		Expect(registry.blobs).To(HaveLen(2))
+		Expect(registry.uploads).To(Equal(2))
+	})
+
+	It("tags the manifest", func() {
+		Expect(pushErr).NotTo(HaveOccurred())
+		Expect(registry.manifests).To(HaveKey("v2"))
+		Expect(digest).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(registry.manifests["v2"]))))
+
+		var manifest oci.Manifest
+		Expect((&oci.Layout{Root: layoutDir}).ReadJSONBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(registry.manifests["v2"])), &manifest)).To(Succeed())
+		Expect(registry.blobs).To(HaveKey(manifest.Config.Digest))
+		Expect(registry.blobs).To(HaveKey(manifest.Layers[0].Digest))
+	})
+
+	When("the registry already has the blobs", func() {
+		BeforeEach(func() {
+			_, err := pusher.Push(layoutDir, ref)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("does not upload them again", func() {
+			Expect(pushErr).NotTo(HaveOccurred())
+			Expect(registry.uploads).To(Equal(2))
+		})
+	})
+
+	When("credentials are configured", func() {
+		BeforeEach(func() {
+			pusher.Username = "user"
+			pusher.Password = "pass"
+		})
+
+		It("sends them with every request", func() {
+			Expect(pushErr).NotTo(HaveOccurred())
+			for _, request := range server.ReceivedRequests() {
+				username, password, ok := request.BasicAuth()
+				Expect(ok).To(BeTrue())
+				Expect(username).To(Equal("user"))
+				Expect(password).To(Equal("pass"))
+			}
+		})
+	})
+
+	When("the registry requires a bearer token", func() {
+		var tokenRequests []*http.Request
+
+		BeforeEach(func() {
+			registry.token = "the-token"
+			pusher.Username = "user"
+			pusher.Password = "pass"
+
+			tokenRequests = nil
+			server.RouteToHandler("GET", "/token", func(w http.ResponseWriter, r *http.Request) {
+				tokenRequests = append(tokenRequests, r)
+				w.Header().Set("Content-Type", "application/json")
+				_, err := w.Write([]byte(`{"token":"the-token"}`))
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		It("pushes with a token for the repository", func() {
+			Expect(pushErr).NotTo(HaveOccurred())
+			Expect(registry.manifests).To(HaveKey("v2"))
+
+			Expect(tokenRequests).To(HaveLen(1))
+			Expect(tokenRequests[0].URL.Query().Get("service")).To(Equal("fake-registry"))
+			Expect(tokenRequests[0].URL.Query().Get("scope")).To(Equal("repository:org/app:pull,push"))
+
+			username, password, ok := tokenRequests[0].BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(username).To(Equal("user"))
+			Expect(password).To(Equal("pass"))
+		})
+
+		When("the token service rejects the credentials", func() {
+			BeforeEach(func() {
+				server.RouteToHandler("GET", "/token", ghttp.RespondWith(http.StatusUnauthorized, nil))
+			})
+
+			It("returns an error", func() {
+				Expect(pushErr).To(MatchError(ContainSubstring("token request failed: status code 401")))
+			})
+		})
+	})
+
+	When("the layout has no manifests", func() {
+		BeforeEach(func() {
+			Expect((&oci.Layout{Root: layoutDir}).WriteIndex()).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(pushErr).To(MatchError("image layout does not contain any manifests"))
+		})
+	})
+
+	When("the registry rejects the manifest", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("PUT", regexp.MustCompile(`^/v2/org/app/manifests/`), ghttp.RespondWith(http.StatusUnauthorized, nil))
+		})
+
+		It("returns an error", func() {
+			Expect(pushErr).To(MatchError(ContainSubstring("status code 401")))
+		})
+	})
+
+	When("the layout does not exist", func() {
+		BeforeEach(func() {
+			layoutDir = filepath.Join(tmpDir, "nope")
+		})
+
+		It("returns an error", func() {
+			Expect(pushErr).To(MatchError(ContainSubstring("failed to read index.json")))
+		})
+	})
+})
+
+var _ = Describe("ParseReference", func() {
+	It("parses a reference with a tag", func() {
+		ref, err := oci.ParseReference("registry.example.com:5000/org/app:v1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref).To(Equal(oci.Reference{Registry: "registry.example.com:5000", Repository: "org/app", Tag: "v1"}))
+	})
+
+	It("defaults the tag to latest", func() {
+		ref, err := oci.ParseReference("registry.example.com/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.Tag).To(Equal("latest"))
+		Expect(ref.String()).To(Equal("registry.example.com/app:latest"))
+	})
+
+	It("rejects a reference without a registry", func() {
+		_, err := oci.ParseReference("app")
+		Expect(err).To(MatchError(ContainSubstring("invalid image reference")))
+	})
+})
@@ -0,0 +1,66 @@
+package oci
+
+import "time"
+
+const (
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+
+	LayoutVersion = "1.0.0"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Config is the subset of the OCI image configuration that staging fills in.
+type Config struct {
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created   *time.Time `json:"created,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+}
+
+type Image struct {
+	Created      *time.Time `json:"created,omitempty"`
+	Architecture string     `json:"architecture"`
+	OS           string     `json:"os"`
+	Config       Config     `json:"config"`
+	RootFS       RootFS     `json:"rootfs"`
+	History      []History  `json:"history,omitempty"`
+}
+
+type layoutFile struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
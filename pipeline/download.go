@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/checksum"
+)
+
+// ChecksumSHA256 is the only checksum algorithm supported for the build
+// artifacts cache.
+const ChecksumSHA256 = "sha256"
+
+// Download fetches what a staging needs: the buildpacks, the app bits and
+// the build artifacts cache of the previous staging.
+type Download struct {
+	// Client talks to Cloud Controller and the bits service, DefaultClient
+	// downloads buildpacks not served by them.
+	Client         *http.Client
+	DefaultClient  *http.Client
+	BuildpacksJSON string
+	BuildpacksDir  string
+	// AppBitsURI is downloaded into WorkspaceDir as app.zip. It is skipped
+	// when empty.
+	AppBitsURI   string
+	WorkspaceDir string
+	// CacheURI is the build artifacts cache, which is verified against
+	// CacheChecksum and extracted into CacheDir. It is skipped when empty.
+	CacheURI               string
+	CacheDir               string
+	CacheChecksum          string
+	CacheChecksumAlgorithm string
+}
+
+func (d Download) Run() error {
+	installers := []eirinistaging.Installer{
+		eirinistaging.NewBuildpackManager(d.Client, d.DefaultClient, d.BuildpacksDir, d.BuildpacksJSON),
+	}
+
+	if d.AppBitsURI != "" {
+		installers = append(installers, eirinistaging.NewPackageManager(d.Client, d.AppBitsURI, d.WorkspaceDir, nil))
+	}
+
+	if d.CacheURI != "" {
+		if d.CacheChecksumAlgorithm != ChecksumSHA256 {
+			return fmt.Errorf("unsupported checksum verification algorithm: %q", d.CacheChecksumAlgorithm)
+		}
+
+		if err := os.MkdirAll(d.CacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create buildpack cache dir at %s: %w", d.CacheDir, err)
+		}
+
+		installers = append(installers, eirinistaging.NewPackageManager(d.Client, d.CacheURI, d.CacheDir, verifyingReader(d.CacheChecksum)))
+	}
+
+	log.Println("Installing dependencies")
+
+	for _, installer := range installers {
+		if err := installer.Install(); err != nil {
+			return err
+		}
+	}
+
+	if d.CacheURI == "" {
+		return nil
+	}
+
+	return RestoreCache(filepath.Join(d.CacheDir, eirinistaging.AppBits), d.CacheDir)
+}
+
+// RestoreCache extracts a build artifacts cache, which is compressed with
+// whatever codec the staging that wrote it used, and removes the archive.
+func RestoreCache(archive, cacheDir string) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create buildpack cache dir at %s: %w", cacheDir, err)
+	}
+
+	if err := builder.ExtractArchive(archive, cacheDir); err != nil {
+		return fmt.Errorf("error untarring cache: %w", err)
+	}
+
+	if filepath.Dir(archive) != filepath.Clean(cacheDir) {
+		return nil
+	}
+
+	return os.Remove(archive)
+}
+
+func verifyingReader(chksum string) func(io.Reader) io.Reader {
+	return func(reader io.Reader) io.Reader {
+		return checksum.NewVerifyingReader(reader, sha256.New(), chksum)
+	}
+}
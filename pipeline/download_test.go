@@ -0,0 +1,149 @@
+package pipeline_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	archive_helpers "code.cloudfoundry.org/archiver/extractor/test_helper"
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Download", func() {
+	var (
+		tmpDir     string
+		server     *ghttp.Server
+		cacheBytes []byte
+		download   pipeline.Download
+		err        error
+	)
+
+	readFile := func(path string) []byte {
+		contents, readErr := ioutil.ReadFile(path)
+		Expect(readErr).NotTo(HaveOccurred())
+
+		return contents
+	}
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "download")
+		Expect(err).NotTo(HaveOccurred())
+
+		buildpackZip := filepath.Join(tmpDir, "buildpack.zip")
+		archive_helpers.CreateZipArchive(buildpackZip, []archive_helpers.ArchiveFile{
+			{Name: "bin/detect", Body: "#!/bin/bash\n", Mode: 0755},
+			{Name: "bin/compile", Body: "#!/bin/bash\n", Mode: 0755},
+			{Name: "bin/release", Body: "#!/bin/bash\n", Mode: 0755},
+		})
+
+		appZip := filepath.Join(tmpDir, "app.zip")
+		archive_helpers.CreateZipArchive(appZip, []archive_helpers.ArchiveFile{{Name: "app.sh", Body: "echo hi"}})
+
+		cacheSrc := filepath.Join(tmpDir, "cache-src", "final")
+		Expect(os.MkdirAll(cacheSrc, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(cacheSrc, "compiled"), []byte("cached"), 0644)).To(Succeed())
+		Expect(exec.Command("tar", "-czf", filepath.Join(tmpDir, "cache.tgz"), "-C", filepath.Dir(cacheSrc), ".").Run()).To(Succeed())
+		cacheBytes = readFile(filepath.Join(tmpDir, "cache.tgz"))
+
+		server = ghttp.NewServer()
+		server.RouteToHandler("GET", "/buildpack.zip", ghttp.RespondWith(http.StatusOK, readFile(buildpackZip)))
+		server.RouteToHandler("GET", "/app", ghttp.RespondWith(http.StatusOK, readFile(appZip)))
+		server.RouteToHandler("GET", "/cache", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(cacheBytes)
+		})
+
+		buildpacksJSON, marshalErr := json.Marshal([]builder.Buildpack{
+			{Name: "my_buildpack", Key: "my-key", URL: server.URL() + "/buildpack.zip"},
+		})
+		Expect(marshalErr).NotTo(HaveOccurred())
+
+		checksum := sha256.Sum256(cacheBytes)
+
+		download = pipeline.Download{
+			Client:                 http.DefaultClient,
+			DefaultClient:          http.DefaultClient,
+			BuildpacksJSON:         string(buildpacksJSON),
+			BuildpacksDir:          filepath.Join(tmpDir, "buildpacks"),
+			AppBitsURI:             server.URL() + "/app",
+			WorkspaceDir:           filepath.Join(tmpDir, "workspace"),
+			CacheURI:               server.URL() + "/cache",
+			CacheDir:               filepath.Join(tmpDir, "cache"),
+			CacheChecksum:          hex.EncodeToString(checksum[:]),
+			CacheChecksumAlgorithm: pipeline.ChecksumSHA256,
+		}
+
+		Expect(os.MkdirAll(download.BuildpacksDir, 0755)).To(Succeed())
+		Expect(os.MkdirAll(download.WorkspaceDir, 0755)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		err = download.Run()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("installs the buildpacks", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		manifest, readErr := builder.ReadInstallManifest(filepath.Join(download.BuildpacksDir, builder.InstallManifestFile))
+		Expect(readErr).NotTo(HaveOccurred())
+		Expect(manifest.Buildpacks).To(HaveLen(1))
+		Expect(filepath.Join(manifest.Buildpacks[0].Path, "bin", "compile")).To(BeARegularFile())
+	})
+
+	It("downloads the app bits into the workspace", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(download.WorkspaceDir, eirinistaging.AppBits)).To(BeARegularFile())
+	})
+
+	It("restores the build artifacts cache", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readFile(filepath.Join(download.CacheDir, "final", "compiled"))).To(Equal([]byte("cached")))
+		Expect(filepath.Join(download.CacheDir, eirinistaging.AppBits)).NotTo(BeAnExistingFile())
+	})
+
+	Context("when the cache does not match its checksum", func() {
+		BeforeEach(func() {
+			download.CacheChecksum = "bogus"
+		})
+
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when the checksum algorithm is not supported", func() {
+		BeforeEach(func() {
+			download.CacheChecksumAlgorithm = "md5"
+		})
+
+		It("fails before downloading anything", func() {
+			Expect(err).To(MatchError(`unsupported checksum verification algorithm: "md5"`))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("without a cache", func() {
+		BeforeEach(func() {
+			download.CacheURI = ""
+		})
+
+		It("only installs the buildpacks and the app bits", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+})
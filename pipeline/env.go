@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+)
+
+// ConfigFromEnv reads the builder configuration that is not about where
+// staging reads and writes its files from the environment of a staging pod
+// or of the staging server.
+func ConfigFromEnv() (builder.Config, error) {
+	compression, err := builder.ParseCompression(os.Getenv(eirinistaging.EnvDropletCompression))
+	if err != nil {
+		return builder.Config{}, err
+	}
+
+	stagingEnv, err := stagingEnvironment()
+	if err != nil {
+		return builder.Config{}, err
+	}
+
+	limits, err := resourceLimits()
+	if err != nil {
+		return builder.Config{}, err
+	}
+
+	return builder.Config{
+		Compression:         compression,
+		OutputImageLayout:   os.Getenv(eirinistaging.EnvOutputImageLayout),
+		OutputLayersDir:     os.Getenv(eirinistaging.EnvOutputLayersDir),
+		StagingEnvironment:  stagingEnv,
+		EnvAllowList:        envAllowList(),
+		ResourceLimits:      limits,
+		Stack:               os.Getenv(eirinistaging.EnvCfStack),
+		PreStagingHooksDir:  os.Getenv(eirinistaging.EnvPreStagingHooksDir),
+		PostStagingHooksDir: os.Getenv(eirinistaging.EnvPostStagingHooksDir),
+		Raw:                 os.Getenv(eirinistaging.EnvRawLifecycle) == "true",
+		StartCommand:        os.Getenv(eirinistaging.EnvStartCommand),
+	}, nil
+}
+
+// stagingEnvironment reads the staging environment from the environment
+// variable holding its JSON, or else from the file it points at.
+func stagingEnvironment() (builder.StagingEnvironment, error) {
+	if stagingEnvJSON := os.Getenv(eirinistaging.EnvStagingEnvironment); stagingEnvJSON != "" {
+		return builder.ParseStagingEnvironment([]byte(stagingEnvJSON))
+	}
+
+	if stagingEnvFile := os.Getenv(eirinistaging.EnvStagingEnvironmentFile); stagingEnvFile != "" {
+		return builder.ReadStagingEnvironment(stagingEnvFile)
+	}
+
+	return builder.StagingEnvironment{}, nil
+}
+
+// envAllowList extends the default allow-list with the comma separated names
+// in EIRINI_BUILDPACK_ENV_ALLOW_LIST.
+func envAllowList() []string {
+	allowList := append([]string{}, builder.DefaultEnvAllowList...)
+
+	for _, name := range strings.Split(os.Getenv(eirinistaging.EnvBuildpackEnvAllowList), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowList = append(allowList, name)
+		}
+	}
+
+	return allowList
+}
+
+func resourceLimits() (builder.ResourceLimits, error) {
+	limits := builder.ResourceLimits{}
+
+	for env, limit := range map[string]*uint64{
+		eirinistaging.EnvBuildpackMaxProcesses: &limits.Processes,
+		eirinistaging.EnvBuildpackMaxOpenFiles: &limits.OpenFiles,
+		eirinistaging.EnvBuildpackMaxFileSize:  &limits.FileSize,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return builder.ResourceLimits{}, fmt.Errorf("invalid value for %s: %w", env, err)
+		}
+
+		*limit = parsed
+	}
+
+	cpuTime, err := durationFromEnv(eirinistaging.EnvBuildpackMaxCPUTime)
+	if err != nil {
+		return builder.ResourceLimits{}, err
+	}
+
+	limits.CPUTime = cpuTime
+
+	return limits, nil
+}
+
+func durationFromEnv(env string) (time.Duration, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %w", env, err)
+	}
+
+	return duration, nil
+}
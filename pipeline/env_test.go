@@ -0,0 +1,72 @@
+package pipeline_test
+
+import (
+	"os"
+	"time"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigFromEnv", func() {
+	var env map[string]string
+
+	setEnv := func(name, value string) {
+		env[name] = value
+		Expect(os.Setenv(name, value)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		env = map[string]string{}
+	})
+
+	AfterEach(func() {
+		for name := range env {
+			Expect(os.Unsetenv(name)).To(Succeed())
+		}
+	})
+
+	It("reads the staging settings", func() {
+		setEnv(eirinistaging.EnvDropletCompression, "zstd")
+		setEnv(eirinistaging.EnvBuildpackMaxProcesses, "512")
+		setEnv(eirinistaging.EnvBuildpackMaxCPUTime, "90s")
+		setEnv(eirinistaging.EnvBuildpackEnvAllowList, "MY_PROXY_CA, OTHER_SETTING")
+		setEnv(eirinistaging.EnvPreStagingHooksDir, "/hooks/pre")
+		setEnv(eirinistaging.EnvRawLifecycle, "true")
+		setEnv(eirinistaging.EnvStagingEnvironment, `{"memory_limit":256}`)
+
+		conf, err := pipeline.ConfigFromEnv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Compression.Codec).To(Equal(builder.FormatZstd))
+		Expect(conf.ResourceLimits).To(Equal(builder.ResourceLimits{Processes: 512, CPUTime: 90 * time.Second}))
+		Expect(conf.EnvAllowList).To(ContainElement("PATH"))
+		Expect(conf.EnvAllowList).To(ContainElement("MY_PROXY_CA"))
+		Expect(conf.EnvAllowList).To(ContainElement("OTHER_SETTING"))
+		Expect(conf.PreStagingHooksDir).To(Equal("/hooks/pre"))
+		Expect(conf.Raw).To(BeTrue())
+		Expect(conf.StagingEnvironment.MemoryLimit).To(Equal(256))
+	})
+
+	It("leaves the resource limits unset by default", func() {
+		conf, err := pipeline.ConfigFromEnv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.ResourceLimits.IsZero()).To(BeTrue())
+	})
+
+	It("fails on an invalid CPU time limit", func() {
+		setEnv(eirinistaging.EnvBuildpackMaxCPUTime, "soon")
+
+		_, err := pipeline.ConfigFromEnv()
+		Expect(err).To(MatchError(ContainSubstring("invalid value for EIRINI_BUILDPACK_MAX_CPU_TIME")))
+	})
+
+	It("fails on an invalid resource limit", func() {
+		setEnv(eirinistaging.EnvBuildpackMaxOpenFiles, "lots")
+
+		_, err := pipeline.ConfigFromEnv()
+		Expect(err).To(MatchError(ContainSubstring("invalid value for EIRINI_BUILDPACK_MAX_OPEN_FILES")))
+	})
+})
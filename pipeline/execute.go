@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+)
+
+// Execution stages downloaded app bits into a droplet with the buildpacks
+// the download installed.
+type Execution struct {
+	// Config configures the builder. Its buildpacks are read from the
+	// install manifest in BuildpacksDir. BuildDir defaults to a temporary
+	// directory, which is removed after staging.
+	Config builder.Config
+	// AppBits is the app zip or app directory to stage, or the droplet to
+	// import when ImportDroplet is set.
+	AppBits       string
+	ImportDroplet bool
+}
+
+func (e Execution) Run() error {
+	conf := e.Config
+
+	if e.ImportDroplet {
+		// imported droplets were not staged with any buildpacks
+		if err := writeInstallManifest(conf, builder.InstallManifest{}); err != nil {
+			return err
+		}
+
+		runner := builder.NewRunner(&conf)
+		defer runner.CleanUp()
+
+		return runner.ImportDroplet(e.AppBits)
+	}
+
+	if err := initBuildpacks(&conf); err != nil {
+		return err
+	}
+
+	extractStart := time.Now()
+
+	if conf.BuildDir == "" {
+		tmpDir, err := ioutil.TempDir("", "app-bits")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		conf.BuildDir = filepath.Join(tmpDir, "app")
+	}
+
+	if err := extractApp(e.AppBits, conf.BuildDir); err != nil {
+		return err
+	}
+
+	runner := builder.NewRunner(&conf)
+	defer runner.CleanUp()
+
+	runner.RecordPhase(builder.PhaseExtract, time.Since(extractStart))
+
+	return runner.Run()
+}
+
+// initBuildpacks configures the buildpacks from the install manifest the
+// download wrote and hands the manifest on to the upload next to the
+// staging result. Raw stagings may run without any buildpacks installed.
+func initBuildpacks(conf *builder.Config) error {
+	manifest, err := builder.ReadInstallManifest(filepath.Join(conf.BuildpacksDir, builder.InstallManifestFile))
+	if err != nil && !(conf.Raw && errors.Is(err, os.ErrNotExist)) {
+		return err
+	}
+
+	conf.InitFromInstallManifest(manifest)
+
+	return writeInstallManifest(*conf, manifest)
+}
+
+func writeInstallManifest(conf builder.Config, manifest builder.InstallManifest) error {
+	outputDir := filepath.Dir(conf.OutputMetadataLocation)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output metadata location directory: %w", err)
+	}
+
+	return manifest.Write(filepath.Join(outputDir, builder.InstallManifestFile))
+}
+
+// extractApp copies the app directory into the build dir, which must not
+// exist yet, or extracts the app zip into it. Like the cf CLI, it leaves out
+// ignored files before any buildpack runs.
+func extractApp(appBits, buildDir string) error {
+	info, err := os.Stat(appBits)
+	if err != nil {
+		return fmt.Errorf("failed to stat app bits: %w", err)
+	}
+
+	if info.IsDir() {
+		if _, err = builder.CopyDir(appBits, buildDir, nil); err != nil {
+			return fmt.Errorf("failed to copy app: %w", err)
+		}
+	} else {
+		var tenGB int64 = 10 * 1024 * 1024 * 1024
+		extractor := &eirinistaging.Unzipper{UnzippedSizeLimit: tenGB}
+
+		if err = os.MkdirAll(buildDir, 0755); err != nil {
+			return fmt.Errorf("failed to create build dir: %w", err)
+		}
+
+		if err = extractor.Extract(appBits, buildDir); err != nil {
+			return fmt.Errorf("extraction failed: %w", err)
+		}
+	}
+
+	if _, err = builder.RemoveCFIgnored(buildDir); err != nil {
+		return fmt.Errorf("failed to apply .cfignore: %w", err)
+	}
+
+	return nil
+}
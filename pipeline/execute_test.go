@@ -0,0 +1,164 @@
+package pipeline_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	archive_helpers "code.cloudfoundry.org/archiver/extractor/test_helper"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Execution", func() {
+	var (
+		tmpDir        string
+		buildpacksDir string
+		outputDir     string
+		appDir        string
+		execution     pipeline.Execution
+		err           error
+	)
+
+	installBuildpack := func(name string) {
+		path := filepath.Join(buildpacksDir, name)
+		_, copyErr := builder.CopyDir(filepath.Join("..", "builder", "fixtures", "buildpacks", "unix", name), path, nil)
+		Expect(copyErr).NotTo(HaveOccurred())
+
+		manifest := builder.InstallManifest{Buildpacks: []builder.InstalledBuildpack{
+			{Buildpack: builder.Buildpack{Name: name, Key: name, SkipDetect: true}, Path: path},
+		}}
+		Expect(manifest.Write(filepath.Join(buildpacksDir, builder.InstallManifestFile))).To(Succeed())
+	}
+
+	dropletFiles := func() []string {
+		files, tarErr := exec.Command("tar", "-tzf", execution.Config.OutputDropletLocation).Output()
+		Expect(tarErr).NotTo(HaveOccurred())
+
+		return strings.Split(string(files), "\n")
+	}
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "execution")
+		Expect(err).NotTo(HaveOccurred())
+
+		buildpacksDir = filepath.Join(tmpDir, "buildpacks")
+		outputDir = filepath.Join(tmpDir, "out")
+		appDir = filepath.Join(tmpDir, "app")
+		Expect(os.MkdirAll(buildpacksDir, 0755)).To(Succeed())
+		Expect(os.MkdirAll(appDir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "app.sh"), []byte("echo hi"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "secret.txt"), []byte("hunter2"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, ".cfignore"), []byte("secret.txt\n"), 0644)).To(Succeed())
+
+		installBuildpack("always-detects")
+
+		execution = pipeline.Execution{
+			Config: builder.Config{
+				BuildpacksDir:             buildpacksDir,
+				OutputDropletLocation:     filepath.Join(outputDir, "droplet.tgz"),
+				OutputBuildArtifactsCache: filepath.Join(outputDir, "cache.tgz"),
+				OutputMetadataLocation:    filepath.Join(outputDir, "result.json"),
+				BuildArtifactsCache:       filepath.Join(tmpDir, "cache"),
+			},
+			AppBits: appDir,
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = execution.Run()
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("stages the app directory without the files the .cfignore matches", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dropletFiles()).To(ContainElement("./app/app.sh"))
+		Expect(dropletFiles()).NotTo(ContainElement("./app/secret.txt"))
+	})
+
+	It("leaves the app directory untouched", func() {
+		Expect(filepath.Join(appDir, "secret.txt")).To(BeARegularFile())
+	})
+
+	It("hands the install manifest on next to the staging result", func() {
+		manifest, readErr := builder.ReadInstallManifest(filepath.Join(outputDir, builder.InstallManifestFile))
+		Expect(readErr).NotTo(HaveOccurred())
+		Expect(manifest.Buildpacks).To(HaveLen(1))
+		Expect(manifest.Buildpacks[0].Name).To(Equal("always-detects"))
+	})
+
+	Context("when the app bits are a zip", func() {
+		BeforeEach(func() {
+			execution.AppBits = filepath.Join(tmpDir, "app.zip")
+			archive_helpers.CreateZipArchive(execution.AppBits, []archive_helpers.ArchiveFile{
+				{Name: "app.sh", Body: "echo hi", Mode: 0755},
+				{Name: "secret.txt", Body: "hunter2"},
+				{Name: ".cfignore", Body: "secret.txt\n"},
+			})
+		})
+
+		It("stages the extracted app", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dropletFiles()).To(ContainElement("./app/app.sh"))
+			Expect(dropletFiles()).NotTo(ContainElement("./app/secret.txt"))
+		})
+	})
+
+	Context("when there is no install manifest", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(buildpacksDir, builder.InstallManifestFile))).To(Succeed())
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to read install manifest")))
+		})
+
+		Context("and the staging is raw", func() {
+			BeforeEach(func() {
+				execution.Config.Raw = true
+				execution.Config.StartCommand = "./app.sh"
+			})
+
+			It("stages the app without buildpacks", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dropletFiles()).To(ContainElement("./app/app.sh"))
+			})
+
+			It("hands an empty install manifest on", func() {
+				manifest, readErr := builder.ReadInstallManifest(filepath.Join(outputDir, builder.InstallManifestFile))
+				Expect(readErr).NotTo(HaveOccurred())
+				Expect(manifest.Buildpacks).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when importing a droplet", func() {
+		BeforeEach(func() {
+			staged := execution
+			staged.Config.OutputDropletLocation = filepath.Join(tmpDir, "staged", "droplet.tgz")
+			staged.Config.OutputMetadataLocation = filepath.Join(tmpDir, "staged", "result.json")
+			Expect(staged.Run()).To(Succeed())
+
+			execution.AppBits = staged.Config.OutputDropletLocation
+			execution.ImportDroplet = true
+		})
+
+		It("stages the droplet as-is", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dropletFiles()).To(ContainElement("./app/app.sh"))
+		})
+
+		It("hands an empty install manifest on", func() {
+			manifest, readErr := builder.ReadInstallManifest(filepath.Join(outputDir, builder.InstallManifestFile))
+			Expect(readErr).NotTo(HaveOccurred())
+			Expect(manifest.Buildpacks).To(BeEmpty())
+		})
+	})
+})
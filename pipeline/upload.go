@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"code.cloudfoundry.org/bbs/models"
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/oci"
+)
+
+// Upload hands the output of a staging to Cloud Controller: the droplet and
+// optionally the build artifacts cache, the SBOM, the droplet layers and
+// the OCI image.
+type Upload struct {
+	Client           *http.Client
+	MetadataLocation string
+	DropletURI       string
+	DropletLocation  string
+	CacheURI         string
+	CacheLocation    string
+	SBOMURI          string
+	SBOMLocation     string
+	LayersURI        string
+	LayersDir        string
+	// ImageDestination is the reference the image layout in ImageLayout is
+	// pushed to with RegistryClient and the registry credentials.
+	ImageDestination string
+	ImageLayout      string
+	RegistryClient   *http.Client
+	RegistryUsername string
+	RegistryPassword string
+	// ImportDroplet is set when the droplet was imported instead of staged.
+	// Only then there is no build artifacts cache to upload.
+	ImportDroplet bool
+}
+
+func (u Upload) Run() error {
+	stagingResult, err := readStagingResult(u.MetadataLocation)
+	if err != nil {
+		return fmt.Errorf("failed to read staging result: %w", err)
+	}
+
+	uploader := eirinistaging.DropletUploader{Client: u.Client}
+
+	if err = uploader.UploadWithDigests(u.DropletURI, u.DropletLocation, stagingResult.DropletDigests); err != nil {
+		return fmt.Errorf("failed to upload droplet: %w", err)
+	}
+
+	// imported droplets are not staged and do not produce a cache
+	if u.CacheURI != "" && !u.ImportDroplet {
+		if err = uploader.UploadWithDigests(u.CacheURI, u.CacheLocation, stagingResult.BuildArtifactsCacheDigests); err != nil {
+			return fmt.Errorf("failed to upload buildpack cache: %w", err)
+		}
+	}
+
+	if u.SBOMURI != "" {
+		if err = uploader.Upload(u.SBOMURI, u.SBOMLocation); err != nil {
+			return fmt.Errorf("failed to upload SBOM: %w", err)
+		}
+	}
+
+	if u.LayersDir != "" && u.LayersURI != "" {
+		if err = uploader.UploadLayers(u.LayersURI, u.LayersDir); err != nil {
+			return fmt.Errorf("failed to upload droplet layers: %w", err)
+		}
+	}
+
+	if u.ImageDestination != "" && u.ImageLayout != "" {
+		if stagingResult.Image, err = u.pushImage(); err != nil {
+			return fmt.Errorf("failed to push image: %w", err)
+		}
+
+		// the completion reports the pushed image from the staging result
+		if err = writeStagingResult(u.MetadataLocation, stagingResult); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u Upload) pushImage() (*builder.PushedImage, error) {
+	ref, err := oci.ParseReference(u.ImageDestination)
+	if err != nil {
+		return nil, err
+	}
+
+	pusher := oci.Pusher{
+		Client:   u.RegistryClient,
+		Username: u.RegistryUsername,
+		Password: u.RegistryPassword,
+	}
+
+	log.Printf("Pushing image to %s", ref)
+
+	digest, err := pusher.Push(u.ImageLayout, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Pushed image %s@%s", ref, digest)
+
+	return &builder.PushedImage{Reference: ref.String(), Digest: digest}, nil
+}
+
+// SuccessResponse prepares the completion of a staging from its result and
+// the install manifest the execution left next to it.
+func SuccessResponse(responder eirinistaging.Responder, metadataLocation string) (*models.TaskCallbackResponse, error) {
+	buildpacksJSON, err := installedBuildpacksJSON(metadataLocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read installed buildpacks: %w", err)
+	}
+
+	return responder.PrepareSuccessResponse(metadataLocation, buildpacksJSON)
+}
+
+func readStagingResult(metadataLocation string) (builder.StagingResult, error) {
+	var stagingResult builder.StagingResult
+
+	contents, err := ioutil.ReadFile(filepath.Clean(metadataLocation))
+	if err != nil {
+		return stagingResult, fmt.Errorf("failed to read %s: %w", metadataLocation, err)
+	}
+
+	if err = json.Unmarshal(contents, &stagingResult); err != nil {
+		return stagingResult, fmt.Errorf("failed to unmarshal staging result: %w", err)
+	}
+
+	return stagingResult, nil
+}
+
+func writeStagingResult(metadataLocation string, stagingResult builder.StagingResult) error {
+	contents, err := json.Marshal(stagingResult)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staging result: %w", err)
+	}
+
+	if err = ioutil.WriteFile(metadataLocation, contents, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", metadataLocation, err)
+	}
+
+	return nil
+}
+
+// installedBuildpacksJSON reads the buildpacks from the install manifest the
+// execution left next to the staging result.
+func installedBuildpacksJSON(metadataLocation string) (string, error) {
+	manifest, err := builder.ReadInstallManifest(filepath.Join(filepath.Dir(metadataLocation), builder.InstallManifestFile))
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.BuildpacksJSON()
+}
@@ -0,0 +1,237 @@
+package pipeline_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/oci"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Upload", func() {
+	var (
+		tmpDir  string
+		server  *ghttp.Server
+		uploads map[string]string
+		upload  pipeline.Upload
+		err     error
+	)
+
+	writeFile := func(name, contents string) string {
+		path := filepath.Join(tmpDir, name)
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+
+		return path
+	}
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "upload")
+		Expect(err).NotTo(HaveOccurred())
+
+		uploads = map[string]string{}
+		server = ghttp.NewServer()
+		for _, path := range []string{"/droplet", "/cache", "/sbom"} {
+			server.RouteToHandler("POST", path, func(w http.ResponseWriter, r *http.Request) {
+				body, readErr := ioutil.ReadAll(r.Body)
+				Expect(readErr).NotTo(HaveOccurred())
+				uploads[r.URL.Path] = string(body)
+			})
+		}
+
+		upload = pipeline.Upload{
+			Client:           http.DefaultClient,
+			MetadataLocation: writeFile("result.json", `{"process_types":{"web":"./app.sh"},"lifecycle_type":"buildpack"}`),
+			DropletURI:       server.URL() + "/droplet",
+			DropletLocation:  writeFile("droplet.tgz", "droplet"),
+			CacheURI:         server.URL() + "/cache",
+			CacheLocation:    writeFile("cache.tgz", "cache"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = upload.Run()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("uploads the droplet and the build artifacts cache", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uploads).To(Equal(map[string]string{"/droplet": "droplet", "/cache": "cache"}))
+	})
+
+	Context("with an SBOM upload URI", func() {
+		BeforeEach(func() {
+			upload.SBOMURI = server.URL() + "/sbom"
+			upload.SBOMLocation = writeFile("sbom.cdx.json", "{}")
+		})
+
+		It("uploads the SBOM", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(uploads).To(HaveKeyWithValue("/sbom", "{}"))
+		})
+	})
+
+	Context("with an image destination", func() {
+		var registry *ghttp.Server
+
+		BeforeEach(func() {
+			registry = ghttp.NewTLSServer()
+			registry.RouteToHandler("GET", "/v2/", ghttp.RespondWith(http.StatusOK, nil))
+			registry.RouteToHandler("HEAD", regexp.MustCompile(`^/v2/org/app/blobs/`), ghttp.RespondWith(http.StatusOK, nil))
+			registry.RouteToHandler("PUT", "/v2/org/app/manifests/v1", ghttp.RespondWith(http.StatusCreated, nil))
+
+			registryURL, parseErr := url.Parse(registry.URL())
+			Expect(parseErr).NotTo(HaveOccurred())
+
+			upload.ImageDestination = registryURL.Host + "/org/app:v1"
+			upload.RegistryClient = registry.HTTPTestServer.Client()
+		})
+
+		AfterEach(func() {
+			registry.Close()
+		})
+
+		Context("and an image layout", func() {
+			var manifest oci.Descriptor
+
+			BeforeEach(func() {
+				dropletDir := filepath.Join(tmpDir, "droplet")
+				Expect(os.MkdirAll(dropletDir, 0755)).To(Succeed())
+
+				upload.ImageLayout = filepath.Join(tmpDir, "image")
+				manifest, err = oci.WriteImage(upload.ImageLayout, "latest", oci.Config{}, oci.Layer{SourceDir: dropletDir})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("records the pushed image in the staging result", func() {
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, readErr := ioutil.ReadFile(upload.MetadataLocation)
+				Expect(readErr).NotTo(HaveOccurred())
+
+				var stagingResult builder.StagingResult
+				Expect(json.Unmarshal(contents, &stagingResult)).To(Succeed())
+				Expect(stagingResult.Image).To(Equal(&builder.PushedImage{
+					Reference: upload.ImageDestination,
+					Digest:    manifest.Digest,
+				}))
+				Expect(stagingResult.ProcessTypes).To(Equal(builder.ProcessTypes{"web": "./app.sh"}))
+			})
+		})
+
+		Context("and no image layout", func() {
+			It("does not push", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registry.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the build artifacts cache is missing", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(upload.CacheLocation)).To(Succeed())
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to upload buildpack cache")))
+		})
+
+		Context("and the droplet was imported", func() {
+			BeforeEach(func() {
+				upload.ImportDroplet = true
+			})
+
+			It("uploads only the droplet", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uploads).To(Equal(map[string]string{"/droplet": "droplet"}))
+			})
+		})
+	})
+
+	Context("when the droplet upload fails", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("POST", "/broken", ghttp.RespondWith(http.StatusInternalServerError, ""))
+			upload.DropletURI = server.URL() + "/broken"
+		})
+
+		It("fails without uploading the cache", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to upload droplet")))
+			Expect(uploads).NotTo(HaveKey("/cache"))
+		})
+	})
+
+	Context("when there is no staging result", func() {
+		BeforeEach(func() {
+			upload.MetadataLocation = filepath.Join(tmpDir, "missing.json")
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to read staging result")))
+		})
+	})
+})
+
+var _ = Describe("SuccessResponse", func() {
+	var (
+		tmpDir           string
+		metadataLocation string
+	)
+
+	result := func() builder.StagingResult {
+		resp, err := pipeline.SuccessResponse(eirinistaging.Responder{}, metadataLocation)
+		Expect(err).NotTo(HaveOccurred())
+
+		var stagingResult builder.StagingResult
+		Expect(json.Unmarshal([]byte(resp.Result), &stagingResult)).To(Succeed())
+
+		return stagingResult
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "response")
+		Expect(err).NotTo(HaveOccurred())
+
+		metadataLocation = filepath.Join(tmpDir, "result.json")
+		Expect(ioutil.WriteFile(metadataLocation, []byte(`{
+			"lifecycle_metadata": {
+				"buildpack_key": "my_buildpack",
+				"detected_buildpack": "My Buildpack",
+				"buildpacks": [{"key": "my_buildpack", "name": "My Buildpack"}]
+			},
+			"process_types": {"web": "./app.sh"},
+			"lifecycle_type": "buildpack"
+		}`), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("reports the buildpacks by the keys Cloud Controller gave them", func() {
+		manifest := builder.InstallManifest{Buildpacks: []builder.InstalledBuildpack{
+			{Buildpack: builder.Buildpack{Name: "my_buildpack", Key: "my-key"}},
+		}}
+		Expect(manifest.Write(filepath.Join(tmpDir, builder.InstallManifestFile))).To(Succeed())
+
+		Expect(result().LifecycleMetadata.BuildpackKey).To(Equal("my-key"))
+	})
+
+	It("fails without an install manifest", func() {
+		_, err := pipeline.SuccessResponse(eirinistaging.Responder{}, metadataLocation)
+		Expect(err).To(MatchError(ContainSubstring("failed to read install manifest")))
+	})
+})
@@ -109,7 +109,7 @@ func (r Responder) createFailureResponse(failure error, stagingGUID, completionC
 	return &models.TaskCallbackResponse{
 		TaskGuid:      stagingGUID,
 		Failed:        true,
-		FailureReason: failure.Error(),
+		FailureReason: failureReason(failure),
 		Annotation:    string(annotationJSON),
 	}
 }
@@ -6,16 +6,21 @@ import (
 	"errors"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"code.cloudfoundry.org/bbs/models"
 	. "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/tlsconfig"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
+	exterrors "github.com/pkg/errors"
 )
 
 var _ = Describe("Responder", func() {
@@ -116,7 +121,7 @@ var _ = Describe("Responder", func() {
 					ghttp.VerifyJSON(`{
 						"task_guid": "staging-guid",
 						"failed": true,
-						"failure_reason": "sploded",
+						"failure_reason": "StagingError: sploded",
 						"result": "",
 						"annotation": "{\"lifecycle\":\"\",\"completion_callback\":\"completion-call-me-back\"}",
 						"created_at": 0
@@ -129,6 +134,84 @@ var _ = Describe("Responder", func() {
 			})
 		})
 
+		Context("when a buildpack fails", func() {
+			BeforeEach(func() {
+				server.RouteToHandler("PUT", "/stage/staging-guid/completed",
+					ghttp.VerifyJSON(`{
+						"task_guid": "staging-guid",
+						"failed": true,
+						"failure_reason": "BuildpackCompileFailed: exit status 223 - internal error: sploded",
+						"result": "",
+						"annotation": "{\"lifecycle\":\"\",\"completion_callback\":\"completion-call-me-back\"}",
+						"created_at": 0
+					}`),
+				)
+			})
+
+			It("should respond with the Cloud Controller error ID", func() {
+				responder.RespondWithFailure(builder.NewCompileFailError(errors.New("sploded")))
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when a buildpack fails with output", func() {
+			BeforeEach(func() {
+				server.RouteToHandler("PUT", "/stage/staging-guid/completed",
+					ghttp.VerifyJSON(`{
+						"task_guid": "staging-guid",
+						"failed": true,
+						"failure_reason": "BuildpackCompileFailed: failed to create droplet: BuildpackCompileFailed: exit status 223 - internal error: sploded\n**ERROR** it broke",
+						"result": "",
+						"annotation": "{\"lifecycle\":\"\",\"completion_callback\":\"completion-call-me-back\"}",
+						"created_at": 0
+					}`),
+				)
+			})
+
+			It("should attach the output to the failure reason", func() {
+				failure := builder.NewCompileFailError(errors.New("sploded")).(builder.DescriptiveError)
+				failure.Output = "**ERROR** it broke"
+				responder.RespondWithFailure(exterrors.Wrap(failure, "failed to create droplet"))
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the failure reason is too long", func() {
+			var failureReason string
+
+			BeforeEach(func() {
+				server.RouteToHandler("PUT", "/stage/staging-guid/completed",
+					func(w http.ResponseWriter, req *http.Request) {
+						var resp models.TaskCallbackResponse
+						Expect(json.NewDecoder(req.Body).Decode(&resp)).To(Succeed())
+						failureReason = resp.FailureReason
+					},
+				)
+			})
+
+			It("should keep the error ID and the end of the output", func() {
+				failure := builder.NewCompileFailError(errors.New(strings.Repeat("é", MaxFailureReasonBytes))).(builder.DescriptiveError)
+				failure.Output = strings.Repeat("ü", MaxFailureReasonBytes) + "the cause"
+				responder.RespondWithFailure(failure)
+
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+				Expect(len(failureReason)).To(BeNumerically("<=", MaxFailureReasonBytes))
+				Expect(utf8.ValidString(failureReason)).To(BeTrue())
+				Expect(failureReason).To(HavePrefix("BuildpackCompileFailed: "))
+			})
+
+			It("should keep the end of the output when the error is short", func() {
+				failure := builder.NewCompileFailError(errors.New("sploded")).(builder.DescriptiveError)
+				failure.Output = strings.Repeat("ü", MaxFailureReasonBytes) + "the cause"
+				responder.RespondWithFailure(failure)
+
+				Expect(len(failureReason)).To(BeNumerically("<=", MaxFailureReasonBytes))
+				Expect(utf8.ValidString(failureReason)).To(BeTrue())
+				Expect(failureReason).To(HavePrefix("BuildpackCompileFailed: exit status 223"))
+				Expect(failureReason).To(HaveSuffix("üthe cause"))
+			})
+		})
+
 		Context("when the response is success", func() {
 
 			var (
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// NewHandler accepts staging requests on PUT /stage and reports the
+// pool status on GET /status.
+func NewHandler(pool *Pool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		stage(pool, w, r)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, pool.Status())
+	})
+
+	return mux
+}
+
+func stage(pool *Pool, w http.ResponseWriter, r *http.Request) {
+	var request StagingRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid staging request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		http.Error(w, "invalid staging request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err := pool.Submit(request)
+
+	switch {
+	case err == nil:
+		log.Printf("queued staging %s", request.StagingGUID)
+		writeJSON(w, http.StatusAccepted, pool.Status())
+	case errors.Is(err, ErrAlreadyStaging):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		// Eirini can fall back to a staging pod
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("failed to write response: %s", err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/eirini-staging/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		pool     *server.Pool
+		release  chan error
+		handler  http.Handler
+		recorder *httptest.ResponseRecorder
+	)
+
+	validRequest := func(guid string) string {
+		request, err := json.Marshal(server.StagingRequest{
+			StagingGUID:        guid,
+			AppBitsDownloadURI: "https://bits/app",
+			DropletUploadURI:   "https://bits/droplet",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return string(request)
+	}
+
+	serve := func(method, path, body string) {
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	}
+
+	BeforeEach(func() {
+		release = make(chan error)
+		pool = server.NewPool(1, 1, func(server.StagingRequest) error {
+			return <-release
+		})
+		handler = server.NewHandler(pool)
+	})
+
+	AfterEach(func() {
+		close(release)
+		pool.Stop()
+	})
+
+	It("accepts a staging request", func() {
+		serve("PUT", "/stage", validRequest("guid-1"))
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("rejects requests that are not JSON", func() {
+		serve("PUT", "/stage", "{")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects requests without a droplet upload URI", func() {
+		serve("PUT", "/stage", `{"staging_guid": "guid-1", "app_bits_download_uri": "https://bits/app"}`)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("droplet_upload_uri is required"))
+	})
+
+	It("rejects a staging that is already in progress", func() {
+		serve("PUT", "/stage", validRequest("guid-1"))
+		serve("PUT", "/stage", validRequest("guid-1"))
+		Expect(recorder.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("tells the client to retry elsewhere when the queue is full", func() {
+		serve("PUT", "/stage", validRequest("guid-1"))
+		Eventually(func() string { return pool.Status().Workers[0].State }).Should(Equal(server.WorkerBusy))
+		serve("PUT", "/stage", validRequest("guid-2"))
+		serve("PUT", "/stage", validRequest("guid-3"))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("reports the queue depth and worker status", func() {
+		serve("PUT", "/stage", validRequest("guid-1"))
+		Eventually(func() string { return pool.Status().Workers[0].State }).Should(Equal(server.WorkerBusy))
+		serve("PUT", "/stage", validRequest("guid-2"))
+
+		serve("GET", "/status", "")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var status server.Status
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &status)).To(Succeed())
+		Expect(status.QueueDepth).To(Equal(1))
+		Expect(status.QueueCapacity).To(Equal(1))
+		Expect(status.Workers).To(HaveLen(1))
+		Expect(status.Workers[0].State).To(Equal(server.WorkerBusy))
+		Expect(status.Workers[0].StagingGUID).To(Equal("guid-1"))
+	})
+
+	It("only reports the status on GET", func() {
+		serve("POST", "/status", "")
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
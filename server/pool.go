@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	WorkerIdle = "idle"
+	WorkerBusy = "busy"
+)
+
+var (
+	ErrQueueFull      = errors.New("staging queue is full")
+	ErrAlreadyStaging = errors.New("staging is already queued or running")
+	ErrStopped        = errors.New("staging pool is stopped")
+)
+
+// StageFunc stages the request and reports its completion.
+type StageFunc func(request StagingRequest) error
+
+// WorkerStatus is what a worker of the pool is doing.
+type WorkerStatus struct {
+	ID          int        `json:"id"`
+	State       string     `json:"state"`
+	StagingGUID string     `json:"staging_guid,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+}
+
+// Status is a snapshot of the pool.
+type Status struct {
+	QueueDepth    int            `json:"queue_depth"`
+	QueueCapacity int            `json:"queue_capacity"`
+	Workers       []WorkerStatus `json:"workers"`
+	Succeeded     int            `json:"succeeded"`
+	Failed        int            `json:"failed"`
+}
+
+// Pool stages queued requests with a fixed number of workers.
+type Pool struct {
+	stage StageFunc
+	queue chan StagingRequest
+	wg    sync.WaitGroup
+
+	mu        sync.Mutex
+	stopped   bool
+	pending   map[string]bool
+	workers   []WorkerStatus
+	succeeded int
+	failed    int
+}
+
+func NewPool(workers, queueSize int, stage StageFunc) *Pool {
+	pool := &Pool{
+		stage:   stage,
+		queue:   make(chan StagingRequest, queueSize),
+		pending: map[string]bool{},
+		workers: make([]WorkerStatus, workers),
+	}
+
+	for i := range pool.workers {
+		pool.workers[i] = WorkerStatus{ID: i, State: WorkerIdle}
+		pool.wg.Add(1)
+
+		go pool.work(i)
+	}
+
+	return pool
+}
+
+// Submit queues the request without waiting for a worker. It fails when
+// the queue is full rather than making the caller wait.
+func (p *Pool) Submit(request StagingRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+
+	if p.pending[request.StagingGUID] {
+		return ErrAlreadyStaging
+	}
+
+	select {
+	case p.queue <- request:
+		p.pending[request.StagingGUID] = true
+
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *Pool) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Status{
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		Workers:       append([]WorkerStatus{}, p.workers...),
+		Succeeded:     p.succeeded,
+		Failed:        p.failed,
+	}
+}
+
+// Stop stops accepting requests and waits for the queued ones to be staged.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *Pool) work(id int) {
+	defer p.wg.Done()
+
+	for request := range p.queue {
+		p.setWorker(id, request.StagingGUID)
+
+		err := p.run(request)
+		if err != nil {
+			log.Printf("staging %s failed: %s", request.StagingGUID, err.Error())
+		}
+
+		p.mu.Lock()
+		delete(p.pending, request.StagingGUID)
+		p.workers[id] = WorkerStatus{ID: id, State: WorkerIdle}
+
+		if err != nil {
+			p.failed++
+		} else {
+			p.succeeded++
+		}
+		p.mu.Unlock()
+	}
+}
+
+// run stages a request, turning a panic into an error so that the worker
+// survives and the staging is counted as failed.
+func (p *Pool) run(request StagingRequest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("staging %s panicked: %v\n%s", request.StagingGUID, r, debug.Stack())
+			err = fmt.Errorf("staging panicked: %v", r)
+		}
+	}()
+
+	return p.stage(request)
+}
+
+func (p *Pool) setWorker(id int, stagingGUID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	startedAt := time.Now()
+	p.workers[id] = WorkerStatus{ID: id, State: WorkerBusy, StagingGUID: stagingGUID, StartedAt: &startedAt}
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/eirini-staging/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pool", func() {
+	var (
+		pool    *server.Pool
+		release chan error
+		started chan string
+	)
+
+	request := func(guid string) server.StagingRequest {
+		return server.StagingRequest{StagingGUID: guid}
+	}
+
+	BeforeEach(func() {
+		release = make(chan error)
+		started = make(chan string, 10)
+		pool = server.NewPool(2, 1, func(request server.StagingRequest) error {
+			started <- request.StagingGUID
+
+			return <-release
+		})
+	})
+
+	AfterEach(func() {
+		close(release)
+		pool.Stop()
+	})
+
+	It("starts with idle workers and an empty queue", func() {
+		status := pool.Status()
+		Expect(status.QueueDepth).To(Equal(0))
+		Expect(status.QueueCapacity).To(Equal(1))
+		Expect(status.Workers).To(HaveLen(2))
+		Expect(status.Workers[0].State).To(Equal(server.WorkerIdle))
+		Expect(status.Workers[1].State).To(Equal(server.WorkerIdle))
+	})
+
+	It("reports the stagings the workers are busy with", func() {
+		Expect(pool.Submit(request("guid-1"))).To(Succeed())
+		Eventually(started).Should(Receive(Equal("guid-1")))
+
+		busyWorker := func() *server.WorkerStatus {
+			for _, worker := range pool.Status().Workers {
+				if worker.State == server.WorkerBusy {
+					return &worker
+				}
+			}
+
+			return nil
+		}
+
+		Eventually(busyWorker).ShouldNot(BeNil())
+		Expect(busyWorker().StagingGUID).To(Equal("guid-1"))
+		Expect(busyWorker().StartedAt).NotTo(BeNil())
+	})
+
+	It("queues requests while all workers are busy", func() {
+		Expect(pool.Submit(request("guid-1"))).To(Succeed())
+		Eventually(started).Should(Receive())
+		Expect(pool.Submit(request("guid-2"))).To(Succeed())
+		Eventually(started).Should(Receive())
+
+		Expect(pool.Submit(request("guid-3"))).To(Succeed())
+		Expect(pool.Status().QueueDepth).To(Equal(1))
+
+		By("rejecting requests once the queue is full")
+		Expect(pool.Submit(request("guid-4"))).To(MatchError(server.ErrQueueFull))
+
+		release <- nil
+		Eventually(started).Should(Receive(Equal("guid-3")))
+		Expect(pool.Status().QueueDepth).To(Equal(0))
+	})
+
+	It("rejects a staging that is already queued or running", func() {
+		Expect(pool.Submit(request("guid-1"))).To(Succeed())
+		Expect(pool.Submit(request("guid-1"))).To(MatchError(server.ErrAlreadyStaging))
+	})
+
+	It("counts succeeded and failed stagings", func() {
+		Expect(pool.Submit(request("guid-1"))).To(Succeed())
+		Eventually(started).Should(Receive())
+		release <- nil
+
+		Expect(pool.Submit(request("guid-2"))).To(Succeed())
+		Eventually(started).Should(Receive())
+		release <- errors.New("boom")
+
+		Eventually(func() int { return pool.Status().Succeeded + pool.Status().Failed }).Should(Equal(2))
+		Expect(pool.Status().Succeeded).To(Equal(1))
+		Expect(pool.Status().Failed).To(Equal(1))
+	})
+
+	It("counts a panicking staging as failed and keeps the worker", func() {
+		panicking := server.NewPool(1, 1, func(request server.StagingRequest) error {
+			if request.StagingGUID == "guid-1" {
+				panic("boom")
+			}
+
+			return nil
+		})
+		defer panicking.Stop()
+
+		Expect(panicking.Submit(request("guid-1"))).To(Succeed())
+		Eventually(func() int { return panicking.Status().Failed }).Should(Equal(1))
+		Expect(panicking.Status().Workers[0].State).To(Equal(server.WorkerIdle))
+
+		Expect(panicking.Submit(request("guid-2"))).To(Succeed())
+		Eventually(func() int { return panicking.Status().Succeeded }).Should(Equal(1))
+	})
+
+	It("accepts a staging again once it completed", func() {
+		Expect(pool.Submit(request("guid-1"))).To(Succeed())
+		Eventually(started).Should(Receive())
+		release <- nil
+
+		Eventually(func() error { return pool.Submit(request("guid-1")) }).Should(Succeed())
+	})
+})
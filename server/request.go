@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+)
+
+// StagingRequest carries what a staging pod gets through its environment.
+type StagingRequest struct {
+	StagingGUID        string                     `json:"staging_guid"`
+	CompletionCallback string                     `json:"completion_callback"`
+	AppBitsDownloadURI string                     `json:"app_bits_download_uri"`
+	DropletUploadURI   string                     `json:"droplet_upload_uri"`
+	Buildpacks         []builder.Buildpack        `json:"buildpacks"`
+	Stack              string                     `json:"stack,omitempty"`
+	StagingEnvironment builder.StagingEnvironment `json:"staging_environment,omitempty"`
+	// Raw stages the app without buildpacks and ImportDroplet stages the
+	// droplet downloaded in place of the app bits, like the
+	// EIRINI_RAW_LIFECYCLE and EIRINI_IMPORT_DROPLET modes of a staging pod.
+	Raw           bool   `json:"raw,omitempty"`
+	StartCommand  string `json:"start_command,omitempty"`
+	ImportDroplet bool   `json:"import_droplet,omitempty"`
+
+	BuildpackCacheDownloadURI       string `json:"buildpack_cache_download_uri,omitempty"`
+	BuildpackCacheChecksum          string `json:"buildpack_cache_checksum,omitempty"`
+	BuildpackCacheChecksumAlgorithm string `json:"buildpack_cache_checksum_algorithm,omitempty"`
+	BuildpackCacheUploadURI         string `json:"buildpack_cache_upload_uri,omitempty"`
+
+	DropletLayersUploadURI string `json:"droplet_layers_upload_uri,omitempty"`
+	SBOMUploadURI          string `json:"sbom_upload_uri,omitempty"`
+	// ImageDestination is the registry reference the droplet is pushed to
+	// as an OCI image.
+	ImageDestination string `json:"image_destination,omitempty"`
+}
+
+func (r StagingRequest) Validate() error {
+	switch {
+	case r.StagingGUID == "":
+		return errors.New("staging_guid is required")
+	case r.AppBitsDownloadURI == "":
+		return errors.New("app_bits_download_uri is required")
+	case r.DropletUploadURI == "":
+		return errors.New("droplet_upload_uri is required")
+	case r.BuildpackCacheDownloadURI != "" && r.BuildpackCacheChecksum == "":
+		return errors.New("buildpack_cache_checksum is required with buildpack_cache_download_uri")
+	case r.BuildpackCacheDownloadURI != "" && r.BuildpackCacheChecksumAlgorithm != pipeline.ChecksumSHA256:
+		return errors.New("buildpack_cache_checksum_algorithm must be sha256")
+	case r.Raw && r.ImportDroplet:
+		return errors.New("raw and import_droplet cannot be combined")
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/pipeline"
+	exterrors "github.com/pkg/errors"
+)
+
+const ExitReason = "failed to create droplet"
+
+// ResponderFactory creates the responder reporting the completion of a
+// staging to Eirini.
+type ResponderFactory func(stagingGUID, completionCallback string) (eirinistaging.Responder, error)
+
+// Stager runs the download, execution and upload of a request in a
+// workspace of its own, which is removed afterwards.
+//
+// Every staging runs as the user of the staging server, so buildpacks of
+// concurrent stagings could read and modify each other's workspaces. Until
+// stagings are isolated, staging-server therefore runs a single worker.
+type Stager struct {
+	WorkspaceDir string
+	// Client talks to Cloud Controller and the bits service, DefaultClient
+	// downloads buildpacks not served by them and pushes images.
+	Client        *http.Client
+	DefaultClient *http.Client
+	// Config holds the settings of all stagings, such as the compression,
+	// resource limits and hooks. The request sets the rest.
+	Config           builder.Config
+	RegistryUsername string
+	RegistryPassword string
+	NewResponder     ResponderFactory
+}
+
+type workspace struct {
+	root          string
+	buildpacksDir string
+	downloadDir   string
+	cacheDir      string
+	buildDir      string
+	outputDir     string
+}
+
+func (s Stager) Stage(request StagingRequest) error {
+	responder, err := s.NewResponder(request.StagingGUID, request.CompletionCallback)
+	if err != nil {
+		return fmt.Errorf("failed to initialize responder: %w", err)
+	}
+
+	ws, err := s.createWorkspace(request.StagingGUID)
+	if err != nil {
+		responder.RespondWithFailure(err)
+
+		return err
+	}
+	defer os.RemoveAll(ws.root)
+
+	if err = s.download(request, ws); err != nil {
+		responder.RespondWithFailure(err)
+
+		return err
+	}
+
+	conf := s.config(request, ws)
+
+	execution := pipeline.Execution{
+		Config:        conf,
+		AppBits:       filepath.Join(ws.downloadDir, eirinistaging.AppBits),
+		ImportDroplet: request.ImportDroplet,
+	}
+
+	if err = execution.Run(); err != nil {
+		err = exterrors.Wrap(err, ExitReason)
+		responder.RespondWithFailure(err)
+
+		return err
+	}
+
+	if err = s.upload(request, conf); err != nil {
+		responder.RespondWithFailure(err)
+
+		return err
+	}
+
+	resp, err := pipeline.SuccessResponse(responder, conf.OutputMetadataLocation)
+	if err != nil {
+		responder.RespondWithFailure(err)
+
+		return err
+	}
+
+	return responder.RespondWithSuccess(resp)
+}
+
+func (s Stager) createWorkspace(stagingGUID string) (workspace, error) {
+	if err := os.MkdirAll(s.WorkspaceDir, 0755); err != nil {
+		return workspace{}, fmt.Errorf("failed to create workspace dir: %w", err)
+	}
+
+	root, err := ioutil.TempDir(s.WorkspaceDir, "staging-"+stagingGUID)
+	if err != nil {
+		return workspace{}, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	ws := workspace{
+		root:          root,
+		buildpacksDir: filepath.Join(root, "buildpacks"),
+		downloadDir:   filepath.Join(root, "workspace"),
+		cacheDir:      filepath.Join(root, "cache"),
+		buildDir:      filepath.Join(root, "app"),
+		outputDir:     filepath.Join(root, "out"),
+	}
+
+	for _, dir := range []string{ws.buildpacksDir, ws.downloadDir, ws.cacheDir, ws.buildDir, ws.outputDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			os.RemoveAll(root)
+
+			return workspace{}, fmt.Errorf("failed to create workspace: %w", err)
+		}
+	}
+
+	return ws, nil
+}
+
+func (s Stager) download(request StagingRequest, ws workspace) error {
+	buildpacksJSON, err := json.Marshal(request.Buildpacks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal buildpacks: %w", err)
+	}
+
+	download := pipeline.Download{
+		Client:                 s.Client,
+		DefaultClient:          s.DefaultClient,
+		BuildpacksJSON:         string(buildpacksJSON),
+		BuildpacksDir:          ws.buildpacksDir,
+		AppBitsURI:             request.AppBitsDownloadURI,
+		WorkspaceDir:           ws.downloadDir,
+		CacheURI:               request.BuildpackCacheDownloadURI,
+		CacheDir:               ws.cacheDir,
+		CacheChecksum:          request.BuildpackCacheChecksum,
+		CacheChecksumAlgorithm: request.BuildpackCacheChecksumAlgorithm,
+	}
+
+	return download.Run()
+}
+
+// config completes the configuration shared by all stagings with the
+// request and the workspace.
+func (s Stager) config(request StagingRequest, ws workspace) builder.Config {
+	conf := s.Config
+	conf.BuildDir = ws.buildDir
+	conf.BuildpacksDir = ws.buildpacksDir
+	conf.OutputDropletLocation = filepath.Join(ws.outputDir, "droplet"+conf.Compression.Extension())
+	conf.OutputBuildArtifactsCache = filepath.Join(ws.outputDir, "cache"+conf.Compression.Extension())
+	conf.OutputMetadataLocation = filepath.Join(ws.outputDir, "result.json")
+	conf.OutputSBOMLocation = filepath.Join(ws.outputDir, "sbom.cdx.json")
+	conf.BuildArtifactsCache = ws.cacheDir
+	conf.StagingEnvironment = request.StagingEnvironment
+	conf.Stack = request.Stack
+	conf.Raw = request.Raw
+	conf.StartCommand = request.StartCommand
+	conf.OutputLayersDir = ""
+	conf.OutputImageLayout = ""
+
+	if request.DropletLayersUploadURI != "" {
+		conf.OutputLayersDir = filepath.Join(ws.outputDir, "layers")
+	}
+
+	if request.ImageDestination != "" {
+		conf.OutputImageLayout = filepath.Join(ws.outputDir, "image")
+	}
+
+	return conf
+}
+
+func (s Stager) upload(request StagingRequest, conf builder.Config) error {
+	upload := pipeline.Upload{
+		Client:           s.Client,
+		MetadataLocation: conf.OutputMetadataLocation,
+		DropletURI:       request.DropletUploadURI,
+		DropletLocation:  conf.OutputDropletLocation,
+		CacheURI:         request.BuildpackCacheUploadURI,
+		CacheLocation:    conf.OutputBuildArtifactsCache,
+		SBOMURI:          request.SBOMUploadURI,
+		SBOMLocation:     conf.OutputSBOMLocation,
+		LayersURI:        request.DropletLayersUploadURI,
+		LayersDir:        conf.OutputLayersDir,
+		ImageDestination: request.ImageDestination,
+		ImageLayout:      conf.OutputImageLayout,
+		RegistryClient:   s.DefaultClient,
+		RegistryUsername: s.RegistryUsername,
+		RegistryPassword: s.RegistryPassword,
+		ImportDroplet:    request.ImportDroplet,
+	}
+
+	return upload.Run()
+}
@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	archive_helpers "code.cloudfoundry.org/archiver/extractor/test_helper"
+	"code.cloudfoundry.org/bbs/models"
+	eirinistaging "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Stager", func() {
+	var (
+		bitsServer     *ghttp.Server
+		eiriniServer   *ghttp.Server
+		tmpDir         string
+		workspaceDir   string
+		compileScript  string
+		dropletUploads int
+		completion     models.TaskCallbackResponse
+		request        server.StagingRequest
+		stager         server.Stager
+		err            error
+	)
+
+	zipBytes := func(files []archive_helpers.ArchiveFile) []byte {
+		path := filepath.Join(tmpDir, "archive.zip")
+		archive_helpers.CreateZipArchive(path, files)
+
+		contents, readErr := ioutil.ReadFile(path)
+		Expect(readErr).NotTo(HaveOccurred())
+
+		return contents
+	}
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "stager")
+		Expect(err).NotTo(HaveOccurred())
+		workspaceDir = filepath.Join(tmpDir, "workspaces")
+
+		compileScript = "#!/bin/bash\necho compiled > $1/compiled\n"
+		dropletUploads = 0
+		completion = models.TaskCallbackResponse{}
+
+		bitsServer = ghttp.NewServer()
+		bitsServer.SetAllowUnhandledRequests(true)
+		eiriniServer = ghttp.NewServer()
+
+		eiriniServer.RouteToHandler("PUT", "/stage/guid-1/completed", func(w http.ResponseWriter, r *http.Request) {
+			Expect(json.NewDecoder(r.Body).Decode(&completion)).To(Succeed())
+		})
+
+		request = server.StagingRequest{
+			StagingGUID:        "guid-1",
+			AppBitsDownloadURI: bitsServer.URL() + "/app",
+			DropletUploadURI:   bitsServer.URL() + "/droplet",
+			Buildpacks: []builder.Buildpack{
+				{Name: "my_buildpack", Key: "my-buildpack-key", URL: bitsServer.URL() + "/buildpack.zip"},
+			},
+		}
+
+		stager = server.Stager{
+			WorkspaceDir:  workspaceDir,
+			Client:        http.DefaultClient,
+			DefaultClient: http.DefaultClient,
+			NewResponder: func(stagingGUID, completionCallback string) (eirinistaging.Responder, error) {
+				return eirinistaging.NewResponder(stagingGUID, completionCallback, eiriniServer.URL(), "", "", "")
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		appZip := zipBytes([]archive_helpers.ArchiveFile{{Name: "app.sh", Body: "echo hi", Mode: 0755}})
+		buildpackZip := zipBytes([]archive_helpers.ArchiveFile{
+			{Name: "bin/detect", Body: "#!/bin/bash\necho My Buildpack\n", Mode: 0755},
+			{Name: "bin/compile", Body: compileScript, Mode: 0755},
+			{Name: "bin/release", Body: "#!/bin/bash\necho '---'\necho 'default_process_types:'\necho '  web: ./app.sh'\n", Mode: 0755},
+		})
+
+		bitsServer.RouteToHandler("GET", "/app", ghttp.RespondWith(http.StatusOK, appZip))
+		bitsServer.RouteToHandler("GET", "/buildpack.zip", ghttp.RespondWith(http.StatusOK, buildpackZip))
+		bitsServer.RouteToHandler("POST", "/droplet", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Digest")).To(HavePrefix("SHA-256="))
+			dropletUploads++
+		})
+
+		err = stager.Stage(request)
+	})
+
+	AfterEach(func() {
+		bitsServer.Close()
+		eiriniServer.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("uploads the droplet and reports the staging result", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dropletUploads).To(Equal(1))
+		Expect(completion.TaskGuid).To(Equal("guid-1"))
+		Expect(completion.Failed).To(BeFalse())
+
+		var result builder.StagingResult
+		Expect(json.Unmarshal([]byte(completion.Result), &result)).To(Succeed())
+		Expect(result.ProcessTypes).To(HaveKeyWithValue("web", "./app.sh"))
+		Expect(result.LifecycleMetadata.BuildpackKey).To(Equal("my-buildpack-key"))
+	})
+
+	It("removes the workspace of the staging", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		workspaces, readErr := ioutil.ReadDir(workspaceDir)
+		Expect(readErr).NotTo(HaveOccurred())
+		Expect(workspaces).To(BeEmpty())
+	})
+
+	Context("when the buildpack fails to compile", func() {
+		BeforeEach(func() {
+			compileScript = "#!/bin/bash\nexit 1\n"
+		})
+
+		It("reports the failure without uploading a droplet", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(dropletUploads).To(Equal(0))
+			Expect(completion.Failed).To(BeTrue())
+			Expect(completion.FailureReason).To(HavePrefix("BuildpackCompileFailed: "))
+		})
+	})
+
+	Context("when the app bits cannot be downloaded", func() {
+		BeforeEach(func() {
+			request.AppBitsDownloadURI = bitsServer.URL() + "/missing"
+		})
+
+		It("reports the failure", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(completion.Failed).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package eirinistaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/eirini-staging/util"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// MaxFailureReasonBytes bounds the failure reason of the completion
+// callback, which Eirini passes on to Cloud Controller.
+const MaxFailureReasonBytes = 8192
+
+// failureReason is the error ID Cloud Controller maps to a message for the
+// user, followed by the error and the tail of the buildpack output that
+// explain it. Errors whose message already starts with the ID are not
+// prefixed again.
+func failureReason(failure error) string {
+	id := string(StagingErrorID(failure))
+
+	reason := failure.Error()
+	if !strings.HasPrefix(reason, id+":") {
+		reason = fmt.Sprintf("%s: %s", id, reason)
+	}
+
+	reason = util.TruncateEnd(reason, MaxFailureReasonBytes)
+
+	output := buildpackOutput(failure)
+	if room := MaxFailureReasonBytes - len(reason) - 1; output != "" && room > 0 {
+		reason += "\n" + util.TruncateStart(output, room)
+	}
+
+	return reason
+}
+
+// StagingErrorID maps a staging failure to the error ID Cloud Controller
+// shows to users. Failures that are not the app's or buildpack's fault are
+// reported as StagingError.
+func StagingErrorID(failure error) cc_messages.StagingErrorID {
+	chain := errorChain(failure)
+
+	for _, err := range chain {
+		switch e := err.(type) {
+		case builder.ResourceLimitError:
+			return cc_messages.INSUFFICIENT_RESOURCES
+		case syscall.Errno:
+			if e == syscall.ENOSPC {
+				return cc_messages.INSUFFICIENT_RESOURCES
+			}
+		}
+	}
+
+	for _, err := range chain {
+		if descriptiveErr, ok := err.(builder.DescriptiveError); ok {
+			return descriptiveErrorID(descriptiveErr)
+		}
+	}
+
+	return cc_messages.STAGING_ERROR
+}
+
+// buildpackOutput returns the summary of the buildpack output attached to
+// the failure, if any.
+func buildpackOutput(failure error) string {
+	for _, err := range errorChain(failure) {
+		var descriptiveErr builder.DescriptiveError
+		if errors.As(err, &descriptiveErr) {
+			return descriptiveErr.Output
+		}
+	}
+
+	return ""
+}
+
+func descriptiveErrorID(err builder.DescriptiveError) cc_messages.StagingErrorID {
+	switch err.ExitCode {
+	case builder.DetectFailCode:
+		return cc_messages.BUILDPACK_DETECT_FAILED
+	case builder.CompileFailCode, builder.SupplyFailCode, builder.FinalizeFailCode:
+		return cc_messages.BUILDPACK_COMPILE_FAILED
+	case builder.ReleaseFailCode:
+		return cc_messages.BUILDPACK_RELEASE_FAILED
+	case builder.StackFailCode:
+		// a buildpack that cannot run on the app's stack fails the way a
+		// buildpack checking the stack in its compile script would
+		return cc_messages.BUILDPACK_COMPILE_FAILED
+	case builder.HookFailCode:
+		// hooks are installed by the operator, so their failures are not
+		// the app's or the buildpack's fault
+		return cc_messages.STAGING_ERROR
+	default:
+		return cc_messages.STAGING_ERROR
+	}
+}
+
+// errorChain lists err and the errors it wraps, following both Unwrap and
+// the Cause method of github.com/pkg/errors.
+func errorChain(err error) []error {
+	chain := []error{}
+
+	for err != nil {
+		chain = append(chain, err)
+
+		switch wrapper := err.(type) {
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		case builder.DescriptiveError:
+			err = wrapper.InnerError
+		default:
+			err = nil
+		}
+	}
+
+	return chain
+}
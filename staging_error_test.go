@@ -0,0 +1,63 @@
+package eirinistaging_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	. "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	pkgerrors "github.com/pkg/errors"
+)
+
+var _ = Describe("StagingErrorID", func() {
+	It("reports unknown failures as StagingError", func() {
+		Expect(StagingErrorID(errors.New("sploded"))).To(Equal(cc_messages.STAGING_ERROR))
+	})
+
+	It("maps detect failures to NoAppDetectedError", func() {
+		err := builder.DescriptiveError{ExitCode: builder.DetectFailCode, Message: builder.DetectFailMsg}
+		Expect(StagingErrorID(pkgerrors.Wrap(err, "failed to create droplet"))).To(Equal(cc_messages.BUILDPACK_DETECT_FAILED))
+	})
+
+	It("maps compile, supply and finalize failures to BuildpackCompileFailed", func() {
+		Expect(StagingErrorID(builder.NewCompileFailError(errors.New("boom")))).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+		Expect(StagingErrorID(builder.NewSupplyFailError(errors.New("boom")))).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+		Expect(StagingErrorID(builder.NewFinalizeFailError(errors.New("boom")))).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+	})
+
+	It("maps release failures to BuildpackReleaseFailed", func() {
+		err := fmt.Errorf("executor: %w", builder.NewReleaseFailError(errors.New("boom")))
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.BUILDPACK_RELEASE_FAILED))
+	})
+
+	It("maps stack incompatibilities to BuildpackCompileFailed", func() {
+		err := builder.NewStackIncompatibleError(builder.StackIncompatibleError{Buildpack: "bp", Stack: "cflinuxfs4"})
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+	})
+
+	It("maps hook failures to StagingError", func() {
+		err := builder.NewHookFailError(errors.New("boom"))
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.STAGING_ERROR))
+	})
+
+	It("maps other descriptive errors to StagingError", func() {
+		err := builder.DescriptiveError{Message: builder.Unknown, ExitCode: builder.SystemFailCode}
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.STAGING_ERROR))
+	})
+
+	It("maps exceeded resource limits to InsufficientResources", func() {
+		limitErr := builder.ResourceLimitError{Buildpack: "bp", Phase: "compile", Limit: "file size limit"}
+		err := pkgerrors.Wrap(builder.NewCompileFailError(pkgerrors.Wrap(limitErr, "failed to compile droplet")), "failed to create droplet")
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.INSUFFICIENT_RESOURCES))
+	})
+
+	It("maps running out of disk space to InsufficientResources", func() {
+		err := fmt.Errorf("failed to copy app: %w", &os.PathError{Op: "write", Path: "/tmp/droplet", Err: syscall.ENOSPC})
+		Expect(StagingErrorID(err)).To(Equal(cc_messages.INSUFFICIENT_RESOURCES))
+	})
+})
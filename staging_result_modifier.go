@@ -75,6 +75,12 @@ func (m *BuildpacksKeyModifier) getProvidedBuildpacks() ([]cc_messages.Buildpack
 }
 
 func (m *BuildpacksKeyModifier) getBuildpackKey(name string, providedBuildpacks []cc_messages.Buildpack) (string, error) {
+	// imported droplets and raw apps were not staged with any of the
+	// provided buildpacks
+	if name == "" || name == builder.RawBuildpackKey {
+		return name, nil
+	}
+
 	for _, b := range providedBuildpacks {
 		if b.Name == name {
 			return b.Key, nil
@@ -97,6 +97,43 @@ var _ = Describe("BuildpacksKeyModifier", func() {
 			})
 		})
 
+		Context("When staging result has no buildpacks", func() {
+
+			BeforeEach(func() {
+				providedResult = builder.StagingResult{
+					LifecycleMetadata: builder.LifecycleMetadata{
+						DetectedBuildpack: "ruby",
+						Buildpacks:        []builder.BuildpackMetadata{},
+					},
+				}
+			})
+
+			It("should leave the staging result unchanged", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(modifiedResult).To(Equal(providedResult))
+			})
+		})
+
+		Context("When staging result has the raw buildpack", func() {
+
+			BeforeEach(func() {
+				providedResult = builder.StagingResult{
+					LifecycleMetadata: builder.LifecycleMetadata{
+						BuildpackKey:      builder.RawBuildpackKey,
+						DetectedBuildpack: builder.RawBuildpackName,
+						Buildpacks: []builder.BuildpackMetadata{
+							{Key: builder.RawBuildpackKey, Name: builder.RawBuildpackName},
+						},
+					},
+				}
+			})
+
+			It("should keep the raw buildpack key", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(modifiedResult).To(Equal(providedResult))
+			})
+		})
+
 		Context("When staging result's buildpacks metadata key  is not available in CC", func() {
 
 			BeforeEach(func() {
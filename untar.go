@@ -0,0 +1,242 @@
+package eirinistaging
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Untarrer extracts gzipped tarballs.
+type Untarrer struct {
+	UntarredSizeLimit int64
+}
+
+func (u *Untarrer) Extract(src, targetDir string) error {
+	if targetDir == "" {
+		return errors.New("target directory cannot be empty")
+	}
+
+	file, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return fmt.Errorf("failed to open tarball: %w", err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip reader: %w", err)
+	}
+	defer gzipReader.Close()
+
+	target, err := newExtractTarget(targetDir)
+	if err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read tarball: %w", err)
+		}
+
+		destPath := filepath.Join(target.dir, filepath.Clean(header.Name))
+		if !target.contains(destPath) {
+			return fmt.Errorf("tarball entry %s is outside the target directory", header.Name)
+		}
+
+		if err = target.checkResolved(filepath.Dir(destPath)); err != nil {
+			return fmt.Errorf("tarball entry %s: %w", header.Name, err)
+		}
+
+		if err = u.extractEntry(reader, header, destPath, target); err != nil {
+			return err
+		}
+	}
+}
+
+func (u *Untarrer) extractEntry(reader io.Reader, header *tar.Header, destPath string, target extractTarget) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(destPath, os.FileMode(header.Mode)); err != nil {
+			return fmt.Errorf("failed to create dir: %w", err)
+		}
+
+		return nil
+	case tar.TypeSymlink:
+		return extractSymlink(header, destPath, target)
+	case tar.TypeLink:
+		return extractHardLink(header, destPath, target)
+	case tar.TypeReg:
+		return u.extractFile(reader, header, destPath)
+	default:
+		return nil
+	}
+}
+
+// extractSymlink only creates symlinks pointing inside the target directory,
+// resolving relative targets from the real directory of the link.
+func extractSymlink(header *tar.Header, destPath string, target extractTarget) error {
+	if filepath.IsAbs(header.Linkname) {
+		return fmt.Errorf("symlink %s has an absolute target %s", header.Name, header.Linkname)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dir: %w", err)
+	}
+
+	linkDir, err := filepath.EvalSymlinks(filepath.Dir(destPath))
+	if err != nil {
+		return fmt.Errorf("failed to resolve dir of symlink %s: %w", header.Name, err)
+	}
+
+	if !target.containsResolved(filepath.Join(linkDir, header.Linkname)) {
+		return fmt.Errorf("symlink %s points outside the target directory", header.Name)
+	}
+
+	if err = removeSymlink(destPath); err != nil {
+		return err
+	}
+
+	if err = os.Symlink(header.Linkname, destPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+
+	return nil
+}
+
+// extractHardLink links destPath to an entry extracted earlier. Hard link
+// targets are relative to the root of the tarball.
+func extractHardLink(header *tar.Header, destPath string, target extractTarget) error {
+	linkPath := filepath.Join(target.dir, filepath.Clean(header.Linkname))
+	if filepath.IsAbs(header.Linkname) || !target.contains(linkPath) {
+		return fmt.Errorf("hard link %s points outside the target directory", header.Name)
+	}
+
+	if err := target.checkResolved(filepath.Dir(linkPath)); err != nil {
+		return fmt.Errorf("hard link %s: %w", header.Name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dir: %w", err)
+	}
+
+	if err := removeSymlink(destPath); err != nil {
+		return err
+	}
+
+	if err := os.Link(linkPath, destPath); err != nil {
+		return fmt.Errorf("failed to create hard link: %w", err)
+	}
+
+	return nil
+}
+
+func (u *Untarrer) extractFile(reader io.Reader, header *tar.Header, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dir: %w", err)
+	}
+
+	if err := removeSymlink(destPath); err != nil {
+		return err
+	}
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer destFile.Close()
+
+	_, err = io.CopyN(destFile, reader, u.UntarredSizeLimit)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err == nil {
+		return fmt.Errorf("extracting tarball stopped at %d limit", u.UntarredSizeLimit)
+	}
+
+	return destFile.Chmod(os.FileMode(header.Mode))
+}
+
+// removeSymlink removes a symlink extracted earlier at path, so that a later
+// entry for the same path replaces it instead of writing through it.
+func removeSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("failed to replace symlink: %w", err)
+	}
+
+	return nil
+}
+
+// extractTarget is the directory a tarball is extracted to, both as given
+// and with its symlinks resolved.
+type extractTarget struct {
+	dir     string
+	realDir string
+}
+
+func newExtractTarget(dir string) (extractTarget, error) {
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return extractTarget{}, fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return extractTarget{}, fmt.Errorf("failed to resolve target directory: %w", err)
+	}
+
+	return extractTarget{dir: dir, realDir: realDir}, nil
+}
+
+func (t extractTarget) contains(path string) bool {
+	return isWithin(t.dir, path)
+}
+
+func (t extractTarget) containsResolved(path string) bool {
+	return isWithin(t.realDir, filepath.Clean(path))
+}
+
+// checkResolved fails when dir, or the part of it that already exists,
+// resolves outside the target directory through a symlink.
+func (t extractTarget) checkResolved(dir string) error {
+	existing := dir
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == filepath.Dir(existing) {
+			break
+		}
+
+		existing = filepath.Dir(existing)
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", existing, err)
+	}
+
+	if !t.containsResolved(resolved) {
+		return errors.New("refusing to write through a symlink outside the target directory")
+	}
+
+	return nil
+}
+
+func isWithin(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
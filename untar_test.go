@@ -0,0 +1,215 @@
+package eirinistaging_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "code.cloudfoundry.org/eirini-staging"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Untarrer", func() {
+	var (
+		tmpDir    string
+		srcTgz    string
+		targetDir string
+		entries   []*tar.Header
+		contents  map[string]string
+		sizeLimit int64
+		err       error
+	)
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "untar")
+		Expect(err).NotTo(HaveOccurred())
+
+		srcTgz = filepath.Join(tmpDir, "src.tgz")
+		targetDir = filepath.Join(tmpDir, "target")
+		sizeLimit = 100000
+
+		entries = []*tar.Header{
+			{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0741},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/file"},
+		}
+		contents = map[string]string{"dir/file": "some content"}
+	})
+
+	JustBeforeEach(func() {
+		writeTgz(srcTgz, entries, contents)
+
+		extractor := &Untarrer{UntarredSizeLimit: sizeLimit}
+		err = extractor.Extract(srcTgz, targetDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("extracts files with their permissions", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		content, readErr := ioutil.ReadFile(filepath.Join(targetDir, "dir", "file"))
+		Expect(readErr).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("some content"))
+
+		info, statErr := os.Stat(filepath.Join(targetDir, "dir", "file"))
+		Expect(statErr).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0741)))
+	})
+
+	It("extracts symlinks", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Readlink(filepath.Join(targetDir, "link"))).To(Equal("dir/file"))
+	})
+
+	Context("when an entry is outside the target directory", func() {
+		BeforeEach(func() {
+			entries = append(entries, &tar.Header{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0644})
+			contents["../escaped"] = "escaped"
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("is outside the target directory")))
+			Expect(filepath.Join(tmpDir, "escaped")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when a symlink has an absolute target", func() {
+		BeforeEach(func() {
+			entries = append(entries, &tar.Header{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("symlink passwd has an absolute target /etc/passwd")))
+			Expect(filepath.Join(targetDir, "passwd")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when a symlink points outside the target directory", func() {
+		BeforeEach(func() {
+			entries = append(entries, &tar.Header{Name: "dir/up", Typeflag: tar.TypeSymlink, Linkname: "../../outside"})
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("symlink dir/up points outside the target directory")))
+		})
+	})
+
+	Context("when an entry is written through a symlink to a directory outside the target directory", func() {
+		BeforeEach(func() {
+			outsideDir := filepath.Join(tmpDir, "outside")
+			Expect(os.MkdirAll(outsideDir, 0755)).To(Succeed())
+			Expect(os.MkdirAll(targetDir, 0755)).To(Succeed())
+			Expect(os.Symlink(outsideDir, filepath.Join(targetDir, "escape"))).To(Succeed())
+
+			entries = append(entries, &tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0644})
+			contents["escape/file"] = "escaped"
+		})
+
+		It("fails without writing the file", func() {
+			Expect(err).To(MatchError(ContainSubstring("refusing to write through a symlink outside the target directory")))
+			Expect(filepath.Join(tmpDir, "outside", "file")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when a later entry replaces a symlink", func() {
+		BeforeEach(func() {
+			entries = append(entries, &tar.Header{Name: "link", Typeflag: tar.TypeReg, Mode: 0644})
+			contents["link"] = "replaced"
+		})
+
+		It("replaces the symlink instead of writing through it", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			content, readErr := ioutil.ReadFile(filepath.Join(targetDir, "dir", "file"))
+			Expect(readErr).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("some content"))
+
+			content, readErr = ioutil.ReadFile(filepath.Join(targetDir, "link"))
+			Expect(readErr).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("replaced"))
+		})
+	})
+
+	Context("with a hard link", func() {
+		BeforeEach(func() {
+			entries = append(entries, &tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file"})
+		})
+
+		It("links it to the earlier entry", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			content, readErr := ioutil.ReadFile(filepath.Join(targetDir, "hardlink"))
+			Expect(readErr).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("some content"))
+
+			linkInfo, statErr := os.Lstat(filepath.Join(targetDir, "hardlink"))
+			Expect(statErr).NotTo(HaveOccurred())
+			fileInfo, statErr := os.Lstat(filepath.Join(targetDir, "dir", "file"))
+			Expect(statErr).NotTo(HaveOccurred())
+			Expect(os.SameFile(linkInfo, fileInfo)).To(BeTrue())
+		})
+
+		Context("when it points outside the target directory", func() {
+			BeforeEach(func() {
+				entries = append(entries, &tar.Header{Name: "escaped-hardlink", Typeflag: tar.TypeLink, Linkname: "../outside"})
+			})
+
+			It("fails", func() {
+				Expect(err).To(MatchError(ContainSubstring("hard link escaped-hardlink points outside the target directory")))
+			})
+		})
+	})
+
+	Context("when a file exceeds the size limit", func() {
+		BeforeEach(func() {
+			sizeLimit = 4
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError("extracting tarball stopped at 4 limit"))
+		})
+	})
+
+	Context("when the file is not a gzipped tarball", func() {
+		JustBeforeEach(func() {
+			Expect(ioutil.WriteFile(srcTgz, []byte("not a tarball"), 0644)).To(Succeed())
+
+			extractor := &Untarrer{UntarredSizeLimit: sizeLimit}
+			err = extractor.Extract(srcTgz, targetDir)
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to open gzip reader")))
+		})
+	})
+})
+
+func writeTgz(path string, entries []*tar.Header, contents map[string]string) {
+	file, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, header := range entries {
+		content := ""
+		if header.Typeflag == tar.TypeReg {
+			content = contents[header.Name]
+		}
+		header.Size = int64(len(content))
+
+		Expect(tarWriter.WriteHeader(header)).To(Succeed())
+		_, err = tarWriter.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	Expect(tarWriter.Close()).To(Succeed())
+	Expect(gzipWriter.Close()).To(Succeed())
+}
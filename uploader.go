@@ -1,12 +1,17 @@
 package eirinistaging
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"code.cloudfoundry.org/eirini-staging/builder"
 	"github.com/pkg/errors"
 )
 
@@ -25,10 +30,84 @@ func (u *DropletUploader) Upload(
 		return errors.New("empty url parameter")
 	}
 
-	return u.uploadFile(dropletLocation, dropletUploadURL)
+	return u.uploadFile(dropletLocation, dropletUploadURL, nil)
 }
 
-func (u *DropletUploader) uploadFile(fileLocation, url string) error {
+// UploadWithDigests uploads a file like Upload, sending its digests in a
+// Digest header (RFC 3230) so that the receiver can verify the upload.
+func (u *DropletUploader) UploadWithDigests(
+	uploadURL string,
+	fileLocation string,
+	digests *builder.Digests,
+) error {
+	if fileLocation == "" {
+		return errors.New("empty path parameter")
+	}
+	if uploadURL == "" {
+		return errors.New("empty url parameter")
+	}
+
+	return u.uploadFile(fileLocation, uploadURL, digests)
+}
+
+// UploadLayers uploads the layers listed in the layers manifest in
+// layersDir to layersUploadURL/<digest>, skipping layers the blobstore
+// already has.
+func (u *DropletUploader) UploadLayers(layersUploadURL, layersDir string) error {
+	if layersUploadURL == "" {
+		return errors.New("empty url parameter")
+	}
+
+	manifest, err := builder.ReadLayersManifest(layersDir)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		layerURL := fmt.Sprintf("%s/%s", strings.TrimRight(layersUploadURL, "/"), layer.Digest)
+
+		exists, err := u.exists(layerURL)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check for %s layer", layer.Name)
+		}
+
+		if exists {
+			log.Printf("Skipping upload of %s layer %s: already present", layer.Name, layer.Digest)
+
+			continue
+		}
+
+		if err = u.uploadFile(builder.LayerPath(layersDir, layer), layerURL, nil); err != nil {
+			return errors.Wrapf(err, "failed to upload %s layer", layer.Name)
+		}
+	}
+
+	return nil
+}
+
+func (u *DropletUploader) exists(url string) (bool, error) {
+	request, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	resp, err := u.Client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+}
+
+func (u *DropletUploader) uploadFile(fileLocation, url string, digests *builder.Digests) error {
 	sourceFile, err := os.Open(filepath.Clean(fileLocation))
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
@@ -45,12 +124,60 @@ func (u *DropletUploader) uploadFile(fileLocation, url string) error {
 		return err
 	}
 
+	contentType, err := contentType(fileLocation)
+	if err != nil {
+		return err
+	}
+
 	request.ContentLength = contentLength
-	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("Content-Type", contentType)
+
+	if digests != nil {
+		digestHeader, err := digestHeader(digests)
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set("Digest", digestHeader)
+	}
 
 	return u.do(request)
 }
 
+// contentType is application/zstd for zstd compressed droplets, caches and
+// layers, and application/octet-stream for anything else, including gzip.
+func contentType(fileLocation string) (string, error) {
+	format, err := builder.DetectFormat(fileLocation)
+	if err != nil {
+		return "", err
+	}
+
+	if format != builder.FormatZstd {
+		return "application/octet-stream", nil
+	}
+
+	return builder.Compression{Codec: format}.MediaType(), nil
+}
+
+// digestHeader formats digests as the base64 encoded instance digests of a
+// Digest header.
+func digestHeader(digests *builder.Digests) (string, error) {
+	sha256Sum, err := hex.DecodeString(digests.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("invalid sha256 digest: %w", err)
+	}
+
+	sha1Sum, err := hex.DecodeString(digests.SHA1)
+	if err != nil {
+		return "", fmt.Errorf("invalid sha1 digest: %w", err)
+	}
+
+	return fmt.Sprintf("SHA-256=%s,SHA=%s",
+		base64.StdEncoding.EncodeToString(sha256Sum),
+		base64.StdEncoding.EncodeToString(sha1Sum),
+	), nil
+}
+
 func fileSize(file *os.File) (int64, error) {
 	fileInfo, err := file.Stat()
 	if err != nil {
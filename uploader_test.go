@@ -1,10 +1,15 @@
 package eirinistaging_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	. "code.cloudfoundry.org/eirini-staging"
+	"code.cloudfoundry.org/eirini-staging/builder"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
@@ -107,3 +112,217 @@ var _ = Describe("Uploader", func() {
 
 	})
 })
+
+var _ = Describe("Upload content type", func() {
+	var (
+		server   *ghttp.Server
+		uploader *DropletUploader
+		tmpDir   string
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		uploader = &DropletUploader{Client: &http.Client{}}
+
+		var err error
+		tmpDir, err = ioutil.TempDir("", "upload")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("sends zstd files with their media type and anything else as octet-stream", func() {
+		for _, artifact := range []struct {
+			contents  []byte
+			mediaType string
+		}{
+			{contents: []byte{0x1f, 0x8b, 0x08, 0x00}, mediaType: "application/octet-stream"},
+			{contents: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, mediaType: "application/zstd"},
+		} {
+			path := filepath.Join(tmpDir, "droplet")
+			Expect(ioutil.WriteFile(path, artifact.contents, 0644)).To(Succeed())
+
+			server.RouteToHandler("POST", "/droplet", ghttp.VerifyContentType(artifact.mediaType))
+			Expect(uploader.Upload(server.URL()+"/droplet", path)).To(Succeed())
+		}
+	})
+})
+
+var _ = Describe("UploadWithDigests", func() {
+	var (
+		server   *ghttp.Server
+		uploader *DropletUploader
+		digests  *builder.Digests
+		err      error
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		uploader = &DropletUploader{Client: &http.Client{}}
+		digests = &builder.Digests{
+			SHA256: "f5e1e5f38a10cbd1d4cbd8f6c4e0a8e2e8f1f0bbcf0cfbd4b0b8c2f2b6c0a0d1",
+			SHA1:   "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		err = uploader.UploadWithDigests(server.URL()+"/droplet", "testdata/file.notzip", digests)
+	})
+
+	Context("when digests are provided", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/droplet"),
+				ghttp.VerifyHeaderKV("Digest", "SHA-256=9eHl84oQy9HUy9j2xOCo4ujx8LvPDPvUsLjC8rbAoNE=,SHA=L9ThxnotKPzthJ7hu3bnORuT6xI="),
+				ghttp.VerifyBody([]byte("This is definitely not a zip.\n")),
+			))
+		})
+
+		It("sends them in the Digest header", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when no digests are provided", func() {
+		BeforeEach(func() {
+			digests = nil
+			server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Digest")).To(BeEmpty())
+			})
+		})
+
+		It("does not send a Digest header", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when a digest is not valid hex", func() {
+		BeforeEach(func() {
+			digests.SHA1 = "not-hex"
+		})
+
+		It("returns an error without uploading", func() {
+			Expect(err).To(MatchError(ContainSubstring("invalid sha1 digest")))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("UploadLayers", func() {
+	var (
+		server    *ghttp.Server
+		uploader  *DropletUploader
+		layersDir string
+		err       error
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+
+		layersDir, err = ioutil.TempDir("", "layers")
+		Expect(err).NotTo(HaveOccurred())
+
+		manifest := builder.LayersManifest{Layers: []builder.DropletLayer{
+			{Name: "deps", Digest: "sha256:aaaa", Size: 4, MediaType: "application/gzip"},
+			{Name: "app", Digest: "sha256:bbbb", Size: 3, MediaType: "application/gzip"},
+		}}
+		manifestJSON, marshalErr := json.Marshal(manifest)
+		Expect(marshalErr).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(layersDir, builder.LayersManifestFile), manifestJSON, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(builder.LayerPath(layersDir, manifest.Layers[0]), []byte("deps"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(builder.LayerPath(layersDir, manifest.Layers[1]), []byte("app"), 0644)).To(Succeed())
+
+		uploader = &DropletUploader{Client: &http.Client{}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(layersDir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		err = uploader.UploadLayers(fmt.Sprintf("%s/layers", server.URL()), layersDir)
+	})
+
+	Context("when the blobstore has none of the layers", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("HEAD", "/layers/sha256:aaaa"),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/layers/sha256:aaaa"),
+					ghttp.VerifyBody([]byte("deps")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("HEAD", "/layers/sha256:bbbb"),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/layers/sha256:bbbb"),
+					ghttp.VerifyBody([]byte("app")),
+				),
+			)
+		})
+
+		It("uploads all layers", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Context("when the blobstore already has a layer", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("HEAD", "/layers/sha256:aaaa"),
+					ghttp.RespondWith(http.StatusOK, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("HEAD", "/layers/sha256:bbbb"),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/layers/sha256:bbbb"),
+					ghttp.VerifyBody([]byte("app")),
+				),
+			)
+		})
+
+		It("skips it", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("when checking for a layer fails", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to check for deps layer")))
+		})
+	})
+
+	Context("when the layers manifest is missing", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(layersDir, builder.LayersManifestFile))).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to read layers manifest")))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,31 @@
+package util
+
+import "unicode/utf8"
+
+// TruncateEnd keeps the first max bytes of s without splitting a rune.
+func TruncateEnd(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	end := max
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+
+	return s[:end]
+}
+
+// TruncateStart keeps the last max bytes of s without splitting a rune.
+func TruncateStart(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	start := len(s) - max
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+
+	return s[start:]
+}
@@ -0,0 +1,43 @@
+package util_test
+
+import (
+	"unicode/utf8"
+
+	"code.cloudfoundry.org/eirini-staging/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Strings", func() {
+	Describe("TruncateEnd", func() {
+		It("leaves short strings alone", func() {
+			Expect(util.TruncateEnd("abc", 3)).To(Equal("abc"))
+		})
+
+		It("keeps the first bytes", func() {
+			Expect(util.TruncateEnd("abcdef", 4)).To(Equal("abcd"))
+		})
+
+		It("does not split a rune", func() {
+			truncated := util.TruncateEnd("aéé", 4)
+			Expect(truncated).To(Equal("aé"))
+			Expect(utf8.ValidString(truncated)).To(BeTrue())
+		})
+	})
+
+	Describe("TruncateStart", func() {
+		It("leaves short strings alone", func() {
+			Expect(util.TruncateStart("abc", 3)).To(Equal("abc"))
+		})
+
+		It("keeps the last bytes", func() {
+			Expect(util.TruncateStart("abcdef", 4)).To(Equal("cdef"))
+		})
+
+		It("does not split a rune", func() {
+			truncated := util.TruncateStart("ééa", 4)
+			Expect(truncated).To(Equal("éa"))
+			Expect(utf8.ValidString(truncated)).To(BeTrue())
+		})
+	})
+})